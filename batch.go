@@ -2,9 +2,9 @@ package kvproject
 
 import (
 	"bitcask-go/data"
+	"bitcask-go/index"
 	"encoding/binary"
 	"sync"
-	"sync/atomic"
 )
 
 // For the data written in not by transaction, give them a specific seqNo
@@ -22,14 +22,6 @@ type WriteBatch struct {
 
 // Initialize write batch
 func (db *DB) NewWriteBatch(opts WriteBatchOptions) *WriteBatch {
-	if db.options.IndexType == BPlusTree && !db.seqNoFileExists && !db.isinitial{
-		// Cannot get the latest seqNo
-		// Forbid WriteBatch
-		// It is possible that user uses B+ tree when it is the first time to initialize the database. 
-		// If there's no isinitial to judge, because there's no seqNoFile, panic
-		panic("cannot use write batch, seq no file not exists")
-	}
-
 	return &WriteBatch{
 		options: opts,
 		mu: new(sync.Mutex),
@@ -92,14 +84,35 @@ func (wb *WriteBatch) Commit() error {
 	wb.db.mu.Lock()
 	defer wb.db.mu.Unlock()
 
+	records := make([]*data.LogRecord, 0, len(wb.pendingWrites))
+	for _, record := range wb.pendingWrites {
+		records = append(records, record)
+	}
+	if err := wb.db.commitTxn(records, wb.options.SyncWrites); err != nil {
+		return err
+	}
+
+	// Clear pendingWrites to enable next commit
+	wb.pendingWrites = make(map[string]*data.LogRecord)
+
+	return nil
+}
+
+// Write records atomically as a transaction, then update the in-memory indexer
+// The keys of records are real keys, the type is LogRecordNormal or LogRecordDeleted
+// Must have lock when using this method
+func (db *DB) commitTxn(records []*data.LogRecord, sync bool) error {
 	// Get current transaction serial number
-	seqNo := atomic.AddUint64(&wb.db.seqNo, 1)
+	seqNo, err := db.nextSeqNo()
+	if err != nil {
+		return err
+	}
 	
 	// Write data to the data file
-	positions := make(map[string]*data.LogRecordPos)
-	for _, record := range wb.pendingWrites {
-		// Because the function has already added lock at the beginning, there is no need here
-		logRecordPos, err := wb.db.appendLogRecord(&data.LogRecord{
+	positions := make([]*data.LogRecordPos, len(records))
+	for i, record := range records {
+		// Because the caller has already added lock, there is no need here
+		logRecordPos, err := db.appendLogRecord(&data.LogRecord{
 			Key: logRecordKeyWithSeq(record.Key, seqNo),
 			Value: record.Value,
 			Type: record.Type,
@@ -107,7 +120,7 @@ func (wb *WriteBatch) Commit() error {
 		if err != nil {
 			return err
 		}
-		positions[string(record.Key)] = logRecordPos
+		positions[i] = logRecordPos
 	}
 
 	// A signal of the completion of the transaction
@@ -115,36 +128,39 @@ func (wb *WriteBatch) Commit() error {
 		Key: logRecordKeyWithSeq(txnFinKey, seqNo),
 		Type: data.LogRecordFinished,
 	}
-	if _, err := wb.db.appendLogRecord(finishedRecord); err != nil {
+	finishedPos, err := db.appendLogRecord(finishedRecord)
+	if err != nil {
 		return err
 	}
 	
 	// Determine whether to persist based on the option
-	if wb.options.SyncWrites && wb.db.activeFile != nil {
-		if err := wb.db.activeFile.Sync(); err != nil {
+	if sync && db.activeFile != nil {
+		if err := db.activeFile.Sync(); err != nil {
+			// Drop the finished record, so the transaction is not committed in the data file either
+			_ = db.activeFile.SetWriteOff(finishedPos.Offset)
 			return err
 		}
 	}
 
 	// All the data of the transaction have been written to the data file
-	// Update the in-memory indexer
-	for _, record := range wb.pendingWrites {
-		pos := positions[string(record.Key)]
-		var oldPos *data.LogRecordPos
+	// Update the indexer in one batch, readers never see a part of the transaction
+	ops := make([]index.BatchOp, len(records))
+	for i, record := range records {
+		ops[i] = index.BatchOp{Key: record.Key}
 		if record.Type == data.LogRecordNormal {
-			oldPos = wb.db.index.Put(record.Key, pos)
-		}
-		if record.Type == data.LogRecordDeleted {
-			oldPos, _ = wb.db.index.Delete(record.Key)
+			ops[i].Pos = positions[i]
+		} else {
+			// The deleted data can be reclaimed
+			db.reclaimSize += int64(positions[i].Size)
 		}
+	}
+	db.markIndexApplied(finishedPos)
+	for _, oldPos := range db.index.ApplyBatch(ops) {
 		if oldPos != nil {
-			wb.db.reclaimSize += int64(oldPos.Size)
+			db.reclaimSize += int64(oldPos.Size)
 		}
 	}
 
-	// Clear pendingWrites to enable next commit
-	wb.pendingWrites = make(map[string]*data.LogRecord)
-
 	return nil
 }
 
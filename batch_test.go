@@ -1,8 +1,11 @@
 package kvproject
 
 import (
+	"bitcask-go/data"
 	"bitcask-go/utils"
+	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -107,4 +110,63 @@ func TestDB_WriteBatch3(t *testing.T) {
 	}
 	err = wb.Commit()
 	assert.Nil(t, err)
-}
\ No newline at end of file
+}
+// Release the database without saving anything, as if the process crashed
+func crashDB(t *testing.T, db *DB) {
+	assert.Nil(t, db.index.Close())
+	assert.Nil(t, db.activeFile.Close())
+	for _, file := range db.olderFiles {
+		assert.Nil(t, file.Close())
+	}
+	assert.Nil(t, db.fileLock.Unlock())
+}
+
+// seqNo is saved ahead, write batch works after a crash with every index type
+func TestDB_WriteBatchAfterCrash(t *testing.T) {
+	for _, indexType := range []IndexerType{Btree, BPlusTree} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-batch-crash")
+		opts.DirPath = dir
+		opts.IndexType = indexType
+		db, err := Open(opts)
+		assert.Nil(t, err)
+
+		wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+		err = wb.Put(utils.GetTestKey(1), []byte("value-1"))
+		assert.Nil(t, err)
+		err = wb.Commit()
+		assert.Nil(t, err)
+		usedSeqNo := db.seqNo
+		crashDB(t, db)
+
+		db2, err := Open(opts)
+		assert.Nil(t, err)
+		assert.GreaterOrEqual(t, db2.seqNo, usedSeqNo)
+		wb2 := db2.NewWriteBatch(DefaultWriteBatchOptions)
+		err = wb2.Put(utils.GetTestKey(2), []byte("value-2"))
+		assert.Nil(t, err)
+		err = wb2.Commit()
+		assert.Nil(t, err)
+		assert.Greater(t, db2.seqNo, usedSeqNo)
+		usedSeqNo = db2.seqNo
+		crashDB(t, db2)
+
+		// Older versions delete the seq-no file when opening, then seqNo is found in the data files
+		err = opts.FS.Remove(filepath.Join(dir, data.SeqNoFileName))
+		assert.Nil(t, err)
+		db3, err := Open(opts)
+		assert.Nil(t, err)
+		assert.Equal(t, usedSeqNo, db3.seqNo)
+		wb3 := db3.NewWriteBatch(DefaultWriteBatchOptions)
+		err = wb3.Put(utils.GetTestKey(3), []byte("value-3"))
+		assert.Nil(t, err)
+		err = wb3.Commit()
+		assert.Nil(t, err)
+		for i := 1; i <= 3; i++ {
+			value, err := db3.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte(fmt.Sprintf("value-%d", i)), value)
+		}
+		destroyDB(db3)
+	}
+}
@@ -69,4 +69,127 @@ func Benchmark_Delete(b *testing.B) {
 		err := db.Delete(utils.GetTestKey(rand.Int()))
 		assert.Nil(b, err)
 	}
-}
\ No newline at end of file
+}
+func Benchmark_MultiGet(b *testing.B) {
+	for i := 0; i < 10000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(b, err)
+	}
+
+	rand.Seed(time.Now().UnixNano())
+	// Get 100 keys every time
+	keys := make([][]byte, 100)
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for j := range keys {
+			keys[j] = utils.GetTestKey(rand.Intn(10000))
+		}
+		_, _, err := db.MultiGet(keys)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Compare the IO types of the active file with small records
+func Benchmark_Put_ActiveFileIOType(b *testing.B) {
+	ioTypes := map[string]kvproject.FileIOType{
+		"StandardIO": kvproject.StandardIO,
+		"MMapIO": kvproject.MMapIO,
+		"BufferedIO": kvproject.BufferedIO,
+		"PreallocatedIO": kvproject.PreallocatedIO,
+		"DirectIO": kvproject.DirectIO,
+	}
+	for name, ioType := range ioTypes {
+		b.Run(name, func(b *testing.B) {
+			options := kvproject.DefaultOptions
+			dir, _ := os.MkdirTemp("", "bitcask-go-bench-io")
+			options.DirPath = dir
+			options.ActiveFileIOType = ioType
+			options.DataFileSize = 64 * 1024 * 1024
+			db, err := kvproject.Open(options)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer func() {
+				_ = db.Close()
+				_ = os.RemoveAll(dir)
+			}()
+
+			value := utils.RandomValue(128)
+			b.ResetTimer()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				err := db.Put(utils.GetTestKey(i), value)
+				assert.Nil(b, err)
+			}
+		})
+	}
+}
+
+// Parallel Gets while another goroutine keeps writing with SyncWrite
+// The Gets of Btree wait for the lock of the database held during the write, Skiplist and Hash don't
+func Benchmark_ParallelGet_WithPut(b *testing.B) {
+	indexTypes := []struct {
+		name string
+		typ kvproject.IndexerType
+	}{
+		{"Btree", kvproject.Btree},
+		{"Skiplist", kvproject.Skiplist},
+		{"Hash", kvproject.Hash},
+	}
+	for _, indexType := range indexTypes {
+		b.Run(indexType.name, func(b *testing.B) {
+			options := kvproject.DefaultOptions
+			dir, _ := os.MkdirTemp("", "bitcask-go-bench-parallel")
+			options.DirPath = dir
+			options.IndexType = indexType.typ
+			options.SyncWrite = true
+			db, err := kvproject.Open(options)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer func() {
+				_ = db.Close()
+				_ = os.RemoveAll(dir)
+			}()
+			for i := 0; i < 10000; i++ {
+				err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+				assert.Nil(b, err)
+			}
+
+			stop := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				value := utils.RandomValue(128)
+				for i := 0; ; i++ {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					_ = db.Put(utils.GetTestKey(i % 10000), value)
+				}
+			}()
+
+			b.ResetTimer()
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				r := rand.New(rand.NewSource(time.Now().UnixNano()))
+				for pb.Next() {
+					_, err := db.Get(utils.GetTestKey(r.Intn(10000)))
+					if err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+			b.StopTimer()
+			close(stop)
+			<-done
+		})
+	}
+}
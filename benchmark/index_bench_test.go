@@ -0,0 +1,152 @@
+package benchmark
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"bitcask-go/utils"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+// Compare the index types without the data files
+var indexTypes = map[string]index.IndexType{
+	"Btree": index.Btree,
+	"ART": index.ART,
+	"BPTree": index.BPTree,
+	"Hybrid": index.Hybrid,
+	"Skiplist": index.Skiplist,
+	"Hash": index.Hash,
+}
+
+const benchIndexKeys = 100000
+
+func newBenchIndexer(b *testing.B, typ index.IndexType) (index.Indexer, func()) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-bench-index")
+	indexer, err := index.NewIndexer(typ, dir, false, 256 * 1024 * 1024)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return indexer, func() {
+		_ = indexer.Close()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+// Fill the index with benchIndexKeys keys in one batch
+func fillBenchIndexer(indexer index.Indexer) {
+	ops := make([]index.BatchOp, benchIndexKeys)
+	for i := range ops {
+		ops[i] = index.BatchOp{Key: utils.GetTestKey(i), Pos: &data.LogRecordPos{Fid: 1, Offset: int64(i)}}
+	}
+	indexer.ApplyBatch(ops)
+}
+
+func Benchmark_Index_Put(b *testing.B) {
+	for name, typ := range indexTypes {
+		b.Run(name, func(b *testing.B) {
+			indexer, cleanup := newBenchIndexer(b, typ)
+			defer cleanup()
+
+			pos := &data.LogRecordPos{Fid: 1, Offset: 100}
+			b.ResetTimer()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				indexer.Put(utils.GetTestKey(i), pos)
+			}
+		})
+	}
+}
+
+func Benchmark_Index_Get(b *testing.B) {
+	for name, typ := range indexTypes {
+		b.Run(name, func(b *testing.B) {
+			indexer, cleanup := newBenchIndexer(b, typ)
+			defer cleanup()
+			fillBenchIndexer(indexer)
+
+			r := rand.New(rand.NewSource(1))
+			b.ResetTimer()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				indexer.Get(utils.GetTestKey(r.Intn(benchIndexKeys)))
+			}
+		})
+	}
+}
+
+// Keys not in the index, the B+ tree answers most of them from its Bloom filter
+func Benchmark_Index_GetMissing(b *testing.B) {
+	for name, typ := range indexTypes {
+		b.Run(name, func(b *testing.B) {
+			indexer, cleanup := newBenchIndexer(b, typ)
+			defer cleanup()
+			fillBenchIndexer(indexer)
+
+			r := rand.New(rand.NewSource(1))
+			b.ResetTimer()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				indexer.Get(utils.GetTestKey(benchIndexKeys + r.Intn(benchIndexKeys)))
+			}
+		})
+	}
+}
+
+// Many readers and one writer, readers of the skiplist don't wait for the writer
+func Benchmark_Index_ParallelGet_WithPut(b *testing.B) {
+	for name, typ := range indexTypes {
+		b.Run(name, func(b *testing.B) {
+			indexer, cleanup := newBenchIndexer(b, typ)
+			defer cleanup()
+			fillBenchIndexer(indexer)
+
+			stop := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				pos := &data.LogRecordPos{Fid: 2, Offset: 100}
+				for i := 0; ; i++ {
+					select {
+					case <-stop:
+						return
+					default:
+						indexer.Put(utils.GetTestKey(i % benchIndexKeys), pos)
+					}
+				}
+			}()
+
+			b.ResetTimer()
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				r := rand.New(rand.NewSource(rand.Int63()))
+				for pb.Next() {
+					indexer.Get(utils.GetTestKey(r.Intn(benchIndexKeys)))
+				}
+			})
+			b.StopTimer()
+			close(stop)
+			<-done
+		})
+	}
+}
+
+func Benchmark_Index_Iterate(b *testing.B) {
+	for name, typ := range indexTypes {
+		b.Run(name, func(b *testing.B) {
+			indexer, cleanup := newBenchIndexer(b, typ)
+			defer cleanup()
+			fillBenchIndexer(indexer)
+
+			b.ResetTimer()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				iter := indexer.Iterator(false)
+				for iter.Rewind(); iter.Valid(); iter.Next() {
+					_ = iter.Value()
+				}
+				iter.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package kvproject
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"sort"
+)
+
+// B+ tree saves the index on the disk, so it is not loaded from the data files when opening
+// But the index may miss the records appended before a crash, or point to the files replaced by merge
+// The end of the records applied is saved in the B+ tree as the high-water mark
+// When opening, the records after the mark are applied again. Applying a record twice doesn't change the index
+
+// Remember that the records up to the end of pos are applied to the index
+// The B+ tree saves it with the next change of the index
+// Must have lock when using this method
+func (db *DB) markIndexApplied(pos *data.LogRecordPos) {
+	if bptree, ok := db.index.(*index.BPlusTree); ok {
+		bptree.SetHighWaterMark(&data.LogRecordPos{Fid: pos.Fid, Offset: pos.Offset + int64(pos.Size)})
+	}
+}
+
+// Apply the records written after the high-water mark of the B+ tree
+// If the mark cannot be trusted, the index is rebuilt from the hint file and all the data files
+func (db *DB) reconcileBPlusTree() error {
+	bptree := db.index.(*index.BPlusTree)
+	mark := bptree.HighWaterMark()
+	valid, err := db.isValidIndexMark(mark)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		bptree.DeleteRange(nil, nil)
+		if err := db.loadIndexFromHintFile(); err != nil {
+			return err
+		}
+		mark = nil
+	}
+	if err := db.loadIndexFromDataFiles(mark); err != nil {
+		return err
+	}
+
+	// All the records are in the index now
+	if db.activeFile != nil {
+		db.markIndexApplied(&data.LogRecordPos{Fid: db.activeFile.FileId, Offset: db.activeFile.WriteOff})
+	}
+	return nil
+}
+
+// The mark can be trusted if the records before it are still in the data files not merged
+// Records of the files replaced by merge are only in the merged files, they cannot be applied from the mark
+// The mark is beyond the end of the data files if they lost the records the index has
+func (db *DB) isValidIndexMark(mark *data.LogRecordPos) (bool, error) {
+	// Written by an older version, or the index file is new
+	if mark == nil {
+		return false, nil
+	}
+	if mark.Fid < db.manifest.nonMergeFileId {
+		return false, nil
+	}
+	i := sort.SearchInts(db.fileIds, int(mark.Fid))
+	if i == len(db.fileIds) || db.fileIds[i] != int(mark.Fid) {
+		return false, nil
+	}
+
+	dataFile := db.getDataFile(mark.Fid)
+	end, err := dataFile.RecordsEnd()
+	if err != nil {
+		return false, err
+	}
+	return mark.Offset <= end, nil
+}
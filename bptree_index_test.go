@@ -0,0 +1,112 @@
+package kvproject
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"bitcask-go/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Write to the data files without updating the B+ tree index, as a crash before the index is saved does
+func writeWithoutBPlusTree(t *testing.T, opts Options, fn func(db *DB)) {
+	opts.IndexType = Btree
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	fn(db)
+	err = db.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_ReconcileBPlusTree(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-reconcile-bptree")
+	opts.DirPath = dir
+	opts.IndexType = BPlusTree
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	expected := make(map[string][]byte)
+	for i := 0; i < 500; i++ {
+		value := utils.RandomValue(128)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+		expected[string(utils.GetTestKey(i))] = value
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// The records after the mark are applied when opening
+	writeWithoutBPlusTree(t, opts, func(db *DB) {
+		for i := 400; i < 1000; i++ {
+			value := utils.RandomValue(128)
+			assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+			expected[string(utils.GetTestKey(i))] = value
+		}
+		for i := 0; i < 100; i++ {
+			assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+			delete(expected, string(utils.GetTestKey(i)))
+		}
+		wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+		assert.Nil(t, wb.Put([]byte("batch"), []byte("value")))
+		assert.Nil(t, wb.Delete(utils.GetTestKey(100)))
+		assert.Nil(t, wb.Commit())
+		expected["batch"] = []byte("value")
+		delete(expected, string(utils.GetTestKey(100)))
+		// Keys 150 to 159
+		prefix := utils.GetTestKey(150)[:len(utils.GetTestKey(150)) - 1]
+		assert.Nil(t, db.DeletePrefix(prefix))
+		for key := range expected {
+			if strings.HasPrefix(key, string(prefix)) {
+				delete(expected, key)
+			}
+		}
+	})
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	checkManifestTestData(t, db2, expected)
+	mark := db2.index.(*index.BPlusTree).HighWaterMark()
+	assert.Equal(t, db2.activeFile.FileId, mark.Fid)
+	assert.Equal(t, db2.activeFile.WriteOff, mark.Offset)
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	// Merge replaces the files the B+ tree points to, the index is rebuilt
+	writeWithoutBPlusTree(t, opts, func(db *DB) {
+		assert.Nil(t, db.Merge())
+	})
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	checkManifestTestData(t, db3, expected)
+	err = db3.Close()
+	assert.Nil(t, err)
+
+	// The index file is lost
+	err = os.Remove(filepath.Join(dir, index.BPlusTreeIndexFileName))
+	assert.Nil(t, err)
+	db4, err := Open(opts)
+	assert.Nil(t, err)
+	checkManifestTestData(t, db4, expected)
+
+	// The data files lost the records the index has
+	assert.Nil(t, db4.Put([]byte("lost"), []byte("value")))
+	activeFileId, writeOff := db4.activeFile.FileId, db4.activeFile.WriteOff
+	err = db4.Close()
+	assert.Nil(t, err)
+	file, err := opts.FS.OpenFile(data.GetDataFileName(dir, activeFileId), os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Truncate(writeOff - 1))
+	assert.Nil(t, file.Close())
+
+	db5, err := Open(opts)
+	defer destroyDB(db5)
+	assert.Nil(t, err)
+	_, err = db5.Get([]byte("lost"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	checkManifestTestData(t, db5, expected)
+}
@@ -0,0 +1,131 @@
+package kvproject
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"math"
+	"strconv"
+)
+
+// Conditional writes
+// The check and the write are done under the lock of the database, so they are atomic
+// Reading the value and then writing it from the caller is not
+
+// Replace the value of key with value, only if the current value equals expected
+// Return false if the key doesn't exist or the value is different
+func (db *DB) CompareAndSwap(key, expected, value []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	current, err := db.get(key)
+	if err == ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(current, expected) {
+		return false, nil
+	}
+	return true, db.put(key, value)
+}
+
+// Write key/value, only if the key doesn't exist
+// Return false if the key exists
+func (db *DB) PutIfAbsent(key, value []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if pos := db.index.Get(key); pos != nil {
+		return false, nil
+	}
+	return true, db.put(key, value)
+}
+
+// Delete the key, only if the current value equals expected
+// Return false if the key doesn't exist or the value is different
+func (db *DB) DeleteIfEquals(key, expected []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	current, err := db.get(key)
+	if err == ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(current, expected) {
+		return false, nil
+	}
+	return true, db.delete(key)
+}
+
+// Add delta to the int64 value of key and return the new value
+// The value is saved as a decimal string. If the key doesn't exist, it is regarded as 0
+func (db *DB) Increment(key []byte, delta int64) (int64, error) {
+	if len(key) == 0 {
+		return 0, ErrKeyIsEmpty
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var current int64
+	value, err := db.get(key)
+	if err != nil && err != ErrKeyNotFound {
+		return 0, err
+	}
+	if err == nil {
+		current, err = strconv.ParseInt(string(value), 10, 64)
+		if err != nil {
+			return 0, ErrValueIsNotInteger
+		}
+	}
+
+	if (delta > 0 && current > math.MaxInt64 - delta) || (delta < 0 && current < math.MinInt64 - delta) {
+		return 0, ErrIntegerOverflow
+	}
+	current += delta
+	if err := db.put(key, []byte(strconv.FormatInt(current, 10))); err != nil {
+		return 0, err
+	}
+	return current, nil
+}
+
+// Move the value of oldKey to newKey. If newKey exists, it is overwritten
+// Two keys are changed, so they are written as a transaction
+func (db *DB) Rename(oldKey, newKey []byte) error {
+	if len(oldKey) == 0 || len(newKey) == 0 {
+		return ErrKeyIsEmpty
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	value, err := db.get(oldKey)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(oldKey, newKey) {
+		return nil
+	}
+
+	records := []*data.LogRecord{
+		{Key: newKey, Value: value, Type: data.LogRecordNormal},
+		{Key: oldKey, Type: data.LogRecordDeleted},
+	}
+	return db.commitTxn(records, db.options.SyncWrite)
+}
@@ -0,0 +1,202 @@
+package kvproject
+
+import (
+	"bitcask-go/utils"
+	"math"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_CompareAndSwap(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-cas")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1. Key doesn't exist
+	ok, err := db.CompareAndSwap(utils.GetTestKey(1), []byte("a"), []byte("b"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// 2. Value is different
+	err = db.Put(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	ok, err = db.CompareAndSwap(utils.GetTestKey(1), []byte("c"), []byte("b"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// 3. Value is the same
+	ok, err = db.CompareAndSwap(utils.GetTestKey(1), []byte("a"), []byte("b"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), val)
+
+	// 4. Key is empty
+	_, err = db.CompareAndSwap(nil, []byte("a"), []byte("b"))
+	assert.Equal(t, ErrKeyIsEmpty, err)
+}
+
+func TestDB_PutIfAbsent(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-put-if-absent")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	ok, err := db.PutIfAbsent(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	ok, err = db.PutIfAbsent(utils.GetTestKey(1), []byte("b"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), val)
+
+	// Only one of the concurrent callers gets the lock
+	var wins int32
+	var mu sync.Mutex
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := db.PutIfAbsent([]byte("lock"), []byte("owner"))
+			assert.Nil(t, err)
+			if ok {
+				mu.Lock()
+				wins++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), wins)
+}
+
+func TestDB_DeleteIfEquals(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-delete-if-equals")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	ok, err := db.DeleteIfEquals(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	err = db.Put(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	ok, err = db.DeleteIfEquals(utils.GetTestKey(1), []byte("b"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	ok, err = db.DeleteIfEquals(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_Increment(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-increment")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1. Key doesn't exist
+	val, err := db.Increment(utils.GetTestKey(1), 5)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), val)
+	val, err = db.Increment(utils.GetTestKey(1), -8)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-3), val)
+
+	// 2. Concurrent increments
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, err := db.Increment(utils.GetTestKey(2), 1)
+				assert.Nil(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+	res, err := db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1000"), res)
+
+	// 3. Not an integer
+	err = db.Put(utils.GetTestKey(3), []byte("abc"))
+	assert.Nil(t, err)
+	_, err = db.Increment(utils.GetTestKey(3), 1)
+	assert.Equal(t, ErrValueIsNotInteger, err)
+
+	// 4. Overflow
+	_, err = db.Increment(utils.GetTestKey(4), math.MaxInt64)
+	assert.Nil(t, err)
+	_, err = db.Increment(utils.GetTestKey(4), 1)
+	assert.Equal(t, ErrIntegerOverflow, err)
+}
+
+func TestDB_Rename(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-rename")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1. Key doesn't exist
+	err = db.Rename(utils.GetTestKey(1), utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 2. Rename
+	err = db.Put(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(2), []byte("b"))
+	assert.Nil(t, err)
+	err = db.Rename(utils.GetTestKey(1), utils.GetTestKey(2))
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), val)
+
+	// 3. Same key
+	err = db.Rename(utils.GetTestKey(2), utils.GetTestKey(2))
+	assert.Nil(t, err)
+
+	// 4. Test after restarting
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	_, err = db2.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err = db2.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), val)
+}
@@ -0,0 +1,422 @@
+package kvproject
+
+import (
+	"bitcask-go/utils"
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Upper limit of the fault points of a test, so a bug cannot make it run forever
+const maxFaultPoints = 100000
+
+// One step of a workload
+type crashStep struct {
+	name string
+	run func(db *DB) error
+	// Change of the data if the step succeeds
+	apply func(data map[string][]byte)
+}
+
+func crashTestOptions(fs *faultFS) Options {
+	opts := DefaultOptions
+	opts.FS = fs
+	opts.DirPath = "/bitcask-go-crash"
+	// Small files, so the steps cross the files
+	opts.DataFileSize = 1024
+	opts.SyncWrite = true
+	opts.DataFileMergeRatio = 0
+	return opts
+}
+
+func putStep(key string, value []byte) crashStep {
+	return crashStep{
+		name: "put " + key,
+		run: func(db *DB) error {
+			return db.Put([]byte(key), value)
+		},
+		apply: func(data map[string][]byte) {
+			data[key] = value
+		},
+	}
+}
+
+func deleteStep(key string) crashStep {
+	return crashStep{
+		name: "delete " + key,
+		run: func(db *DB) error {
+			return db.Delete([]byte(key))
+		},
+		apply: func(data map[string][]byte) {
+			delete(data, key)
+		},
+	}
+}
+
+// Put all the keys and delete the keys of deletes in one batch
+func batchStep(puts map[string][]byte, deletes []string) crashStep {
+	return crashStep{
+		name: fmt.Sprintf("batch of %d puts and %d deletes", len(puts), len(deletes)),
+		run: func(db *DB) error {
+			wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+			for key, value := range puts {
+				if err := wb.Put([]byte(key), value); err != nil {
+					return err
+				}
+			}
+			for _, key := range deletes {
+				if err := wb.Delete([]byte(key)); err != nil {
+					return err
+				}
+			}
+			return wb.Commit()
+		},
+		apply: func(data map[string][]byte) {
+			for key, value := range puts {
+				data[key] = value
+			}
+			for _, key := range deletes {
+				delete(data, key)
+			}
+		},
+	}
+}
+
+func mergeStep() crashStep {
+	return crashStep{
+		name: "merge",
+		run: func(db *DB) error {
+			return db.Merge()
+		},
+		apply: func(data map[string][]byte) {},
+	}
+}
+
+func copyData(data map[string][]byte) map[string][]byte {
+	copied := make(map[string][]byte, len(data))
+	for key, value := range data {
+		copied[key] = value
+	}
+	return copied
+}
+
+// Does the database have exactly the data?
+// keys are all the keys which may be in the database
+func matchData(db *DB, keys map[string]bool, data map[string][]byte) bool {
+	for key := range keys {
+		value, err := db.Get([]byte(key))
+		expected, ok := data[key]
+		if !ok {
+			if err != ErrKeyNotFound {
+				return false
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(expected, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Check the database has one of the candidate data, then check it can still be written
+func checkRecovered(t *testing.T, db *DB, opts Options, keys map[string]bool, candidates []map[string][]byte, point string) bool {
+	matched := false
+	for _, data := range candidates {
+		if matchData(db, keys, data) {
+			matched = true
+			break
+		}
+	}
+	if !assert.True(t, matched, "unexpected data after %s", point) {
+		return false
+	}
+	if !assert.Nil(t, db.Put([]byte("after-recovery"), []byte("value")), point) {
+		return false
+	}
+	if !assert.Nil(t, db.Close(), point) {
+		return false
+	}
+
+	db2, err := Open(opts)
+	if !assert.Nil(t, err, point) {
+		return false
+	}
+	defer func() {
+		_ = db2.Close()
+	}()
+	value, err := db2.Get([]byte("after-recovery"))
+	return assert.Nil(t, err, point) && assert.Equal(t, []byte("value"), value, point)
+}
+
+// Run setup and steps, crash at every operation of the steps, restart and check the data
+// Steps finished before the crash must be durable, the step in progress is either done or not at all
+func testCrashPoints(t *testing.T, setup []crashStep, steps []crashStep) {
+	keys := make(map[string]bool)
+	for _, step := range append(append([]crashStep{}, setup...), steps...) {
+		data := make(map[string][]byte)
+		step.apply(data)
+		for key := range data {
+			keys[key] = true
+		}
+	}
+
+	for crashAt := 1; crashAt < maxFaultPoints; crashAt++ {
+		fs := newFaultFS()
+		opts := crashTestOptions(fs)
+		db, err := Open(opts)
+		if !assert.Nil(t, err) {
+			return
+		}
+		data := make(map[string][]byte)
+		for _, step := range setup {
+			if !assert.Nil(t, step.run(db), step.name) {
+				return
+			}
+			step.apply(data)
+		}
+
+		fs.injectAfter(crashAt, faultCrash)
+		candidates := []map[string][]byte{data}
+		point := fmt.Sprintf("crash at operation %d", crashAt)
+		for _, step := range steps {
+			before := copyData(data)
+			if err := step.run(db); err != nil {
+				if !assert.Equal(t, errCrashed, err, step.name) {
+					return
+				}
+				// The step in progress
+				step.apply(data)
+				candidates = []map[string][]byte{before, data}
+				point += " in " + step.name
+				break
+			}
+			step.apply(data)
+			candidates = []map[string][]byte{data}
+		}
+
+		crashed := fs.hasCrashed()
+		restartedFS := fs.restart()
+		opts = crashTestOptions(restartedFS)
+		db2, err := Open(opts)
+		if !assert.Nil(t, err, point) {
+			return
+		}
+		if !checkRecovered(t, db2, opts, keys, candidates, point) {
+			return
+		}
+
+		if !crashed {
+			// All the steps finished
+			t.Logf("%d crash points tested", crashAt - 1)
+			return
+		}
+	}
+	t.Fatal("too many crash points")
+}
+
+// Inject a fault which is not a crash at every operation of the steps
+// The step with the fault fails, the following steps must succeed
+func testFaultPoints(t *testing.T, kind faultKind, setup []crashStep, steps []crashStep) {
+	keys := make(map[string]bool)
+	for _, step := range append(append([]crashStep{}, setup...), steps...) {
+		data := make(map[string][]byte)
+		step.apply(data)
+		for key := range data {
+			keys[key] = true
+		}
+	}
+
+	for faultAt := 1; faultAt < maxFaultPoints; faultAt++ {
+		fs := newFaultFS()
+		opts := crashTestOptions(fs)
+		db, err := Open(opts)
+		if !assert.Nil(t, err) {
+			return
+		}
+		data := make(map[string][]byte)
+		for _, step := range setup {
+			if !assert.Nil(t, step.run(db), step.name) {
+				return
+			}
+			step.apply(data)
+		}
+
+		fs.injectAfter(faultAt, kind)
+		// Data without and with the failed step
+		withoutFailed, withFailed := copyData(data), copyData(data)
+		point := fmt.Sprintf("fault %d at operation %d", kind, faultAt)
+		failed := false
+		for _, step := range steps {
+			if err := step.run(db); err != nil {
+				if !assert.False(t, failed, "%s: more than one step failed, %s: %v", point, step.name, err) {
+					return
+				}
+				failed = true
+				point += " in " + step.name
+				step.apply(withFailed)
+				continue
+			}
+			step.apply(withoutFailed)
+			step.apply(withFailed)
+		}
+		candidates := []map[string][]byte{withoutFailed, withFailed}
+		injected := fs.stopFault()
+
+		// The database is still right before restarting
+		matched := matchData(db, keys, withoutFailed) || matchData(db, keys, withFailed)
+		if !assert.True(t, matched, "unexpected data after %s", point) {
+			return
+		}
+
+		// Restart after syncing everything
+		if !assert.Nil(t, db.Sync(), point) {
+			return
+		}
+		opts = crashTestOptions(fs.restart())
+		db2, err := Open(opts)
+		if !assert.Nil(t, err, point) {
+			return
+		}
+		if !checkRecovered(t, db2, opts, keys, candidates, point) {
+			return
+		}
+
+		if !injected {
+			// The fault is not injected, all the operations have been tested
+			t.Logf("%d fault points tested", faultAt - 1)
+			return
+		}
+	}
+	t.Fatal("too many fault points")
+}
+
+func crashTestPutSteps() []crashStep {
+	var steps []crashStep
+	for i := 0; i < 30; i++ {
+		// Keys 0-9 are written twice
+		steps = append(steps, putStep(string(utils.GetTestKey(i % 20)), utils.RandomValue(64)))
+	}
+	for i := 0; i < 10; i++ {
+		steps = append(steps, deleteStep(string(utils.GetTestKey(i))))
+	}
+	return steps
+}
+
+func crashTestBatchSteps() []crashStep {
+	var steps []crashStep
+	for i := 0; i < 8; i++ {
+		puts := make(map[string][]byte)
+		for j := 0; j < 4; j++ {
+			puts[fmt.Sprintf("batch-%d-%d", i, j)] = utils.RandomValue(64)
+		}
+		var deletes []string
+		if i > 0 {
+			deletes = append(deletes, fmt.Sprintf("batch-%d-0", i - 1))
+		}
+		steps = append(steps, batchStep(puts, deletes))
+	}
+	return steps
+}
+
+// Data where half of the keys are deleted, so merge has something to drop
+func crashTestMergeSetup() []crashStep {
+	var steps []crashStep
+	for i := 0; i < 60; i++ {
+		steps = append(steps, putStep(string(utils.GetTestKey(i)), utils.RandomValue(64)))
+	}
+	for i := 0; i < 60; i += 2 {
+		steps = append(steps, deleteStep(string(utils.GetTestKey(i))))
+	}
+	return steps
+}
+
+func TestCrash_Put(t *testing.T) {
+	testCrashPoints(t, nil, crashTestPutSteps())
+}
+
+func TestCrash_WriteBatch(t *testing.T) {
+	testCrashPoints(t, nil, crashTestBatchSteps())
+}
+
+func TestCrash_Merge(t *testing.T) {
+	// Writes after the merge are not lost either
+	steps := []crashStep{mergeStep(), putStep("after-merge", []byte("value")), mergeStep()}
+	testCrashPoints(t, crashTestMergeSetup(), steps)
+}
+
+// Crash when the merged files are moved in at the next start
+func TestCrash_LoadMergeFiles(t *testing.T) {
+	for crashAt := 1; crashAt < maxFaultPoints; crashAt++ {
+		fs := newFaultFS()
+		opts := crashTestOptions(fs)
+		db, err := Open(opts)
+		if !assert.Nil(t, err) {
+			return
+		}
+		data := make(map[string][]byte)
+		keys := make(map[string]bool)
+		for _, step := range crashTestMergeSetup() {
+			if !assert.Nil(t, step.run(db), step.name) {
+				return
+			}
+			step.apply(data)
+		}
+		for i := 0; i < 60; i++ {
+			keys[string(utils.GetTestKey(i))] = true
+		}
+		if _, err := db.merge(); !assert.Nil(t, err) {
+			return
+		}
+
+		// Crash before the merged files are activated, then crash again when opening
+		fs = fs.restart()
+		fs.injectAfter(crashAt, faultCrash)
+		point := fmt.Sprintf("crash at operation %d of open", crashAt)
+		db2, err := Open(crashTestOptions(fs))
+		crashed := fs.hasCrashed()
+		if !crashed {
+			// Opening finished
+			if !assert.Nil(t, err, point) {
+				return
+			}
+			_ = db2.Close()
+		}
+
+		opts = crashTestOptions(fs.restart())
+		db3, err := Open(opts)
+		if !assert.Nil(t, err, point) {
+			return
+		}
+		if !checkRecovered(t, db3, opts, keys, []map[string][]byte{data}, point) {
+			return
+		}
+
+		if !crashed {
+			t.Logf("%d crash points tested", crashAt - 1)
+			return
+		}
+	}
+	t.Fatal("too many crash points")
+}
+
+func TestFault_Put(t *testing.T) {
+	for _, kind := range []faultKind{faultShortWrite, faultSyncError, faultNoSpace} {
+		testFaultPoints(t, kind, nil, crashTestPutSteps())
+	}
+}
+
+func TestFault_WriteBatch(t *testing.T) {
+	for _, kind := range []faultKind{faultShortWrite, faultSyncError, faultNoSpace} {
+		testFaultPoints(t, kind, nil, crashTestBatchSteps())
+	}
+}
+
+func TestFault_Merge(t *testing.T) {
+	steps := []crashStep{mergeStep(), putStep("after-merge", []byte("value"))}
+	for _, kind := range []faultKind{faultShortWrite, faultSyncError, faultNoSpace} {
+		testFaultPoints(t, kind, crashTestMergeSetup(), steps)
+	}
+}
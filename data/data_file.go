@@ -7,18 +7,68 @@ import (
 	"hash/crc32"
 	"io"
 	"path/filepath"
+	"sync"
 )
 
 const (
 	DataFileNameSuffix = ".data"
 	HintFileName = "hint-index"
+	HintFileNameSuffix = ".hint"
 	MergeFinishedFileName = "merge.finished"
+	MergeFinishedTempFileName = "merge.finished.tmp"
 	SeqNoFileName = "seq-no"
+	SeqNoTempFileName = "seq-no.tmp"
+	ManifestFileName = "MANIFEST"
+	ManifestTempFileName = "MANIFEST.tmp"
 )
 
 
+const (
+	// Records whose gap is not larger than this are read in one read
+	maxReadGap = 4 * 1024
+	// The maximum size of one read when reading records together
+	maxReadBlockSize = 1024 * 1024
+	// Buffers larger than this are not put back to the pool
+	maxPooledBufferSize = 64 * 1024
+)
+
+// Buffers for ReadLogRecordAt
+var readBufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 4 * 1024)
+		return &buf
+	},
+}
+
+// Get a buffer of length n from the pool
+func getReadBuffer(n int) *[]byte {
+	bufp := readBufferPool.Get().(*[]byte)
+	if cap(*bufp) < n {
+		buf := make([]byte, n)
+		bufp = &buf
+	}
+	*bufp = (*bufp)[:n]
+	return bufp
+}
+
+// Return the buffer to the pool, large buffers are dropped so that the pool doesn't hold too much memory
+func putReadBuffer(bufp *[]byte) {
+	if cap(*bufp) > maxPooledBufferSize {
+		return
+	}
+	readBufferPool.Put(bufp)
+}
+
 var (
 	ErrInvalidCRC = errors.New("invalid crc value, log record may be corrupted")
+	ErrInvalidFileHeader = errors.New("invalid file header, the file may be corrupted")
+	ErrUnsupportedFileFormat = errors.New("unsupported file format version")
+	ErrUnsupportedChecksum = errors.New("unsupported checksum type")
+	ErrInvalidLogRecordHeader = errors.New("invalid log record header, log record may be corrupted")
+	ErrInvalidLogRecordSize = errors.New("log record runs past the end of the file, log record may be corrupted")
+	ErrTrailingData = errors.New("unexpected data after the last log record, the file may be corrupted")
+	ErrInvalidLogRecordPos = errors.New("invalid log record position")
+	ErrInvalidMergeOperand = errors.New("invalid merge operand, log record may be corrupted")
 )
 
 // Data files
@@ -26,54 +76,204 @@ type DataFile struct {
 	FileId uint32			
 	WriteOff int64			// The place where the file is written to 
 	IoManager fio.IOManager     // Management of IO read and write
+	Header *FileHeader          // nil for the files of format v0, which have no header
 }
 
-// Open new file
-func OpenDataFile(dirPath string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
+// Open new file in fs
+func OpenDataFile(fs fio.FS, dirPath string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
+	return OpenDataFileWithCapacity(fs, dirPath, fileId, ioType, 0)
+}
+
+// Open new file, the file is pre-allocated to capacity if the IO type needs
+func OpenDataFileWithCapacity(fs fio.FS, dirPath string, fileId uint32, ioType fio.FileIOType, capacity int64) (*DataFile, error) {
 	// Format fileId as an integer and pad it with 0 on the left to ensure the final generated string is 9 digits in length
 	fileName := GetDataFileName(dirPath, fileId)
-	return newOpenFile(fileName, fileId, ioType)
+	return newOpenFileWithCapacity(fs, fileName, fileId, ioType, capacity)
 }
 
 // Open hint index file
-func OpenHintFile(dirPath string) (*DataFile, error) {
-	fileName := filepath.Join(dirPath, HintFileName)
-	return newOpenFile(fileName, 0, fio.StandardFIO)
+func OpenHintFile(fs fio.FS, dirPath string) (*DataFile, error) {
+	return OpenHintFileWithName(fs, dirPath, HintFileName)
+}
+
+// Open the hint file with the name, the hint files of installed merges are named by GetHintFileName
+func OpenHintFileWithName(fs fio.FS, dirPath string, name string) (*DataFile, error) {
+	fileName := filepath.Join(dirPath, name)
+	return newOpenFile(fs, fileName, 0, fio.StandardFIO)
 }
 
 // Open the file which indicates the end of merge
-func OpenMergeFinishedFile(dirPath string) (*DataFile, error) {
+func OpenMergeFinishedFile(fs fio.FS, dirPath string) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, MergeFinishedFileName)
-	return newOpenFile(fileName, 0, fio.StandardFIO)
+	return newOpenFile(fs, fileName, 0, fio.StandardFIO)
+}
+
+// Open the file which indicates the end of merge under a temporary name
+// It is renamed to MergeFinishedFileName after being synced, so it is never seen half written
+func OpenMergeFinishedTempFile(fs fio.FS, dirPath string) (*DataFile, error) {
+	fileName := filepath.Join(dirPath, MergeFinishedTempFileName)
+	return newOpenFile(fs, fileName, 0, fio.StandardFIO)
 }
 
 // Open the file which saves seqNo
-func OpenSeqNoFile(dirPath string) (*DataFile, error) {
+func OpenSeqNoFile(fs fio.FS, dirPath string) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, SeqNoFileName)
-	return newOpenFile(fileName, 0, fio.StandardFIO)
+	return newOpenFile(fs, fileName, 0, fio.StandardFIO)
+}
+
+// Open the file which saves seqNo under a temporary name
+// It is renamed to SeqNoFileName after being synced, so seqNo is never lost by a half written file
+func OpenSeqNoTempFile(fs fio.FS, dirPath string) (*DataFile, error) {
+	fileName := filepath.Join(dirPath, SeqNoTempFileName)
+	return newOpenFile(fs, fileName, 0, fio.StandardFIO)
+}
+
+// Open the manifest, which records the live files of the database
+func OpenManifestFile(fs fio.FS, dirPath string) (*DataFile, error) {
+	fileName := filepath.Join(dirPath, ManifestFileName)
+	return newOpenFile(fs, fileName, 0, fio.StandardFIO)
+}
+
+// Open the manifest under a temporary name
+// It is renamed to ManifestFileName after being synced, so the manifest is switched at once
+func OpenManifestTempFile(fs fio.FS, dirPath string) (*DataFile, error) {
+	fileName := filepath.Join(dirPath, ManifestTempFileName)
+	return newOpenFile(fs, fileName, 0, fio.StandardFIO)
 }
 
 func GetDataFileName(dirPath string, fileId uint32) string {
 	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId) + DataFileNameSuffix) 
 }
 
-func newOpenFile(fileName string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
+// Name of the hint file of the merged files starting from fileId
+func GetHintFileName(fileId uint32) string {
+	return fmt.Sprintf("%09d", fileId) + HintFileNameSuffix
+}
+
+func newOpenFile(fs fio.FS, fileName string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
+	return newOpenFileWithCapacity(fs, fileName, fileId, ioType, 0)
+}
+
+func newOpenFileWithCapacity(fs fio.FS, fileName string, fileId uint32, ioType fio.FileIOType, capacity int64) (*DataFile, error) {
 	// Initialize IOManager
-	ioManager, err := fio.NewIOManager(fileName, ioType)
+	ioManager, err := fio.NewIOManagerWithCapacity(fs, fileName, ioType, capacity)
 	if err != nil {
 		return nil, err
 	}
-	return &DataFile{
+	df := &DataFile{
 		FileId: fileId,
 		WriteOff: 0,
 		IoManager: ioManager,
-	}, nil
+	}
+	// Memory map is read only, it opens only the files written before
+	if err := df.loadHeader(ioType != fio.MemoryMap); err != nil {
+		_ = ioManager.Close()
+		return nil, err
+	}
+	return df, nil
+}
+
+// Read the header of the file, a new file gets a header if it is writable
+// A file without the magic is of format v0, its records start at 0
+func (df *DataFile) loadHeader(writable bool) error {
+	size, err := df.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	var buf []byte
+	if size > 0 {
+		n := int64(FileHeaderSize)
+		if size < n {
+			n = size
+		}
+		if buf, err = df.readNBytes(n, 0); err != nil {
+			return err
+		}
+	}
+
+	if hasFileMagic(buf) && len(buf) == FileHeaderSize {
+		header, err := DecodeFileHeader(buf)
+		if err != nil {
+			return err
+		}
+		df.Header = header
+		return nil
+	}
+	if !isNewFile(buf) {
+		// Records of format v0
+		return nil
+	}
+	if !writable {
+		return nil
+	}
+
+	// The file is empty, zero-filled by pre-allocation, or its header was not written completely
+	if size > 0 {
+		if err := df.SetWriteOff(0); err != nil {
+			return err
+		}
+	}
+	header := newFileHeader()
+	if err := df.Write(header.Encode()); err != nil {
+		return err
+	}
+	df.Header = header
+	return nil
+}
+
+// Is the beginning of the file no record or header?
+// It is all 0, part of a header, or shorter than the crc of a record
+func isNewFile(buf []byte) bool {
+	if len(buf) < crc32.Size || hasFileMagic(buf) {
+		return true
+	}
+	for _, b := range buf {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Offset of the first record
+func (df *DataFile) RecordsStart() int64 {
+	if df.Header == nil {
+		return 0
+	}
+	return FileHeaderSize
+}
+
+// Checksum algorithm of the records in the file
+func (df *DataFile) Checksum() ChecksumType {
+	if df.Header == nil {
+		return ChecksumCRC32IEEE
+	}
+	return df.Header.Checksum
+}
+
+// Encode LogRecord in the format of the file
+func (df *DataFile) EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
+	return EncodeLogRecordWithChecksum(logRecord, df.Checksum())
+}
+
+// Encode LogRecord and write it to the file
+func (df *DataFile) WriteLogRecord(logRecord *LogRecord) error {
+	encRecord, _ := df.EncodeLogRecord(logRecord)
+	return df.Write(encRecord)
 }
 
 
 func (df *DataFile) Write(buf []byte) error {
 	n, err := df.IoManager.Write(buf)
 	if err != nil {
+		if n > 0 {
+			// Part of buf is written, drop it so that the next record is written at WriteOff
+			// If it cannot be dropped, the positions of the following records must still be right
+			truncater, ok := df.IoManager.(fio.Truncater)
+			if !ok || truncater.Truncate(df.WriteOff) != nil {
+				df.WriteOff += int64(n)
+			}
+		}
 		return err
 	}
 	df.WriteOff += int64(n)
@@ -86,8 +286,7 @@ func (df *DataFile) WriteHintRecord(key []byte, pos *LogRecordPos) error {
 		Key: key,
 		Value: EncodeLogRecordPos(pos),
 	}
-	encRecord, _ := EncodeLogRecord(record)
-	return df.Write(encRecord)
+	return df.WriteLogRecord(record)
 }
 
 func (df *DataFile) Sync() error {
@@ -104,6 +303,21 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	if err != nil {
 		return nil, 0, err
 	}
+	return df.ScanLogRecord(offset, fileSize)
+}
+
+// Read LogRecord accrording to the offset, fileSize is the size of the file got by the caller
+// When reading records one by one, get the size once and use this method to avoid a Stat every time
+// io.EOF is returned at the end of the records, including a last record which is not written completely
+// and the zero-filled tail of a pre-allocated file. A record which cannot be read before other complete records
+// is corrupted, the error tells the file and the offset of it
+func (df *DataFile) ScanLogRecord(offset int64, fileSize int64) (*LogRecord, int64, error) {
+	if offset < 0 {
+		return nil, 0, df.recordError(offset, ErrInvalidLogRecordPos)
+	}
+	if offset >= fileSize {
+		return nil, 0, io.EOF
+	}
 
 	// A special case:
 	// When deleting data, a log record will be appended to the file
@@ -121,23 +335,30 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 		return nil, 0, err
 	}
 
-	header, headerSize := decodeLogRecordHeader(headerBuf)
-
-	// Header not read
-	// The file reading finishes
-	if header == nil {
+	header, headerSize, err := decodeLogRecordHeader(headerBuf)
+	if err == io.EOF || (err == io.ErrUnexpectedEOF && headerBytes < maxLogRecordHeaderSize) {
+		// Header is cut by the end of the file
+		// The file reading finishes
 		return nil, 0, io.EOF
 	}
+	if err != nil {
+		return nil, 0, df.recordError(offset, err)
+	}
 
 	// Information in header are all 0
-	// The file reading finishes
+	// The file reading finishes if it is the zero-filled tail of a pre-allocated file
 	if header.crc == 0 && header.keySize == 0 && header.valueSize == 0 {
-		return nil, 0, io.EOF
+		return nil, 0, df.endOfRecords(offset, fileSize, ErrInvalidLogRecordHeader)
 	}
 
 	// Get the length of key and value
 	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
 	var recordSize = headerSize + keySize + valueSize
+	// Check the length before reading, a corrupted header cannot make a huge allocation
+	if offset + recordSize > fileSize {
+		// The last record is not written completely, or the size in the header is corrupted
+		return nil, 0, df.endOfRecords(offset, fileSize, ErrInvalidLogRecordSize)
+	}
 
 	// Read the actual key and value saved by user
 	logRecord := &LogRecord{Type: header.recordType}
@@ -155,24 +376,286 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	// Check validation of the data 
 	// headerBuf is the longest length. headerSize is the real length
 	// crc32.Size = 4
-	crc := getLogRecordCRC(logRecord, headerBuf[crc32.Size: headerSize]) 
+	crc := getLogRecordChecksum(logRecord, headerBuf[crc32.Size: headerSize], df.Checksum())
 	if crc != header.crc {
-		return nil, 0, ErrInvalidCRC
+		// In a pre-allocated file, a record not written completely is followed by 0 instead of the end of the file
+		return nil, 0, df.endOfRecords(offset, fileSize, ErrInvalidCRC)
 	}
 	return logRecord, recordSize, nil
 }
 
+// The error of a record which cannot be read at offset
+// io.EOF if it is the end of the records, otherwise err with the file and the offset
+func (df *DataFile) endOfRecords(offset int64, fileSize int64, err error) error {
+	torn, tornErr := df.isTornTail(offset, fileSize)
+	if tornErr != nil {
+		return tornErr
+	}
+	if torn {
+		return io.EOF
+	}
+	return df.recordError(offset, err)
+}
+
+// Is the data from offset to the end of the file no complete record?
+// It is a record not written completely, the zero-filled tail of a pre-allocated file, or both
+// A corrupted record in the middle of the file is followed by complete records, so the records after it are not dropped silently
+// Only one block after offset is searched, a pre-allocated file is not read to its end at every start
+func (df *DataFile) isTornTail(offset int64, fileSize int64) (bool, error) {
+	// The block is read with the header of its last record
+	blockEnd := offset + maxReadBlockSize + maxLogRecordHeaderSize
+	if blockEnd > fileSize {
+		blockEnd = fileSize
+	}
+	buf, err := df.readNBytes(blockEnd - offset, offset)
+	if err != nil {
+		return false, err
+	}
+
+	// The value of a corrupted record may be larger than the block, the record after it is found with the size in its header
+	if header, headerSize, err := decodeLogRecordHeader(buf); err == nil {
+		next := offset + headerSize + int64(header.keySize) + int64(header.valueSize)
+		if next >= offset + maxReadBlockSize && next < fileSize {
+			nextBuf, err := df.readNBytes(minInt64(maxLogRecordHeaderSize, fileSize - next), next)
+			if err != nil {
+				return false, err
+			}
+			found, err := df.isRecordAt(next, nextBuf, fileSize)
+			if err != nil || found {
+				return false, err
+			}
+		}
+	}
+
+	for i := int64(0); i < maxReadBlockSize && offset + i < fileSize; i++ {
+		if buf[i] == 0 {
+			// A record doesn't start with a header of all 0, skip to the headers which have the next non-zero byte
+			if next := nextNonZero(buf, i); next - i > maxLogRecordHeaderSize {
+				i = next - maxLogRecordHeaderSize
+				continue
+			}
+		}
+		found, err := df.isRecordAt(offset + i, buf[i:], fileSize)
+		if err != nil {
+			return false, err
+		}
+		if found {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Is there a complete record at offset? buf is the data from offset, it may be shorter than the record
+// The record must be followed by the end of the file or a header, so most of the random data is not checked by crc
+func (df *DataFile) isRecordAt(offset int64, buf []byte, fileSize int64) (bool, error) {
+	header, headerSize, err := decodeLogRecordHeader(buf)
+	if err != nil || (header.crc == 0 && header.keySize == 0 && header.valueSize == 0) {
+		return false, nil
+	}
+	recordSize := headerSize + int64(header.keySize) + int64(header.valueSize)
+	end := offset + recordSize
+	if end > fileSize {
+		return false, nil
+	}
+	if end < fileSize {
+		next, err := df.readFrom(buf, offset, end, minInt64(maxLogRecordHeaderSize, fileSize - end))
+		if err != nil {
+			return false, err
+		}
+		if _, _, err := decodeLogRecordHeader(next); err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return false, nil
+		}
+	}
+	record, err := df.readFrom(buf, offset, offset, recordSize)
+	if err != nil {
+		return false, err
+	}
+	_, _, err = DecodeLogRecordWithChecksum(record, df.Checksum())
+	return err == nil, nil
+}
+
+// Get n bytes at offset, from buf if it has them, buf is the data from bufOffset
+func (df *DataFile) readFrom(buf []byte, bufOffset int64, offset int64, n int64) ([]byte, error) {
+	start := offset - bufOffset
+	if start + n <= int64(len(buf)) {
+		return buf[start : start + n], nil
+	}
+	return df.readNBytes(n, offset)
+}
+
+// Index of the first non-zero byte from i, len(buf) if there is none
+func nextNonZero(buf []byte, i int64) int64 {
+	for ; i < int64(len(buf)); i++ {
+		if buf[i] != 0 {
+			break
+		}
+	}
+	return i
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Add the file and the offset to the error of a record, errors.Is still matches the error
+func (df *DataFile) recordError(offset int64, err error) error {
+	return fmt.Errorf("%w (file %d, offset %d)", err, df.FileId, offset)
+}
+
+// Read LogRecord at offset, size is the length of the whole record
+// Only one read of exactly size bytes is done, the buffer is reused
+// The key and value are copied, so they are still valid after the buffer is reused
+func (df *DataFile) ReadLogRecordAt(offset int64, size uint32) (*LogRecord, error) {
+	bufp := getReadBuffer(int(size))
+	defer putReadBuffer(bufp)
+
+	buf := *bufp
+	if _, err := df.IoManager.Read(buf, offset); err != nil {
+		return nil, err
+	}
+	logRecord, _, err := DecodeLogRecordWithChecksum(buf, df.Checksum())
+	if err != nil {
+		return nil, df.recordError(offset, err)
+	}
+
+	// Key and value share one allocation
+	kv := make([]byte, len(logRecord.Key) + len(logRecord.Value))
+	copy(kv, logRecord.Key)
+	copy(kv[len(logRecord.Key):], logRecord.Value)
+	logRecord.Key = kv[:len(logRecord.Key):len(logRecord.Key)]
+	logRecord.Value = kv[len(logRecord.Key):]
+	return logRecord, nil
+}
+
+// Same as ReadLogRecordAt, but if the IOManager can return bytes without copying,
+// the key and value of the record are slices of the IOManager, which are only valid before the file is closed
+func (df *DataFile) ViewLogRecordAt(offset int64, size uint32) (*LogRecord, error) {
+	reader, ok := df.IoManager.(fio.BytesReader)
+	if !ok {
+		return df.ReadLogRecordAt(offset, size)
+	}
+
+	buf, err := reader.Bytes(offset, int(size))
+	if err != nil {
+		return nil, err
+	}
+	logRecord, _, err := DecodeLogRecordWithChecksum(buf, df.Checksum())
+	if err != nil {
+		return nil, df.recordError(offset, err)
+	}
+	return logRecord, nil
+}
+
+// Read the records one by one from the first one, return the offset after the last record
+// The zero-filled tail of a pre-allocated file is not regarded as records
+func (df *DataFile) RecordsEnd() (int64, error) {
+	fileSize, err := df.IoManager.Size()
+	if err != nil {
+		return 0, err
+	}
+	offset := df.RecordsStart()
+	for {
+		_, size, err := df.ScanLogRecord(offset, fileSize)
+		if err == io.EOF {
+			return offset, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		offset += size
+	}
+}
+
+// Set the place where the file is written to when opening, offset is the end of the records read
+// The data after it is dropped only if it is the zero-filled tail of a pre-allocated file or a record not written completely
+// Otherwise it may be records after a corrupted one, they are kept and an error with the offset is returned
+func (df *DataFile) TruncateTail(offset int64) error {
+	size, err := df.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	if size > offset {
+		torn, err := df.isTornTail(offset, size)
+		if err != nil {
+			return err
+		}
+		if !torn {
+			return df.recordError(offset, ErrTrailingData)
+		}
+	}
+	return df.SetWriteOff(offset)
+}
+
+// Set the place where the file is written to
+// The data after it is dropped, it must be written by this process, use TruncateTail when opening
+func (df *DataFile) SetWriteOff(offset int64) error {
+	size, err := df.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	if size > offset {
+		if truncater, ok := df.IoManager.(fio.Truncater); ok {
+			if err := truncater.Truncate(offset); err != nil {
+				return err
+			}
+		}
+	}
+	df.WriteOff = offset
+	return nil
+}
+
+// Read the log records of positions, records close to each other are read in one read
+// positions must be in this file and sorted by offset
+func (df *DataFile) ReadLogRecords(positions []*LogRecordPos) ([]*LogRecord, error) {
+	records := make([]*LogRecord, len(positions))
+	for start := 0; start < len(positions); {
+		// Combine the following records into the block until the gap or the block is too large
+		blockStart := positions[start].Offset
+		blockEnd := blockStart + int64(positions[start].Size)
+		end := start + 1
+		for ; end < len(positions); end++ {
+			pos := positions[end]
+			posEnd := pos.Offset + int64(pos.Size)
+			if pos.Offset > blockEnd + maxReadGap || posEnd - blockStart > maxReadBlockSize {
+				break
+			}
+			if posEnd > blockEnd {
+				blockEnd = posEnd
+			}
+		}
+
+		buf, err := df.readNBytes(blockEnd - blockStart, blockStart)
+		if err != nil {
+			return nil, err
+		}
+		for i := start; i < end; i++ {
+			offset := positions[i].Offset - blockStart
+			logRecord, _, err := DecodeLogRecordWithChecksum(buf[offset : offset + int64(positions[i].Size)], df.Checksum())
+			if err != nil {
+				return nil, df.recordError(positions[i].Offset, err)
+			}
+			records[i] = logRecord
+		}
+		start = end
+	}
+	return records, nil
+}
+
 func (df *DataFile) readNBytes(n int64, offset int64) (b []byte, err error) {
 	b = make([]byte, n)
 	_, err = df.IoManager.Read(b, offset)
 	return b, err
 }
 
-func (df *DataFile) SetIOManager(driPath string, ioTpye fio.FileIOType) error {
+func (df *DataFile) SetIOManager(fs fio.FS, driPath string, ioTpye fio.FileIOType) error {
 	if err := df.IoManager.Close(); err != nil {
 		return err
 	}
-	ioManager, err := fio.NewIOManager(GetDataFileName(driPath, df.FileId), ioTpye)
+	ioManager, err := fio.NewIOManager(fs, GetDataFileName(driPath, df.FileId), ioTpye)
 	if err != nil {
 		return err
 	}
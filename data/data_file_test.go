@@ -2,28 +2,46 @@ package data
 
 import (
 	"bitcask-go/fio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
+// File system of the tests, BITCASK_TEST_FS=mem runs the tests in memory
+var testFS = newTestFS()
+
+func newTestFS() fio.FS {
+	if os.Getenv("BITCASK_TEST_FS") != "mem" {
+		return fio.OSFS
+	}
+	fs := fio.NewMemFS()
+	if err := fs.MkdirAll(os.TempDir(), os.ModePerm); err != nil {
+		panic(err)
+	}
+	return fs
+}
+
 func TestOpenDataFile(t *testing.T) {
-	dataFile1, err := OpenDataFile(os.TempDir(), 0, fio.StandardFIO)
+	dataFile1, err := OpenDataFile(testFS, os.TempDir(), 0, fio.StandardFIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile1)
 
-	dataFile2, err := OpenDataFile(os.TempDir(), 111, fio.StandardFIO)
+	dataFile2, err := OpenDataFile(testFS, os.TempDir(), 111, fio.StandardFIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile2)
 
-	dataFile3, err := OpenDataFile(os.TempDir(), 0, fio.StandardFIO)
+	dataFile3, err := OpenDataFile(testFS, os.TempDir(), 0, fio.StandardFIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile3)
 }
 
 func TestDataFile_Write(t *testing.T) {
-	dataFile, err := OpenDataFile(os.TempDir(), 0, fio.StandardFIO)
+	dataFile, err := OpenDataFile(testFS, os.TempDir(), 0, fio.StandardFIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 	
@@ -38,7 +56,7 @@ func TestDataFile_Write(t *testing.T) {
 }
 
 func TestDataFile_Close(t *testing.T) {
-	dataFile, err := OpenDataFile(os.TempDir(), 123, fio.StandardFIO)
+	dataFile, err := OpenDataFile(testFS, os.TempDir(), 123, fio.StandardFIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 	
@@ -50,7 +68,7 @@ func TestDataFile_Close(t *testing.T) {
 }
 
 func TestDataFile_Sync(t *testing.T) {
-	dataFile, err := OpenDataFile(os.TempDir(), 456, fio.StandardFIO)
+	dataFile, err := OpenDataFile(testFS, os.TempDir(), 456, fio.StandardFIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 	
@@ -62,7 +80,8 @@ func TestDataFile_Sync(t *testing.T) {
 }
 
 func TestDataFile_ReadLogRecord(t *testing.T) {
-	dataFile, err := OpenDataFile(os.TempDir(), 363, fio.StandardFIO)
+	dataFile, err := OpenDataFile(testFS, os.TempDir(), 363, fio.StandardFIO)
+	defer testFS.Remove(GetDataFileName(os.TempDir(), 363))
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -71,10 +90,11 @@ func TestDataFile_ReadLogRecord(t *testing.T) {
 		Key: []byte("name"),
 		Value: []byte("bicask kv go"),
 	}
-	res1, size1 := EncodeLogRecord(rec1)
+	res1, size1 := dataFile.EncodeLogRecord(rec1)
 	err = dataFile.Write(res1)
 	assert.Nil(t, err)
-	readRec1, readSize1, err := dataFile.ReadLogRecord(0) // Offset is 0. Read from beginning
+	start := dataFile.RecordsStart() // Records start after the header
+	readRec1, readSize1, err := dataFile.ReadLogRecord(start)
 	assert.Nil(t, err)
 	assert.Equal(t, rec1, readRec1)
 	assert.Equal(t, size1, readSize1)
@@ -85,10 +105,10 @@ func TestDataFile_ReadLogRecord(t *testing.T) {
 		Key: []byte("name"),
 		Value: []byte("a new value"),
 	}
-	res2, size2 := EncodeLogRecord(rec2)
+	res2, size2 := dataFile.EncodeLogRecord(rec2)
 	err = dataFile.Write(res2)
 	assert.Nil(t, err)
-	readRec2, readSize2, err := dataFile.ReadLogRecord(size1)
+	readRec2, readSize2, err := dataFile.ReadLogRecord(start + size1)
 	assert.Nil(t, err)
 	assert.Equal(t, rec2, readRec2)
 	assert.Equal(t, size2, readSize2)
@@ -100,12 +120,447 @@ func TestDataFile_ReadLogRecord(t *testing.T) {
 		Value: []byte("0"),
 		Type: LogRecordDeleted,
 	}
-	res3, size3 := EncodeLogRecord(rec3)
+	res3, size3 := dataFile.EncodeLogRecord(rec3)
 	err = dataFile.Write(res3)
 	assert.Nil(t, err)
 	t.Log(size3)
-	readRec3, readSize3, err := dataFile.ReadLogRecord(size1 + size2)
+	readRec3, readSize3, err := dataFile.ReadLogRecord(start + size1 + size2)
 	assert.Nil(t, err)
 	assert.Equal(t, rec3, readRec3)
 	assert.Equal(t, size3, readSize3)	
-}
\ No newline at end of file
+}
+func TestDataFile_ReadLogRecords(t *testing.T) {
+	dataFile, err := OpenDataFile(testFS, os.TempDir(), 364, fio.StandardFIO)
+	defer testFS.Remove(GetDataFileName(os.TempDir(), 364))
+	assert.Nil(t, err)
+	assert.NotNil(t, dataFile)
+
+	// Records next to each other, and a record far away from them
+	var records []*LogRecord
+	var positions []*LogRecordPos
+	offset := dataFile.RecordsStart()
+	for i := 0; i < 4; i++ {
+		value := []byte(fmt.Sprintf("value-%d", i))
+		if i == 3 {
+			// Leave a large gap before the last record
+			err = dataFile.Write(make([]byte, 2 * maxReadGap))
+			assert.Nil(t, err)
+			offset += 2 * maxReadGap
+		}
+		rec := &LogRecord{Key: []byte(fmt.Sprintf("key-%d", i)), Value: value}
+		enc, size := dataFile.EncodeLogRecord(rec)
+		err = dataFile.Write(enc)
+		assert.Nil(t, err)
+		records = append(records, rec)
+		positions = append(positions, &LogRecordPos{Fid: 364, Offset: offset, Size: uint32(size)})
+		offset += size
+	}
+
+	// The same position can be read more than once
+	positions = append(positions[:2], positions[1:]...)
+	records = append(records[:2], records[1:]...)
+
+	readRecords, err := dataFile.ReadLogRecords(positions)
+	assert.Nil(t, err)
+	assert.Equal(t, len(records), len(readRecords))
+	for i := range records {
+		assert.Equal(t, records[i].Key, readRecords[i].Key)
+		assert.Equal(t, records[i].Value, readRecords[i].Value)
+	}
+
+	// Position doesn't match the record
+	_, err = dataFile.ReadLogRecords([]*LogRecordPos{{Fid: 364, Offset: positions[0].Offset + 1, Size: positions[0].Size}})
+	assert.NotNil(t, err)
+}
+
+func TestDataFile_ReadLogRecordAt(t *testing.T) {
+	dataFile, err := OpenDataFile(testFS, os.TempDir(), 365, fio.StandardFIO)
+	defer testFS.Remove(GetDataFileName(os.TempDir(), 365))
+	assert.Nil(t, err)
+	assert.NotNil(t, dataFile)
+
+	// A small record and a record larger than the pooled buffers
+	rec1 := &LogRecord{Key: []byte("name"), Value: []byte("bitcask-go")}
+	rec2 := &LogRecord{Key: []byte("big"), Value: make([]byte, 2 * maxPooledBufferSize)}
+	enc1, size1 := dataFile.EncodeLogRecord(rec1)
+	enc2, size2 := dataFile.EncodeLogRecord(rec2)
+	start := dataFile.RecordsStart()
+	err = dataFile.Write(enc1)
+	assert.Nil(t, err)
+	err = dataFile.Write(enc2)
+	assert.Nil(t, err)
+
+	readRec1, err := dataFile.ReadLogRecordAt(start, uint32(size1))
+	assert.Nil(t, err)
+	assert.Equal(t, rec1.Key, readRec1.Key)
+	assert.Equal(t, rec1.Value, readRec1.Value)
+
+	readRec2, err := dataFile.ReadLogRecordAt(start + size1, uint32(size2))
+	assert.Nil(t, err)
+	assert.Equal(t, rec2.Key, readRec2.Key)
+	assert.Equal(t, rec2.Value, readRec2.Value)
+
+	// The record read before is not changed by the reused buffer
+	_, err = dataFile.ReadLogRecordAt(start, uint32(size1))
+	assert.Nil(t, err)
+	assert.Equal(t, rec1.Value, readRec1.Value)
+
+	// Wrong size
+	_, err = dataFile.ReadLogRecordAt(start, uint32(size1 - 1))
+	assert.NotNil(t, err)
+
+	// Scan with the size got once
+	fileSize, err := dataFile.IoManager.Size()
+	assert.Nil(t, err)
+	offset := start
+	var count int
+	for {
+		_, size, err := dataFile.ScanLogRecord(offset, fileSize)
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		offset += size
+		count++
+	}
+	assert.Equal(t, 2, count)
+}
+
+func TestDataFile_Header(t *testing.T) {
+	fileName := GetDataFileName(os.TempDir(), 366)
+	defer testFS.Remove(fileName)
+
+	// A new file gets a header
+	dataFile, err := OpenDataFile(testFS, os.TempDir(), 366, fio.StandardFIO)
+	assert.Nil(t, err)
+	assert.NotNil(t, dataFile.Header)
+	assert.Equal(t, CurrentFileFormat, dataFile.Header.Version)
+	assert.Equal(t, DefaultChecksum, dataFile.Checksum())
+	assert.Equal(t, int64(FileHeaderSize), dataFile.RecordsStart())
+	assert.Equal(t, int64(FileHeaderSize), dataFile.WriteOff)
+	rec := &LogRecord{Key: []byte("name"), Value: []byte("bitcask-go")}
+	err = dataFile.WriteLogRecord(rec)
+	assert.Nil(t, err)
+	createdAt := dataFile.Header.CreatedAt
+	err = dataFile.Close()
+	assert.Nil(t, err)
+
+	// Opened again, the header is read, not written
+	dataFile, err = OpenDataFile(testFS, os.TempDir(), 366, fio.StandardFIO)
+	assert.Nil(t, err)
+	assert.Equal(t, createdAt.UnixNano(), dataFile.Header.CreatedAt.UnixNano())
+	end, err := dataFile.RecordsEnd()
+	assert.Nil(t, err)
+	readRec, size, err := dataFile.ReadLogRecord(dataFile.RecordsStart())
+	assert.Nil(t, err)
+	assert.Equal(t, rec, readRec)
+	assert.Equal(t, end, dataFile.RecordsStart() + size)
+	err = dataFile.Close()
+	assert.Nil(t, err)
+
+	// Read only memory map doesn't write a header to an empty file
+	err = testFS.Remove(fileName)
+	assert.Nil(t, err)
+	file, err := testFS.OpenFile(fileName, os.O_CREATE|os.O_RDWR, fio.DataFilePerm)
+	assert.Nil(t, err)
+	_ = file.Close()
+	dataFile, err = OpenDataFile(testFS, os.TempDir(), 366, fio.MemoryMap)
+	assert.Nil(t, err)
+	assert.Nil(t, dataFile.Header)
+	_, _, err = dataFile.ReadLogRecord(0)
+	assert.Equal(t, io.EOF, err)
+	err = dataFile.Close()
+	assert.Nil(t, err)
+
+	// A zero-filled file gets a header
+	file, err = testFS.OpenFile(fileName, os.O_RDWR, fio.DataFilePerm)
+	assert.Nil(t, err)
+	_, err = file.Write(make([]byte, 100))
+	assert.Nil(t, err)
+	_ = file.Close()
+	dataFile, err = OpenDataFile(testFS, os.TempDir(), 366, fio.StandardFIO)
+	assert.Nil(t, err)
+	assert.NotNil(t, dataFile.Header)
+	fileSize, err := dataFile.IoManager.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(FileHeaderSize), fileSize)
+	err = dataFile.Close()
+	assert.Nil(t, err)
+
+	// Corrupted header
+	file, err = testFS.OpenFile(fileName, os.O_RDWR, fio.DataFilePerm)
+	assert.Nil(t, err)
+	_, err = file.WriteAt([]byte{0xff}, 10)
+	assert.Nil(t, err)
+	_ = file.Close()
+	_, err = OpenDataFile(testFS, os.TempDir(), 366, fio.StandardFIO)
+	assert.Equal(t, ErrInvalidFileHeader, err)
+}
+
+// Files written before the header was added
+func TestDataFile_FormatV0(t *testing.T) {
+	fileName := GetDataFileName(os.TempDir(), 367)
+	defer testFS.Remove(fileName)
+
+	rec1 := &LogRecord{Key: []byte("name"), Value: []byte("bitcask-go")}
+	rec2 := &LogRecord{Key: []byte("name"), Type: LogRecordDeleted}
+	enc1, size1 := EncodeLogRecord(rec1)
+	enc2, size2 := EncodeLogRecord(rec2)
+	file, err := testFS.OpenFile(fileName, os.O_CREATE|os.O_RDWR|os.O_TRUNC, fio.DataFilePerm)
+	assert.Nil(t, err)
+	_, err = file.Write(append(enc1, enc2...))
+	assert.Nil(t, err)
+	_ = file.Close()
+
+	for _, ioType := range []fio.FileIOType{fio.StandardFIO, fio.MemoryMap} {
+		dataFile, err := OpenDataFile(testFS, os.TempDir(), 367, ioType)
+		assert.Nil(t, err)
+		assert.Nil(t, dataFile.Header)
+		assert.Equal(t, ChecksumCRC32IEEE, dataFile.Checksum())
+		assert.Equal(t, int64(0), dataFile.RecordsStart())
+
+		readRec1, readSize1, err := dataFile.ReadLogRecord(0)
+		assert.Nil(t, err)
+		assert.Equal(t, rec1, readRec1)
+		assert.Equal(t, size1, readSize1)
+		readRec2, err := dataFile.ReadLogRecordAt(size1, uint32(size2))
+		assert.Nil(t, err)
+		assert.Equal(t, rec2.Key, readRec2.Key)
+		assert.Equal(t, rec2.Type, readRec2.Type)
+		end, err := dataFile.RecordsEnd()
+		assert.Nil(t, err)
+		assert.Equal(t, size1 + size2, end)
+		err = dataFile.Close()
+		assert.Nil(t, err)
+	}
+
+	// New records are written in the format of the file
+	dataFile, err := OpenDataFile(testFS, os.TempDir(), 367, fio.StandardFIO)
+	assert.Nil(t, err)
+	err = dataFile.SetWriteOff(size1 + size2)
+	assert.Nil(t, err)
+	rec3 := &LogRecord{Key: []byte("name"), Value: []byte("new value")}
+	err = dataFile.WriteLogRecord(rec3)
+	assert.Nil(t, err)
+	readRec3, _, err := dataFile.ReadLogRecord(size1 + size2)
+	assert.Nil(t, err)
+	assert.Equal(t, rec3, readRec3)
+	err = dataFile.Close()
+	assert.Nil(t, err)
+}
+
+func TestDataFile_CorruptedRecord(t *testing.T) {
+	fs := fio.NewMemFS()
+	dataFile, err := OpenDataFile(fs, "/", 368, fio.StandardFIO)
+	assert.Nil(t, err)
+	rec := &LogRecord{Key: []byte("name"), Value: []byte("bitcask-go")}
+	for i := 0; i < 3; i++ {
+		err = dataFile.WriteLogRecord(rec)
+		assert.Nil(t, err)
+	}
+	enc, size := dataFile.EncodeLogRecord(rec)
+	second := dataFile.RecordsStart() + size
+	file, err := fs.OpenFile(GetDataFileName("/", 368), os.O_RDWR, fio.DataFilePerm)
+	assert.Nil(t, err)
+
+	// Flipped bit in the value
+	_, err = file.WriteAt([]byte{enc[len(enc) - 1] ^ 1}, second + size - 1)
+	assert.Nil(t, err)
+	_, _, err = dataFile.ReadLogRecord(second)
+	assert.True(t, errors.Is(err, ErrInvalidCRC))
+	assert.Contains(t, err.Error(), fmt.Sprintf("file 368, offset %d", second))
+	_, err = dataFile.ReadLogRecordAt(second, uint32(size))
+	assert.True(t, errors.Is(err, ErrInvalidCRC))
+
+	// Negative value size in the header
+	_, err = file.WriteAt([]byte{0x7f}, second + 6)
+	assert.Nil(t, err)
+	_, _, err = dataFile.ReadLogRecord(second)
+	assert.True(t, errors.Is(err, ErrInvalidLogRecordHeader))
+
+	// A huge value size is checked against the file size, nothing is allocated for it
+	_, err = file.WriteAt(binary.AppendVarint(nil, 1 << 30), second + 6)
+	assert.Nil(t, err)
+	_, _, err = dataFile.ReadLogRecord(second)
+	assert.True(t, errors.Is(err, ErrInvalidLogRecordSize))
+
+	// Offset outside the file
+	_, _, err = dataFile.ReadLogRecord(1 << 40)
+	assert.Equal(t, io.EOF, err)
+	_, _, err = dataFile.ReadLogRecord(-1)
+	assert.True(t, errors.Is(err, ErrInvalidLogRecordPos))
+	_ = file.Close()
+}
+
+func TestDataFile_CorruptedRecordSize(t *testing.T) {
+	fs := fio.NewMemFS()
+	dataFile, err := OpenDataFile(fs, "/", 369, fio.StandardFIO)
+	assert.Nil(t, err)
+	rec := &LogRecord{Key: []byte("name"), Value: []byte("bitcask-go")}
+	for i := 0; i < 3; i++ {
+		err = dataFile.WriteLogRecord(rec)
+		assert.Nil(t, err)
+	}
+	enc, size := dataFile.EncodeLogRecord(rec)
+	second := dataFile.RecordsStart() + size
+	fileSize, err := dataFile.IoManager.Size()
+	assert.Nil(t, err)
+	file, err := fs.OpenFile(GetDataFileName("/", 369), os.O_RDWR, fio.DataFilePerm)
+	assert.Nil(t, err)
+	defer file.Close()
+
+	// Flipped bit in the value size of the record in the middle, it runs past the end of the file
+	// It is not the end of the file, the third record is not skipped silently
+	_, err = file.WriteAt([]byte{enc[6] ^ 0x40}, second + 6)
+	assert.Nil(t, err)
+	_, _, err = dataFile.ScanLogRecord(second, fileSize)
+	assert.True(t, errors.Is(err, ErrInvalidLogRecordSize))
+	assert.Contains(t, err.Error(), fmt.Sprintf("file 369, offset %d", second))
+	_, err = dataFile.RecordsEnd()
+	assert.True(t, errors.Is(err, ErrInvalidLogRecordSize))
+
+	// Header of all 0 in the middle is not the end either
+	_, err = file.WriteAt(make([]byte, 7), second)
+	assert.Nil(t, err)
+	_, _, err = dataFile.ScanLogRecord(second, fileSize)
+	assert.True(t, errors.Is(err, ErrInvalidLogRecordHeader))
+	assert.Contains(t, err.Error(), fmt.Sprintf("file 369, offset %d", second))
+
+	// The last record is not written completely, it is the end of the file
+	_, err = file.WriteAt(enc[:7], second)
+	assert.Nil(t, err)
+	err = file.Truncate(fileSize - 3)
+	assert.Nil(t, err)
+	_, _, err = dataFile.ScanLogRecord(second + size, fileSize - 3)
+	assert.Equal(t, io.EOF, err)
+	end, err := dataFile.RecordsEnd()
+	assert.Nil(t, err)
+	assert.Equal(t, second + size, end)
+
+	// Same with the zero-filled tail of a pre-allocated file after it, the record fits in the file but its crc is wrong
+	err = file.Truncate(fileSize + 4096)
+	assert.Nil(t, err)
+	end, err = dataFile.RecordsEnd()
+	assert.Nil(t, err)
+	assert.Equal(t, second + size, end)
+}
+
+func TestDataFile_TruncateTail(t *testing.T) {
+	fs := fio.NewMemFS()
+	dataFile, err := OpenDataFile(fs, "/", 370, fio.StandardFIO)
+	assert.Nil(t, err)
+	rec := &LogRecord{Key: []byte("name"), Value: []byte("bitcask-go")}
+	for i := 0; i < 2; i++ {
+		err = dataFile.WriteLogRecord(rec)
+		assert.Nil(t, err)
+	}
+	enc, size := dataFile.EncodeLogRecord(rec)
+	second := dataFile.RecordsStart() + size
+
+	// The second record is complete, it is not dropped
+	err = dataFile.TruncateTail(second)
+	assert.True(t, errors.Is(err, ErrTrailingData))
+	assert.Contains(t, err.Error(), fmt.Sprintf("file 370, offset %d", second))
+	fileSize, err := dataFile.IoManager.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, second + size, fileSize)
+
+	// A record not written completely is dropped
+	err = dataFile.Write(enc[:size - 3])
+	assert.Nil(t, err)
+	err = dataFile.TruncateTail(second + size)
+	assert.Nil(t, err)
+	fileSize, err = dataFile.IoManager.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, second + size, fileSize)
+	assert.Equal(t, second + size, dataFile.WriteOff)
+}
+
+// IOManager counting the bytes read
+type countingIOManager struct {
+	fio.IOManager
+	read int64
+}
+
+func (c *countingIOManager) Read(b []byte, offset int64) (int, error) {
+	c.read += int64(len(b))
+	return c.IOManager.Read(b, offset)
+}
+
+func TestDataFile_LargePreallocatedFile(t *testing.T) {
+	fs := fio.NewMemFS()
+	dataFile, err := OpenDataFile(fs, "/", 371, fio.StandardFIO)
+	assert.Nil(t, err)
+	rec := &LogRecord{Key: []byte("name"), Value: []byte("bitcask-go")}
+	big := &LogRecord{Key: []byte("big"), Value: make([]byte, 2 * maxReadBlockSize)}
+	for _, r := range []*LogRecord{rec, big, rec} {
+		err = dataFile.WriteLogRecord(r)
+		assert.Nil(t, err)
+	}
+	written := dataFile.WriteOff
+	file, err := fs.OpenFile(GetDataFileName("/", 371), os.O_RDWR, fio.DataFilePerm)
+	assert.Nil(t, err)
+	defer file.Close()
+
+	// Only one block of the zero-filled tail is read
+	err = file.Truncate(64 * maxReadBlockSize)
+	assert.Nil(t, err)
+	counting := &countingIOManager{IOManager: dataFile.IoManager}
+	dataFile.IoManager = counting
+	end, err := dataFile.RecordsEnd()
+	assert.Nil(t, err)
+	assert.Equal(t, written, end)
+	assert.Less(t, counting.read, written + 2 * maxReadBlockSize)
+
+	// The record after a corrupted value larger than the block is found, it is not the end of the file
+	_, recSize := dataFile.EncodeLogRecord(rec)
+	_, err = file.WriteAt([]byte{1}, dataFile.RecordsStart() + recSize + 100)
+	assert.Nil(t, err)
+	_, err = dataFile.RecordsEnd()
+	assert.True(t, errors.Is(err, ErrInvalidCRC))
+}
+
+func FuzzReadLogRecord(f *testing.F) {
+	var records []byte
+	for _, rec := range []*LogRecord{
+		{Key: []byte("name"), Value: []byte("bitcask-go")},
+		{Key: []byte("name"), Type: LogRecordDeleted},
+		{Key: []byte("big"), Value: make([]byte, 1000)},
+	} {
+		enc, _ := EncodeLogRecordWithChecksum(rec, DefaultChecksum)
+		records = append(records, enc...)
+	}
+	f.Add(records)
+	f.Add(records[:len(records) - 10])
+	f.Add([]byte{1, 2, 3, 4, 0, 0xfe, 0xff, 0xff, 0xff, 0x0f, 0})
+
+	f.Fuzz(func(t *testing.T, records []byte) {
+		fs := fio.NewMemFS()
+		dataFile, err := OpenDataFile(fs, "/", 0, fio.StandardFIO)
+		assert.Nil(t, err)
+		err = dataFile.Write(records)
+		assert.Nil(t, err)
+		fileSize, err := dataFile.IoManager.Size()
+		assert.Nil(t, err)
+
+		// Read the records as loading does, every record is inside the file
+		offset := dataFile.RecordsStart()
+		for {
+			logRecord, size, err := dataFile.ScanLogRecord(offset, fileSize)
+			if err != nil {
+				break
+			}
+			assert.True(t, size > 0)
+			assert.LessOrEqual(t, offset + size, fileSize)
+			readRecord, err := dataFile.ReadLogRecordAt(offset, uint32(size))
+			assert.Nil(t, err)
+			assert.Equal(t, logRecord.Key, readRecord.Key)
+			offset += size
+		}
+		// A corrupted record gives an error telling where it is
+		_, err = dataFile.RecordsEnd()
+		if err != nil {
+			assert.Contains(t, err.Error(), "(file 0, offset")
+		}
+	})
+}
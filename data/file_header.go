@@ -0,0 +1,106 @@
+package data
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"time"
+)
+
+// Algorithm of the checksum of the log records
+type ChecksumType = byte
+
+const (
+	// Used by the files without a header
+	ChecksumCRC32IEEE ChecksumType = iota
+	// Castagnoli polynomial, computed by hardware instructions on most platforms
+	ChecksumCRC32C
+)
+
+const (
+	// Version of the files without a header
+	FileFormatV0 uint16 = 0
+	// Files start with a header
+	FileFormatV1 uint16 = 1
+
+	// Version of the newly created files
+	CurrentFileFormat = FileFormatV1
+	// Checksum of the records in the newly created files
+	DefaultChecksum = ChecksumCRC32C
+
+	// Length of the header, records start after it
+	FileHeaderSize = 32
+)
+
+// "BCKV" in little endian
+const fileMagic uint32 = 0x564b4342
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Header at the beginning of data, hint, seq-no and merge finished files
+// *---------*---------*---------*---------*---------*---------*---------*
+// |  magic  | version |checksum |reserved |  ctime  |reserved |   crc   |
+// *---------*---------*---------*---------*---------*---------*---------*
+//      4         2         1         1         8        12         4
+// ctime is unix nano, crc is CRC32C of the bytes before it
+type FileHeader struct {
+	Version uint16
+	Checksum ChecksumType
+	CreatedAt time.Time
+}
+
+func newFileHeader() *FileHeader {
+	return &FileHeader{
+		Version: CurrentFileFormat,
+		Checksum: DefaultChecksum,
+		CreatedAt: time.Now(),
+	}
+}
+
+// Encode the header into FileHeaderSize bytes
+func (h *FileHeader) Encode() []byte {
+	buf := make([]byte, FileHeaderSize)
+	binary.LittleEndian.PutUint32(buf[0:4], fileMagic)
+	binary.LittleEndian.PutUint16(buf[4:6], h.Version)
+	buf[6] = h.Checksum
+	binary.LittleEndian.PutUint64(buf[8:16], uint64(h.CreatedAt.UnixNano()))
+	binary.LittleEndian.PutUint32(buf[28:32], crc32.Checksum(buf[:28], castagnoliTable))
+	return buf
+}
+
+// Does buf start with the magic of the header?
+func hasFileMagic(buf []byte) bool {
+	return len(buf) >= 4 && binary.LittleEndian.Uint32(buf[:4]) == fileMagic
+}
+
+// Decode the header, buf must start with the magic
+func DecodeFileHeader(buf []byte) (*FileHeader, error) {
+	if len(buf) < FileHeaderSize || !hasFileMagic(buf) {
+		return nil, ErrInvalidFileHeader
+	}
+	if crc32.Checksum(buf[:28], castagnoliTable) != binary.LittleEndian.Uint32(buf[28:32]) {
+		return nil, ErrInvalidFileHeader
+	}
+	h := &FileHeader{
+		Version: binary.LittleEndian.Uint16(buf[4:6]),
+		Checksum: buf[6],
+		CreatedAt: time.Unix(0, int64(binary.LittleEndian.Uint64(buf[8:16]))),
+	}
+	if h.Version == FileFormatV0 || h.Version > CurrentFileFormat {
+		return nil, ErrUnsupportedFileFormat
+	}
+	if checksumTable(h.Checksum) == nil {
+		return nil, ErrUnsupportedChecksum
+	}
+	return h, nil
+}
+
+// Table of the checksum, nil if the type is unknown
+func checksumTable(checksum ChecksumType) *crc32.Table {
+	switch checksum {
+	case ChecksumCRC32IEEE:
+		return crc32.IEEETable
+	case ChecksumCRC32C:
+		return castagnoliTable
+	}
+	return nil
+}
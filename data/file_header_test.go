@@ -0,0 +1,60 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileHeader_Encode(t *testing.T) {
+	header := newFileHeader()
+	buf := header.Encode()
+	assert.Equal(t, FileHeaderSize, len(buf))
+	assert.True(t, hasFileMagic(buf))
+
+	decoded, err := DecodeFileHeader(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, CurrentFileFormat, decoded.Version)
+	assert.Equal(t, DefaultChecksum, decoded.Checksum)
+	assert.Equal(t, header.CreatedAt.UnixNano(), decoded.CreatedAt.UnixNano())
+
+	// Corrupted
+	corrupted := append([]byte(nil), buf...)
+	corrupted[10]++
+	_, err = DecodeFileHeader(corrupted)
+	assert.Equal(t, ErrInvalidFileHeader, err)
+
+	// Too short
+	_, err = DecodeFileHeader(buf[:FileHeaderSize - 1])
+	assert.Equal(t, ErrInvalidFileHeader, err)
+}
+
+func TestFileHeader_Unsupported(t *testing.T) {
+	// Newer version
+	header := &FileHeader{Version: CurrentFileFormat + 1, Checksum: DefaultChecksum, CreatedAt: time.Now()}
+	_, err := DecodeFileHeader(header.Encode())
+	assert.Equal(t, ErrUnsupportedFileFormat, err)
+
+	// Unknown checksum
+	header = &FileHeader{Version: CurrentFileFormat, Checksum: 100, CreatedAt: time.Now()}
+	_, err = DecodeFileHeader(header.Encode())
+	assert.Equal(t, ErrUnsupportedChecksum, err)
+
+	// Old checksum in the new format
+	header = &FileHeader{Version: CurrentFileFormat, Checksum: ChecksumCRC32IEEE, CreatedAt: time.Now()}
+	decoded, err := DecodeFileHeader(header.Encode())
+	assert.Nil(t, err)
+	assert.Equal(t, ChecksumCRC32IEEE, decoded.Checksum)
+}
+
+func TestIsNewFile(t *testing.T) {
+	assert.True(t, isNewFile(nil))
+	assert.True(t, isNewFile(make([]byte, FileHeaderSize)))
+	// Part of a header
+	assert.True(t, isNewFile(newFileHeader().Encode()[:10]))
+
+	// A record of format v0
+	enc, _ := EncodeLogRecord(&LogRecord{Key: []byte("name"), Value: []byte("bitcask-go")})
+	assert.False(t, isNewFile(enc))
+}
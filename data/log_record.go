@@ -3,6 +3,8 @@ package data
 import (
 	"encoding/binary"
 	"hash/crc32"
+	"io"
+	"math"
 )
 
 // A signal of whether this data means deletion
@@ -12,8 +14,18 @@ const (
 	LogRecordNormal LogRecordType = iota
 	LogRecordDeleted
 	LogRecordFinished
+	// Key is the start of the range, value is the end (exclusive, empty means no end)
+	LogRecordRangeDeleted
+	// Value is the position of the previous record of the key and the operand, see EncodeMergeOperand
+	LogRecordMergeOperand
 )
 
+// The largest type of the records, a larger type in a header means the record is corrupted
+const maxLogRecordType = LogRecordMergeOperand
+
+// The size of a record is saved in uint32 by LogRecordPos
+const MaxLogRecordSize = math.MaxUint32
+
 // crc type(deleted?) keySize valueSize
 //  4 +      1       +   5   +    5    = 15
 // keySize and valueSize are changeable
@@ -29,10 +41,11 @@ type LogRecord struct{
 
 // Index of data on RAM (in-memory)
 // describe data's postion on disk (ie. keydir)
+// Fid and Size are next to each other, so the struct is 16 bytes without padding
 type LogRecordPos struct {
 	Fid uint32
-	Offset int64
 	Size uint32                 // Size of the data on the disk
+	Offset int64
 }
 
 // Header of LogRecord
@@ -55,7 +68,13 @@ type TransactionRecord struct {
 // |   crc   |  type   | keySize |valueSize|   key   |  value  |
 // *---------*---------*---------*---------*---------*---------*
 //      4         1       max 5     max 5    variant    variant
+// The crc is CRC32 IEEE, as in the files without a header
 func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64){
+	return EncodeLogRecordWithChecksum(logRecord, ChecksumCRC32IEEE)
+}
+
+// Encode LogRecord with the checksum algorithm of the file it is written to
+func EncodeLogRecordWithChecksum(logRecord *LogRecord, checksum ChecksumType) ([]byte, int64){
 	// Initialize a header
 	header := make([]byte, maxLogRecordHeaderSize)
 
@@ -80,7 +99,7 @@ func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64){
 	copy(encBytes[index + len(logRecord.Key):], logRecord.Value)
 
 	// Calculate crc
-	crc := crc32.ChecksumIEEE(encBytes[4:])
+	crc := crc32.Checksum(encBytes[4:], checksumTable(checksum))
 	// Main platforms use little endian
 	binary.LittleEndian.PutUint32(encBytes[:4], crc)
 
@@ -97,56 +116,154 @@ func EncodeLogRecordPos(pos *LogRecordPos) []byte {
 	return buf[:index]
 }
 
+// Encode the value of a merge operand record
+// prev is the position of the previous record of the same key, nil if there is no previous record
+// *-------------*-------------*-------------*
+// |   posSize   |     pos     |   operand   |
+// *-------------*-------------*-------------*
+//     max 5        variant       variant
+func EncodeMergeOperand(prev *LogRecordPos, operand []byte) []byte {
+	var encPos []byte
+	if prev != nil {
+		encPos = EncodeLogRecordPos(prev)
+	}
+	buf := make([]byte, binary.MaxVarintLen32 + len(encPos) + len(operand))
+	var index = 0
+	index += binary.PutUvarint(buf[index:], uint64(len(encPos)))
+	index += copy(buf[index:], encPos)
+	index += copy(buf[index:], operand)
+	return buf[:index]
+}
+
+// Decode the value of a merge operand record
+func DecodeMergeOperand(buf []byte) (*LogRecordPos, []byte, error) {
+	posSize, n := binary.Uvarint(buf)
+	if n <= 0 || posSize > uint64(len(buf) - n) {
+		return nil, nil, ErrInvalidMergeOperand
+	}
+	var prev *LogRecordPos
+	if posSize > 0 {
+		pos, err := DecodeLogRecordPos(buf[n : n + int(posSize)])
+		if err != nil {
+			return nil, nil, err
+		}
+		prev = pos
+	}
+	return prev, buf[n + int(posSize):], nil
+}
+
+// Decode a whole LogRecord from the byte slice, return the record and its length
+// Key and value of the record share the memory of buf
+// The crc is CRC32 IEEE, as in the files without a header
+func DecodeLogRecord(buf []byte) (*LogRecord, int64, error) {
+	return DecodeLogRecordWithChecksum(buf, ChecksumCRC32IEEE)
+}
+
+// Decode a whole LogRecord with the checksum algorithm of the file it is read from
+func DecodeLogRecordWithChecksum(buf []byte, checksum ChecksumType) (*LogRecord, int64, error) {
+	header, headerSize, err := decodeLogRecordHeader(buf)
+	if err != nil {
+		return nil, 0, err
+	}
+	if header.crc == 0 && header.keySize == 0 && header.valueSize == 0 {
+		return nil, 0, io.EOF
+	}
+
+	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
+	var recordSize = headerSize + keySize + valueSize
+	if recordSize > int64(len(buf)) {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
+	logRecord := &LogRecord{
+		Key: buf[headerSize : headerSize + keySize],
+		Value: buf[headerSize + keySize : recordSize],
+		Type: header.recordType,
+	}
+	crc := getLogRecordChecksum(logRecord, buf[crc32.Size : headerSize], checksum)
+	if crc != header.crc {
+		return nil, 0, ErrInvalidCRC
+	}
+	return logRecord, recordSize, nil
+}
+
 // Decode header of the byte slice 
-func decodeLogRecordHeader(buf []byte) (*logRecordHeader, int64) {
+// io.EOF is returned if buf is even shorter than crc and type, io.ErrUnexpectedEOF if buf ends in the sizes
+// The sizes are checked, so that a corrupted header cannot make the caller read a huge record
+func decodeLogRecordHeader(buf []byte) (*logRecordHeader, int64, error) {
 	if len(buf) <= 4 {
-		// Header's length is even smaller than crc. Something went wrong
-		return nil, 0
+		// Header's length is even smaller than crc
+		return nil, 0, io.EOF
 	}
 
 	header := &logRecordHeader{
 		crc: binary.LittleEndian.Uint32(buf[:4]),
 		recordType: buf[4],
 	}
+	if header.recordType > maxLogRecordType {
+		return nil, 0, ErrInvalidLogRecordHeader
+	}
 
 	var index = 5
-	// Get key size
+	// Get key size and value size
 	// Varint has a way to indicate the end of the number when encoding
-	keySize, n := binary.Varint(buf[index:])
-	header.keySize = uint32(keySize)
-	index += n
-
-	// Get value size
-	valueSize, n := binary.Varint(buf[index:])
-	header.valueSize = uint32(valueSize)
-	index += n
+	var sizes [2]int64
+	for i := range sizes {
+		size, n := binary.Varint(buf[index:])
+		if n == 0 {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+		if n < 0 || size < 0 || size > MaxLogRecordSize {
+			return nil, 0, ErrInvalidLogRecordHeader
+		}
+		sizes[i] = size
+		index += n
+	}
+	if int64(index) + sizes[0] + sizes[1] > MaxLogRecordSize {
+		return nil, 0, ErrInvalidLogRecordHeader
+	}
+	header.keySize = uint32(sizes[0])
+	header.valueSize = uint32(sizes[1])
 
-	return header, int64(index)
+	return header, int64(index), nil
 }
 
 // Decode LogRecordPos
-func DecodeLogRecordPos(buf []byte) *LogRecordPos {
+func DecodeLogRecordPos(buf []byte) (*LogRecordPos, error) {
 	var index = 0
-	fileId, n := binary.Varint(buf[index:])
-	index += n
-	offset, n := binary.Varint(buf[index:])
-	index += n
-	size, _ := binary.Varint(buf[index:])
+	var values [3]int64
+	for i := range values {
+		value, n := binary.Varint(buf[index:])
+		if n <= 0 || value < 0 {
+			return nil, ErrInvalidLogRecordPos
+		}
+		values[i] = value
+		index += n
+	}
+	fileId, offset, size := values[0], values[1], values[2]
+	if fileId > math.MaxUint32 || size > MaxLogRecordSize {
+		return nil, ErrInvalidLogRecordPos
+	}
 	return &LogRecordPos{
 		Fid: uint32(fileId),
 		Offset: offset,
 		Size: uint32(size),
-	}
+	}, nil
 }
 
 // The second parameter doesn't contain crc itself
 func getLogRecordCRC(lr *LogRecord, header []byte) uint32 {
+	return getLogRecordChecksum(lr, header, ChecksumCRC32IEEE)
+}
+
+func getLogRecordChecksum(lr *LogRecord, header []byte, checksum ChecksumType) uint32 {
 	if lr == nil {
 		return 0
 	}
-	crc := crc32.ChecksumIEEE(header)
-	crc = crc32.Update(crc, crc32.IEEETable, lr.Key)
-	crc = crc32.Update(crc, crc32.IEEETable, lr.Value)
+	table := checksumTable(checksum)
+	crc := crc32.Checksum(header, table)
+	crc = crc32.Update(crc, table, lr.Key)
+	crc = crc32.Update(crc, table, lr.Value)
 	return crc
 }
 
@@ -1,7 +1,10 @@
 package data
 
 import (
+	"encoding/binary"
 	"hash/crc32"
+	"io"
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -45,7 +48,8 @@ func TestEncodeLogRecord(t *testing.T) {
 func TestDecodeLogRecordHeader(t *testing.T) {
 	// Normal case
 	headerBuf1 := []byte{104, 82, 240, 150, 0, 8, 20}
-	h1, size1 := decodeLogRecordHeader(headerBuf1)
+	h1, size1, err := decodeLogRecordHeader(headerBuf1)
+	assert.Nil(t, err)
 	t.Log(h1)
 	t.Log(size1)
 	assert.NotNil(t, h1)
@@ -57,7 +61,8 @@ func TestDecodeLogRecordHeader(t *testing.T) {
 
 	// Value is empty
 	headerBuf2 := []byte{9, 252, 88, 14, 0, 8, 0}
-	h2, size2 := decodeLogRecordHeader(headerBuf2)
+	h2, size2, err := decodeLogRecordHeader(headerBuf2)
+	assert.Nil(t, err)
 	t.Log(h2)
 	t.Log(size2)
 	assert.NotNil(t, h2)
@@ -69,7 +74,8 @@ func TestDecodeLogRecordHeader(t *testing.T) {
 	
 	// Test deleted key
 	headerBuf3 := []byte{43, 153, 86, 17, 1, 8, 20}
-	h3, size3 := decodeLogRecordHeader(headerBuf3)
+	h3, size3, err := decodeLogRecordHeader(headerBuf3)
+	assert.Nil(t, err)
 	t.Log(h3)
 	t.Log(size3)
 	assert.NotNil(t, h3)
@@ -112,4 +118,180 @@ func TestGetLogRecordCRC(t *testing.T) {
 	crc3 := getLogRecordCRC(rec3, headerBuf3[crc32.Size:])
 	t.Log(crc3)
 	assert.Equal(t, uint32(290887979), crc3)
-}
\ No newline at end of file
+}
+func TestEncodeMergeOperand(t *testing.T) {
+	// No previous record
+	buf1 := EncodeMergeOperand(nil, []byte("a"))
+	prev1, op1, err := DecodeMergeOperand(buf1)
+	assert.Nil(t, err)
+	assert.Nil(t, prev1)
+	assert.Equal(t, []byte("a"), op1)
+
+	// Has previous record
+	pos := &LogRecordPos{Fid: 3, Offset: 1024, Size: 47}
+	buf2 := EncodeMergeOperand(pos, []byte("bitcask-go"))
+	prev2, op2, err := DecodeMergeOperand(buf2)
+	assert.Nil(t, err)
+	assert.Equal(t, pos, prev2)
+	assert.Equal(t, []byte("bitcask-go"), op2)
+
+	// Operand is empty
+	buf3 := EncodeMergeOperand(pos, nil)
+	prev3, op3, err := DecodeMergeOperand(buf3)
+	assert.Nil(t, err)
+	assert.Equal(t, pos, prev3)
+	assert.Equal(t, 0, len(op3))
+}
+
+func TestEncodeLogRecordWithChecksum(t *testing.T) {
+	rec := &LogRecord{
+		Key: []byte("name"),
+		Value: []byte("bitcask-go"),
+		Type: LogRecordNormal,
+	}
+	// The old format is the same as EncodeLogRecord
+	encIEEE, sizeIEEE := EncodeLogRecordWithChecksum(rec, ChecksumCRC32IEEE)
+	enc, size := EncodeLogRecord(rec)
+	assert.Equal(t, enc, encIEEE)
+	assert.Equal(t, size, sizeIEEE)
+
+	// Only the crc differs
+	encC, sizeC := EncodeLogRecordWithChecksum(rec, ChecksumCRC32C)
+	assert.Equal(t, size, sizeC)
+	assert.NotEqual(t, enc[:4], encC[:4])
+	assert.Equal(t, enc[4:], encC[4:])
+
+	decoded, n, err := DecodeLogRecordWithChecksum(encC, ChecksumCRC32C)
+	assert.Nil(t, err)
+	assert.Equal(t, sizeC, n)
+	assert.Equal(t, rec, decoded)
+
+	// Decoded with the wrong checksum
+	_, _, err = DecodeLogRecordWithChecksum(encC, ChecksumCRC32IEEE)
+	assert.Equal(t, ErrInvalidCRC, err)
+	_, _, err = DecodeLogRecord(encC)
+	assert.Equal(t, ErrInvalidCRC, err)
+}
+
+func TestDecodeLogRecordHeader_Invalid(t *testing.T) {
+	// Shorter than crc and type
+	_, _, err := decodeLogRecordHeader([]byte{1, 2, 3, 4})
+	assert.Equal(t, io.EOF, err)
+
+	// Ends in the key size
+	_, _, err = decodeLogRecordHeader([]byte{1, 2, 3, 4, 0, 0x80})
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	// Unknown type
+	_, _, err = decodeLogRecordHeader([]byte{1, 2, 3, 4, maxLogRecordType + 1, 8, 20})
+	assert.Equal(t, ErrInvalidLogRecordHeader, err)
+
+	// Negative key size
+	_, _, err = decodeLogRecordHeader([]byte{1, 2, 3, 4, 0, 7, 20})
+	assert.Equal(t, ErrInvalidLogRecordHeader, err)
+
+	// Size larger than a record can be
+	buf := []byte{1, 2, 3, 4, 0}
+	buf = binary.AppendVarint(buf, MaxLogRecordSize)
+	buf = binary.AppendVarint(buf, 1)
+	_, _, err = decodeLogRecordHeader(buf)
+	assert.Equal(t, ErrInvalidLogRecordHeader, err)
+
+	// Varint longer than 64 bits
+	buf = []byte{1, 2, 3, 4, 0}
+	for i := 0; i < 10; i++ {
+		buf = append(buf, 0xff)
+	}
+	_, _, err = decodeLogRecordHeader(append(buf, 1))
+	assert.Equal(t, ErrInvalidLogRecordHeader, err)
+}
+
+func TestDecodeLogRecord_Invalid(t *testing.T) {
+	enc, _ := EncodeLogRecord(&LogRecord{Key: []byte("name"), Value: []byte("bitcask-go")})
+
+	// Record is cut
+	_, _, err := DecodeLogRecord(enc[:len(enc) - 1])
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	// Value size is changed
+	corrupted := append([]byte(nil), enc...)
+	corrupted[6] = 0x7e
+	_, _, err = DecodeLogRecord(corrupted)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestDecodeLogRecordPos_Invalid(t *testing.T) {
+	pos := &LogRecordPos{Fid: 3, Offset: 1024, Size: 47}
+	decoded, err := DecodeLogRecordPos(EncodeLogRecordPos(pos))
+	assert.Nil(t, err)
+	assert.Equal(t, pos, decoded)
+
+	for _, buf := range [][]byte{
+		nil,
+		{6, 128},                   // Size is missing
+		{6, 128, 16, 1},            // Offset ends in the middle
+		{1, 128, 16, 94},           // Negative file id
+		binary.AppendVarint([]byte{6, 2}, math.MaxUint32 + 1),
+	} {
+		_, err := DecodeLogRecordPos(buf)
+		assert.Equal(t, ErrInvalidLogRecordPos, err, buf)
+	}
+
+	// Operand with a position longer than itself
+	_, _, err = DecodeMergeOperand([]byte{100, 6, 128, 16, 94})
+	assert.Equal(t, ErrInvalidMergeOperand, err)
+	_, _, err = DecodeMergeOperand(nil)
+	assert.Equal(t, ErrInvalidMergeOperand, err)
+	_, _, err = DecodeMergeOperand([]byte{2, 1, 2})
+	assert.Equal(t, ErrInvalidLogRecordPos, err)
+}
+
+func FuzzDecodeLogRecordHeader(f *testing.F) {
+	for _, rec := range []*LogRecord{
+		{Key: []byte("name"), Value: []byte("bitcask-go")},
+		{Key: []byte("name"), Type: LogRecordDeleted},
+		{Key: make([]byte, 300), Value: make([]byte, 70000)},
+	} {
+		enc, _ := EncodeLogRecord(rec)
+		f.Add(enc)
+	}
+	f.Add([]byte{1, 2, 3, 4, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01})
+
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		header, headerSize, err := decodeLogRecordHeader(buf)
+		if err != nil {
+			assert.Nil(t, header)
+			return
+		}
+		assert.LessOrEqual(t, headerSize, int64(len(buf)))
+		assert.LessOrEqual(t, headerSize + int64(header.keySize) + int64(header.valueSize), int64(MaxLogRecordSize))
+		assert.LessOrEqual(t, header.recordType, maxLogRecordType)
+
+		// The whole record is decoded without panicking
+		_, _, _ = DecodeLogRecordWithChecksum(buf, ChecksumCRC32C)
+	})
+}
+
+func FuzzDecodeLogRecordPos(f *testing.F) {
+	f.Add(EncodeLogRecordPos(&LogRecordPos{Fid: 3, Offset: 1024, Size: 47}))
+	f.Add(EncodeLogRecordPos(&LogRecordPos{Fid: math.MaxUint32, Offset: math.MaxInt64, Size: math.MaxUint32}))
+	f.Add(EncodeMergeOperand(&LogRecordPos{Fid: 1, Offset: 2, Size: 3}, []byte("operand")))
+
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		pos, err := DecodeLogRecordPos(buf)
+		if err == nil {
+			// Encoding it again gives the same position
+			decoded, err := DecodeLogRecordPos(EncodeLogRecordPos(pos))
+			assert.Nil(t, err)
+			assert.Equal(t, pos, decoded)
+		}
+
+		prev, operand, err := DecodeMergeOperand(buf)
+		if err == nil {
+			assert.LessOrEqual(t, len(operand), len(buf))
+			if prev != nil {
+				assert.True(t, prev.Offset >= 0)
+			}
+		}
+	})
+}
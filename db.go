@@ -5,6 +5,7 @@ import (
 	"bitcask-go/fio"
 	"bitcask-go/index"
 	"bitcask-go/utils"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -14,12 +15,12 @@ import (
 	"strconv"
 	"strings"
 	"sync"
-
-	"github.com/gofrs/flock"
 )
 
 const (
 	seqNoKey = "seq.no"
+	// Number of seqNo saved ahead each time
+	seqNoReserveSize = 1024
 	fileLockName = "flock"
 )
 
@@ -27,17 +28,24 @@ const (
 type DB struct {
 	options Options
 	mu *sync.RWMutex
-	fileIds []int                           // Can only be used when loading index, cannot be used or updated in other places
+	fileIds []int                           // Files read when loading index. Can only be used when loading index, cannot be used or updated in other places
+	manifest *manifest                      // Live files of the database
+	nextFileId uint32                       // Id of the next new data file
 	activeFile *data.DataFile 				// Current active file, can be written in
 	olderFiles map[uint32]*data.DataFile    // Old files, can only be read
 	index index.Indexer
 	seqNo uint64							// Transaction serial number
 	isMerging bool							// Only one merge is allowed at the same time
-	seqNoFileExists bool					// The file which saves seqNo exists?
-	isinitial bool							// Is it the first time tp initialize the database?
-	fileLock *flock.Flock					// File lock ensures mutual exclusion between multiple processes
+	seqNoReserved uint64					// seqNo up to it are saved in the seq-no file, larger ones must be saved before use
+	fileLock fio.Locker						// File lock ensures mutual exclusion between multiple processes
 	bytesWrite uint							// The number of bytes have been written so far
 	reclaimSize int64                       // The number of size which are invalid
+	mergeBoundary uint32                    // Files below it are rewritten by merge, positions in them are invalid after restart
+	refMu *sync.Mutex                       // Protect fileRefs and obsoleteFiles
+	fileRefs map[uint32]int                 // Number of iterators using each data file
+	obsoleteFiles map[uint32]*data.DataFile // Files replaced by merge but still used by iterators
+	filesMu *sync.RWMutex                   // Protect activeFile, olderFiles and their IOManagers for Get without mu
+	concurrentGet bool                      // Get doesn't hold mu, see canGetConcurrently
 }
 
 type Stat struct {
@@ -45,6 +53,8 @@ type Stat struct {
 	DataFileNum uint                        // Number of datafiles on the disk
 	ReclaimableSize int64                   // Bytes that can be reclaimed
 	DiskSize int64                          // Amount of disk space
+	IndexMemory int64                       // Bytes of memory used by the index, estimated
+	BloomFalsePositiveRate float64          // Observed false-positive rate of the Bloom filter of the B+ tree index
 }
 
 // Open bitcask storage engine instance
@@ -54,20 +64,23 @@ func Open(options Options) (*DB, error) {
 		return nil, err
 	}
 
-	var isinitial bool
-	
 	// Judge if DirPath exists
 	// If not exits, construct
-	if _, err := os.Stat(options.DirPath); os.IsNotExist(err) {
+	if _, err := options.FS.Stat(options.DirPath); os.IsNotExist(err) {
 		// The path doesn't exist. Must be the first time to initialize database
-		isinitial = true
+		if err := options.FS.MkdirAll(options.DirPath, os.ModePerm); err != nil {
+			return nil, err
+		}
+	}
+	if options.IndexType == BPlusTree && !fio.IsOSFS(options.FS) {
+		// bbolt can only use files of the OS, the index file is on the disk
 		if err := os.MkdirAll(options.DirPath, os.ModePerm); err != nil {
 			return nil, err
 		}
 	}
 
 	// Judge if current path is in use
-	fileLock := flock.New(filepath.Join(options.DirPath, fileLockName))
+	fileLock := options.FS.Lock(filepath.Join(options.DirPath, fileLockName))
 	hold, err := fileLock.TryLock()  // This method will create a lock file
 	if err != nil {
 		return nil, err
@@ -77,76 +90,93 @@ func Open(options Options) (*DB, error) {
 		return nil, ErrDatabaseIsInUse
 	}
 
-	entries, err := os.ReadDir(options.DirPath)
+
+	indexer, err := index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrite, options.IndexMemoryLimit)
 	if err != nil {
+		_ = fileLock.Unlock()
 		return nil, err
 	}
-	if len(entries) == 0 {
-		// Although the path exists, it's empty
-		// ALso means the first time to initialize database
-		isinitial = true
-	}
-
 
 	// Initialize DB instance
 	db := &DB{
 		options: options,
 		mu: new(sync.RWMutex),
 		olderFiles: make(map[uint32]*data.DataFile),
-		index: index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrite),
-		isinitial: isinitial,
+		refMu: new(sync.Mutex),
+		fileRefs: make(map[uint32]int),
+		obsoleteFiles: make(map[uint32]*data.DataFile),
+		filesMu: new(sync.RWMutex),
+		concurrentGet: canGetConcurrently(options),
+		index: indexer,
 		fileLock: fileLock,
 	}
 
+	// The index and the lock are released if loading fails, so Open can be tried again
+	if err := db.load(); err != nil {
+		db.closeFailedOpen()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Load the files, the index and the seqNo of the database
+func (db *DB) load() error {
 	// Load merge directory
 	if err := db.loadMergeFiles(); err != nil {
-		return nil, err
+		return err
+	}
+
+	// Find the live files
+	if err := db.loadManifest(); err != nil {
+		return err
 	}
 
 	// Load data file
 	// These are files to be appended (log files)
 	// Actually, log files are data files. They are the same thing.
 	if err := db.loadDataFiles(); err != nil {
-		return nil, err
+		return err
 	}
 
-	// B+ tree stores indexes on the disk. No need to load
-	if options.IndexType != BPlusTree{
+	// B+ tree stores indexes on the disk. Only the records written after it was saved are loaded
+	if db.options.IndexType != BPlusTree{
 		// Load data from hint file
 		if err := db.loadIndexFromHintFile(); err != nil {
-			return nil, err
+			return err
 		}
 
 		// Load index from data files
-		if err := db.loadIndexFromDataFiles(); err != nil {
-			return nil, err
-		}
-
-		// Reset IO type as file IO
-		// B+ tree saves index on disk. Won't use mmap
-		if db.options.MMapAtStartUp {
-			if err := db.resetIoType(); err != nil {
-				return nil, err
-			}
+		if err := db.loadIndexFromDataFiles(nil); err != nil {
+			return err
 		}
 	} else {
-		// Get current seqNo
-		if err := db.loadSeqNo(); err != nil {
-			return nil, err
+		if err := db.reconcileBPlusTree(); err != nil {
+			return err
 		}
+	}
 
-		// If it's not B+ tree, loadIndexFromDataFiles() update the Writeoff in the active file
-		// For B+ tree, this should be updated manually
-		if db.activeFile != nil {
-			size, err := db.activeFile.IoManager.Size()
-			if err != nil {
-				return nil, err
-			}
-			db.activeFile.WriteOff = size
-		}
+	// Get current seqNo
+	if err := db.loadSeqNo(); err != nil {
+		return err
 	}
 
-	return db, nil
+	return nil
+}
+
+// Release what Open has taken when loading fails
+// The data files are closed without writing anything
+func (db *DB) closeFailedOpen() {
+	_ = db.index.Close()
+	if db.activeFile != nil {
+		_ = db.activeFile.Close()
+	}
+	for _, file := range db.olderFiles {
+		_ = file.Close()
+	}
+	for _, file := range db.obsoleteFiles {
+		_ = file.Close()
+	}
+	_ = db.fileLock.Unlock()
 }
 
 func checkOptions(options Options) error {
@@ -154,6 +184,10 @@ func checkOptions(options Options) error {
 		return errors.New("database dir path is empty")
 	}
 
+	if options.FS == nil {
+		return errors.New("database file system is nil")
+	}
+
 	if options.DataFileSize <= 0 {
 		return errors.New("database data file size must be positive")
 	}
@@ -162,6 +196,15 @@ func checkOptions(options Options) error {
 		return errors.New("invalid merge ratio, must between 0 and 1")
 	}
 
+	if options.IndexType == Hybrid && options.IndexMemoryLimit <= 0 {
+		return errors.New("index memory limit must be positive")
+	}
+
+	if options.IndexType == Hybrid && !fio.IsOSFS(options.FS) {
+		// The spilled keys are in a bbolt file, it is created and removed with the OS
+		return errors.New("hybrid index can only be used with the file system of the OS")
+	}
+
 	return nil
 }
 
@@ -172,6 +215,16 @@ func (db *DB) Put(key []byte, value []byte) error{
 		return ErrKeyIsEmpty
 	}
 
+	// Append to the current active file and renew in-memory index under the same lock
+	// So that the order in the index is the same as the order in the data file
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.put(key, value)
+}
+
+// Write key/value
+// Must have lock when using this method
+func (db *DB) put(key []byte, value []byte) error {
 	// Build struct LogRecord 
 	logRecord := &data.LogRecord{
 		Key: logRecordKeyWithSeq(key, nonTransactionSeqNo),
@@ -179,19 +232,18 @@ func (db *DB) Put(key []byte, value []byte) error{
 		Type: data.LogRecordNormal, 
 	}
 
-	// Append to the current active file
-	pos, err := db.appendLogRecordWithLock(logRecord)
+	pos, err := db.appendLogRecord(logRecord)
 	if err != nil {
 		return err
 	}
 
 	// Renew in-memory index
+	db.markIndexApplied(pos)
 	if oldPos := db.index.Put(key, pos); oldPos != nil {
 		db.reclaimSize += int64(oldPos.Size)
 	}
 
 	return nil
-
 }
 
 // Delete corresponding data according to the key
@@ -201,6 +253,14 @@ func (db *DB) Delete(key []byte) error{
 		return ErrKeyIsEmpty
 	}
 
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.delete(key)
+}
+
+// Delete the key
+// Must have lock when using this method
+func (db *DB) delete(key []byte) error {
 	// Examine if the key exists
 	// If not exist, there is no need to write this log record
 	if pos := db.index.Get(key); pos == nil {
@@ -212,7 +272,7 @@ func (db *DB) Delete(key []byte) error{
 		Key: logRecordKeyWithSeq(key, nonTransactionSeqNo), 
 		Type: data.LogRecordDeleted,
 	}
-	pos, err := db.appendLogRecordWithLock(logRecord)
+	pos, err := db.appendLogRecord(logRecord)
 	if err != nil {
 		return err
 	}
@@ -220,6 +280,7 @@ func (db *DB) Delete(key []byte) error{
 	db.reclaimSize += int64(pos.Size)
 
 	// Delete the key in the in-memory index
+	db.markIndexApplied(pos)
 	oldPos, ok := db.index.Delete(key)
 	if !ok {
 		return ErrIndexUpdateFailed
@@ -231,17 +292,85 @@ func (db *DB) Delete(key []byte) error{
 	return nil
 }
 
+// Delete all the keys in [start, end)
+// Empty start means from the first key, empty end means to the last key
+// Only one range tombstone is written no matter how many keys are in the range
+func (db *DB) DeleteRange(start, end []byte) error {
+	if len(start) != 0 && len(end) != 0 && bytes.Compare(start, end) >= 0 {
+		return ErrInvalidRange
+	}
+
+	logRecord := &data.LogRecord{
+		Key: logRecordKeyWithSeq(start, nonTransactionSeqNo),
+		Value: end,
+		Type: data.LogRecordRangeDeleted,
+	}
+
+	// Appending the tombstone and updating the index must be done under the same lock
+	// Otherwise a key put between them would be deleted from the index by mistake
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	pos, err := db.appendLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+	db.markIndexApplied(pos)
+	db.applyRangeDelete(start, end, pos)
+	return nil
+}
+
+// Delete all the keys with the specified prefix
+func (db *DB) DeletePrefix(prefix []byte) error {
+	if len(prefix) == 0 {
+		return ErrKeyIsEmpty
+	}
+	return db.DeleteRange(prefix, utils.PrefixEnd(prefix))
+}
+
+// Remove the keys in [start, end) from the in-memory index
+// pos is the position of the range tombstone
+func (db *DB) applyRangeDelete(start, end []byte, pos *data.LogRecordPos) {
+	// The tombstone itself can be reclaimed
+	db.reclaimSize += int64(pos.Size)
+	for _, oldPos := range db.index.DeleteRange(start, end) {
+		db.reclaimSize += int64(oldPos.Size)
+	}
+}
+
 // Get value according to key
 func (db *DB) Get(key []byte) ([]byte, error) {
+	// Judge the validation of key
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+
+	if db.concurrentGet {
+		// Only the data files must not be switched while reading
+		db.filesMu.RLock()
+		defer db.filesMu.RUnlock()
+		return db.get(key)
+	}
+
 	// Add read lock
 	db.mu.RLock()
 	defer db.mu.RUnlock()
+	return db.get(key)
+}
 
-	// Judge the validation of key
-	if len(key) == 0 {
-		return nil, ErrKeyIsEmpty
+// Can Get read without mu, so it doesn't wait for the writes?
+// The index must be read and written at the same time, and see none or all of a batch
+// The active file must be read while it is appended, write(2) and pread(2) can, the other IO types change their state in memory
+func canGetConcurrently(options Options) bool {
+	if options.IndexType != Skiplist && options.IndexType != Hash {
+		return false
 	}
+	return options.ActiveFileIOType == StandardIO
+}
 
+// Get value according to key
+// Must have lock when using this method
+func (db *DB) get(key []byte) ([]byte, error) {
 	// Get corresponding index from in-memory
 	logRecordPos := db.index.Get(key)
 	// If key is not in the in-memory index, key doesn't exist
@@ -253,16 +382,38 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 	return db.getValueByPosition(logRecordPos)
 }
 
+// Call fn with the value of key
+// If the data file is mapped, value is a slice of the mapping, no copy is done
+// value is only valid in fn, it must not be modified or used after fn returns
+func (db *DB) GetFunc(key []byte, fn func(value []byte) error) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+
+	if db.concurrentGet {
+		db.filesMu.RLock()
+		defer db.filesMu.RUnlock()
+	} else {
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+	}
+
+	logRecordPos := db.index.Get(key)
+	if logRecordPos == nil {
+		return ErrKeyNotFound
+	}
+	return db.viewValueByPosition(logRecordPos, fn)
+}
+
 // Get all the keys in the database
 func (db *DB) ListKeys() [][]byte {
 	iterator := db.index.Iterator(false)
 	defer iterator.Close()
 
-	keys := make([][]byte, db.index.Size())
-	var idx int
+	// The iterator walks a snapshot, its size may differ from the current size
+	keys := make([][]byte, 0, db.index.Size())
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-		keys[idx] = iterator.Key()
-		idx++
+		keys = append(keys, iterator.Key())
 	}
 	return keys
 }
@@ -290,38 +441,89 @@ func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
 
 // Get value by logRecordPos
 func (db *DB) getValueByPosition(pos *data.LogRecordPos) ([]byte, error) {
-	// Find data file according to file id
-	var dataFile *data.DataFile
-	if db.activeFile.FileId == pos.Fid {
-		dataFile = db.activeFile
-	} else {
-		dataFile = db.olderFiles[pos.Fid]
+	logRecord, err := db.readLogRecord(pos)
+	if err != nil {
+		return nil, err
+	}
+
+	// Judge if the key is deleted
+	if logRecord.Type == data.LogRecordDeleted {
+		return nil, ErrKeyNotFound
+	}
+
+	// Combine the operands with the value
+	if logRecord.Type == data.LogRecordMergeOperand {
+		return db.resolveMergeOperands(logRecord)
+	}
+
+	return logRecord.Value, nil
+}
+
+// Call fn with the value by logRecordPos, copy is avoided if possible
+// Must have lock when using this method
+func (db *DB) viewValueByPosition(pos *data.LogRecordPos, fn func(value []byte) error) error {
+	dataFile := db.getDataFile(pos.Fid)
+	if dataFile == nil {
+		return ErrDataFileNotFound
+	}
+	if pos.Size == 0 {
+		value, err := db.getValueByPosition(pos)
+		if err != nil {
+			return err
+		}
+		return fn(value)
+	}
+
+	logRecord, err := dataFile.ViewLogRecordAt(pos.Offset, pos.Size)
+	if err != nil {
+		return err
+	}
+	switch logRecord.Type {
+	case data.LogRecordDeleted:
+		return ErrKeyNotFound
+	case data.LogRecordMergeOperand:
+		// The combined value is a new slice
+		value, err := db.resolveMergeOperands(logRecord)
+		if err != nil {
+			return err
+		}
+		return fn(value)
+	default:
+		return fn(logRecord.Value)
 	}
+}
 
+// Read the log record by logRecordPos
+func (db *DB) readLogRecord(pos *data.LogRecordPos) (*data.LogRecord, error) {
+	dataFile := db.getDataFile(pos.Fid)
 	// Data file is nil
 	if dataFile == nil {
 		return nil, ErrDataFileNotFound
 	}
 
+	// The size of the record is known, read it in one read
+	if pos.Size > 0 {
+		return dataFile.ReadLogRecordAt(pos.Offset, pos.Size)
+	}
+
 	// Read corresponding data according to offset
 	logRecord, _, err := dataFile.ReadLogRecord(int64(pos.Offset))
 	if err != nil {
 		return nil, err
 	}
-
-	// Judge if the key is deleted
-	if logRecord.Type == data.LogRecordDeleted {
-		return nil, ErrKeyNotFound
-	}
-
-	return logRecord.Value, nil
+	return logRecord, nil
 }
 
-// append logRecord to active file with lock
-func (db *DB) appendLogRecordWithLock(logRecord *data.LogRecord) (*data.LogRecordPos, error){
-	db.mu.Lock()
-	defer db.mu.Unlock()
-	return db.appendLogRecord(logRecord)
+// Find data file according to file id
+func (db *DB) getDataFile(fid uint32) *data.DataFile {
+	if db.activeFile != nil && db.activeFile.FileId == fid {
+		return db.activeFile
+	}
+	if dataFile, ok := db.olderFiles[fid]; ok {
+		return dataFile
+	}
+	// Replaced by merge, but an iterator created before it still reads it
+	return db.getObsoleteDataFile(fid)
 }
 
 // append logRecord to active file
@@ -335,8 +537,12 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 		}
 	}
 
-	// Encode logRecord
-	encRecord, size := data.EncodeLogRecord(logRecord)
+	// Encode logRecord in the format of the active file
+	encRecord, size := db.activeFile.EncodeLogRecord(logRecord)
+	if size > data.MaxLogRecordSize {
+		// The size could not be saved in the position
+		return nil, ErrLogRecordTooLarge
+	}
 
 	// If written data reaches the limit of the active file,
 	// then close the active file and open a new file
@@ -347,12 +553,19 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 		}
 
 		// Make current active file transfer to old files
-		db.olderFiles[db.activeFile.FileId] = db.activeFile
+		if err := db.moveActiveToOlder(); err != nil {
+			return nil, err
+		}
 
 		// Open new file
+		checksum := db.activeFile.Checksum()
 		if err := db.setActiveDataFile(); err != nil {
 			return nil, err
 		}
+		// The old file may be of an older format
+		if db.activeFile.Checksum() != checksum {
+			encRecord, size = db.activeFile.EncodeLogRecord(logRecord)
+		}
 	}
 
 	// Write data to the file
@@ -371,6 +584,9 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 	}
 	if needSync { 
 		if err := db.activeFile.Sync(); err != nil {
+			// The record may not be durable and the caller will not index it
+			// Drop it, otherwise it would appear after restart and the data file would differ from the index
+			_ = db.activeFile.SetWriteOff(writeOff)
 			return nil, err
 		}
 		if db.bytesWrite > 0 {
@@ -384,29 +600,47 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 	return pos, nil
 }
 
-// Set current active file
+// Transfer current active file into older files
+// It won't be written any more, so it can be mapped
 // Must have lock when using this method
-func (db *DB) setActiveDataFile() error{
-	var initialFileId uint32 = 0
-	if db.activeFile != nil {
-		// It means there are files that have been opened
-		initialFileId = db.activeFile.FileId + 1
+func (db *DB) moveActiveToOlder() error {
+	db.filesMu.Lock()
+	defer db.filesMu.Unlock()
+
+	if db.options.MMapAtStartUp {
+		if err := db.activeFile.SetIOManager(db.options.FS, db.options.DirPath, fio.MemoryMap); err != nil {
+			return err
+		}
+	} else if db.options.ActiveFileIOType != fio.StandardFIO {
+		// Closing the IOManager of the active file truncates the pre-allocated file
+		if err := db.activeFile.SetIOManager(db.options.FS, db.options.DirPath, fio.StandardFIO); err != nil {
+			return err
+		}
 	}
+	db.olderFiles[db.activeFile.FileId] = db.activeFile
+	return nil
+}
 
+// Set current active file
+// Must have lock when using this method
+func (db *DB) setActiveDataFile() error{
 	// Open new file
-	dataFile, err := data.OpenDataFile(db.options.DirPath, initialFileId, fio.StandardFIO)
+	dataFile, err := data.OpenDataFileWithCapacity(db.options.FS, db.options.DirPath, db.nextFileId, db.options.ActiveFileIOType, db.options.DataFileSize)
 	if err != nil {
 		return err
 	}
+	db.filesMu.Lock()
 	db.activeFile = dataFile
+	db.filesMu.Unlock()
+	db.nextFileId++
 	return nil
 }
 
-// Load data files from disk
-func (db *DB) loadDataFiles() error {
-	dirEntries, err := os.ReadDir(db.options.DirPath)
+// List the ids of the data files in dirPath, sorted from small to large
+func listDataFileIds(fs fio.FS, dirPath string) ([]int, error) {
+	dirEntries, err := fs.ReadDir(dirPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	var fileIds []int
@@ -421,7 +655,7 @@ func (db *DB) loadDataFiles() error {
 			if err != nil {
 				// There are some files in the directory with .data suffix but its name is not a number
 				// This is not allowed
-				return ErrDataDirectoryCorrupted
+				return nil, ErrDataDirectoryCorrupted
 			}
 			fileIds = append(fileIds, fileId)
 		}
@@ -429,62 +663,106 @@ func (db *DB) loadDataFiles() error {
 
 	// Sort id, load in order from small to large
 	sort.Ints(fileIds)
-	db.fileIds = fileIds
+	return fileIds, nil
+}
+
+// Load the live data files from disk
+func (db *DB) loadDataFiles() error {
+	fileIds, err := listDataFileIds(db.options.FS, db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	// New files are numbered after all the files, so they never take the ids of the merged files
+	if len(fileIds) > 0 {
+		db.nextFileId = uint32(fileIds[len(fileIds) - 1]) + 1
+	}
+
+	// The merged files are loaded from the hint file, the others are read when loading index
+	var liveFileIds []int
+	db.fileIds = nil
+	for _, fid := range fileIds {
+		if !db.manifest.isLive(uint32(fid)) {
+			continue
+		}
+		liveFileIds = append(liveFileIds, fid)
+		if !db.manifest.isMerged(uint32(fid)) {
+			db.fileIds = append(db.fileIds, fid)
+		}
+	}
 
 	// Iterate over all the fileIds, Open corresponding data file
-	for i, fid := range fileIds {
+	for _, fid := range liveFileIds {
+		if len(db.fileIds) > 0 && fid == db.fileIds[len(db.fileIds) - 1] {
+			// The last file not merged, which means it's current active file
+			// Open it with the IO type for writing
+			dataFile, err := data.OpenDataFileWithCapacity(db.options.FS, db.options.DirPath, uint32(fid), db.options.ActiveFileIOType, db.options.DataFileSize)
+			if err != nil {
+				return err
+			}
+			db.activeFile = dataFile
+			continue
+		}
+
+		// Older files are only read
 		ioType := fio.StandardFIO
 		if db.options.MMapAtStartUp {
 			ioType = fio.MemoryMap
 		}
-		dataFile, err := data.OpenDataFile(db.options.DirPath, uint32(fid), ioType)
+		dataFile, err := data.OpenDataFile(db.options.FS, db.options.DirPath, uint32(fid), ioType)
 		if err != nil {
 			return err
 		}
+		db.olderFiles[uint32(fid)] = dataFile
+	}
 
-		if i == len(fileIds) - 1 {
-			// The last one, which means it's current active file
-			db.activeFile = dataFile
-		} else {
-			db.olderFiles[uint32(fid)] = dataFile
-		}
+	if db.activeFile == nil && len(db.olderFiles) > 0 {
+		// There are only merged files, they are not written any more
+		return db.setActiveDataFile()
 	}
 	return nil
 }
-
 // Load index from data files
 // Use fileIds to iterate over all the records in files
-func (db *DB) loadIndexFromDataFiles() error {
+// start is where to begin, records before it are already in the index. nil means from the beginning
+func (db *DB) loadIndexFromDataFiles(start *data.LogRecordPos) error {
 	// No files, which means the database is empty
 	if len(db.fileIds) == 0 {
 		return nil
 	}
 
-	// Get the first file which has not been merged
-	hasMerged, nonMergeFileId := false, uint32(0)
-	mergeFinFileName := filepath.Join(db.options.DirPath, data.MergeFinishedFileName)
-	if _, err := os.Stat(mergeFinFileName); err == nil {
-		fid, err := db.getNonMergeFileId(db.options.DirPath)
-		if err != nil {
-			return err
+	// Put data to the indexer in batches
+	// reclaimOld tells whether the data replaced by each change can be reclaimed
+	var ops []index.BatchOp
+	var reclaimOld []bool
+	flushIndex := func() {
+		if len(ops) == 0 {
+			return
+		}
+		for i, oldPos := range db.index.ApplyBatch(ops) {
+			if oldPos != nil && reclaimOld[i] {
+				db.reclaimSize += int64(oldPos.Size)
+			}
 		}
-		hasMerged = true
-		nonMergeFileId = fid
+		ops, reclaimOld = ops[:0], reclaimOld[:0]
 	}
 
-	// Put data to the indexer
 	// Key should not contain seqNo
 	updateIndex := func(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
-		var oldPos *data.LogRecordPos
 		if typ == data.LogRecordDeleted {
-			oldPos, _ = db.index.Delete(key)
+			ops = append(ops, index.BatchOp{Key: key})
+			reclaimOld = append(reclaimOld, true)
 			// Delted data itself can be reclaimed
 			db.reclaimSize += int64(pos.Size)
 		} else if typ == data.LogRecordNormal{
-			oldPos = db.index.Put(key, pos)
+			ops = append(ops, index.BatchOp{Key: key, Pos: pos})
+			reclaimOld = append(reclaimOld, true)
+		} else if typ == data.LogRecordMergeOperand {
+			// The previous record is still used by the operand, it cannot be reclaimed
+			ops = append(ops, index.BatchOp{Key: key, Pos: pos})
+			reclaimOld = append(reclaimOld, false)
 		}
-		if oldPos != nil {
-			db.reclaimSize += int64(oldPos.Size)
+		if len(ops) >= indexBatchSize {
+			flushIndex()
 		}
 	}
 
@@ -497,9 +775,7 @@ func (db *DB) loadIndexFromDataFiles() error {
 	for i, fid := range db.fileIds {
 		var fileId = uint32(fid)
 
-		if hasMerged && fileId < nonMergeFileId {
-			// This file has been merged
-			// It is loaded from hint file
+		if start != nil && fileId < start.Fid {
 			continue
 		}
 
@@ -511,9 +787,17 @@ func (db *DB) loadIndexFromDataFiles() error {
 		}
 
 		// Iterate over all the records in the file
-		var offset int64 = 0
+		// The file doesn't change when loading, so get its size only once
+		fileSize, err := dataFile.IoManager.Size()
+		if err != nil {
+			return err
+		}
+		offset := dataFile.RecordsStart()
+		if start != nil && fileId == start.Fid && start.Offset > offset {
+			offset = start.Offset
+		}
 		for {
-			logRecord, size, err := dataFile.ReadLogRecord(int64(offset)) 
+			logRecord, size, err := dataFile.ScanLogRecord(offset, fileSize)
 			if err != nil {
 				// There are two possibilities:
 				// 1. Something go wrong, just return the error
@@ -529,7 +813,11 @@ func (db *DB) loadIndexFromDataFiles() error {
 
 			// Decode key and get transaction serial number
 			realKey, seqNo := parseLogRecordKey(logRecord.Key)
-			if seqNo == nonTransactionSeqNo {
+			if logRecord.Type == data.LogRecordRangeDeleted {
+				// Range tombstone, remove all the keys in the range loaded so far
+				flushIndex()
+				db.applyRangeDelete(realKey, logRecord.Value, logRecordPos)
+			} else if seqNo == nonTransactionSeqNo {
 				// Not written in by batch, just update the in-memory indexer
 				updateIndex(realKey, logRecord.Type, logRecordPos)
 			} else {
@@ -564,38 +852,101 @@ func (db *DB) loadIndexFromDataFiles() error {
 		// If it is current active file
 		// Update writeoff
 		if i == len(db.fileIds) - 1 {
-			db.activeFile.WriteOff = offset
+			// The zero-filled tail of the pre-allocated file and a record not written completely are dropped
+			if err := db.activeFile.TruncateTail(offset); err != nil {
+				return err
+			}
 		}
 	}
 
+	// The rest of the changes
+	flushIndex()
+
 	// Update seqNo in db
 	db.seqNo = currentSeqNo
 
 	return nil
 }
 
+// Load the seqNo saved in the seq-no file
+// The file is kept, it is always larger than or equal to the seqNo used by the transactions in the data files
 func (db *DB) loadSeqNo() error {
 	fileName := filepath.Join(db.options.DirPath, data.SeqNoFileName)
-	if _, err := os.Stat(fileName); os.IsNotExist(err) {
-		return nil
+	if _, err := db.options.FS.Stat(fileName); err == nil {
+		records, err := readRecordFile(db.options.FS, db.options.DirPath, data.OpenSeqNoFile)
+		if err != nil {
+			return err
+		}
+		seqNo, err := strconv.ParseUint(records[seqNoKey], 10, 64)
+		if err != nil {
+			return err
+		}
+		if seqNo > db.seqNo {
+			db.seqNo = seqNo
+		}
+	} else if db.options.IndexType == BPlusTree {
+		// Older versions delete the file when opening, it is missing after a crash
+		// B+ tree doesn't load index from the data files, so find seqNo in them
+		if err := db.loadSeqNoFromDataFiles(); err != nil {
+			return err
+		}
+	}
+	db.seqNoReserved = db.seqNo
+	return nil
+}
+
+// Find the largest seqNo in the data files not merged, merged files have no transaction
+func (db *DB) loadSeqNoFromDataFiles() error {
+	for _, fid := range db.fileIds {
+		dataFile := db.getDataFile(uint32(fid))
+		fileSize, err := dataFile.IoManager.Size()
+		if err != nil {
+			return err
+		}
+		offset := dataFile.RecordsStart()
+		for {
+			logRecord, size, err := dataFile.ScanLogRecord(offset, fileSize)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if _, seqNo := parseLogRecordKey(logRecord.Key); seqNo > db.seqNo {
+				db.seqNo = seqNo
+			}
+			offset += size
+		}
 	}
+	return nil
+}
 
-	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath)
-	if err != nil {
-		return err
+// Get the seqNo of a new transaction
+// seqNo is saved a range ahead, so it is not used again after a crash
+// Must have lock when using this method
+func (db *DB) nextSeqNo() (uint64, error) {
+	seqNo := db.seqNo + 1
+	if seqNo > db.seqNoReserved {
+		if err := db.saveSeqNo(seqNo + seqNoReserveSize - 1); err != nil {
+			return 0, err
+		}
 	}
+	db.seqNo = seqNo
+	return seqNo, nil
+}
 
-	record, _, err := seqNoFile.ReadLogRecord(0)
-	if err != nil {
-		return err
+// Replace the seq-no file with seqNo
+// Must have lock when using this method
+func (db *DB) saveSeqNo(seqNo uint64) error {
+	record := &data.LogRecord{
+		Key: []byte(seqNoKey),
+		Value: []byte(strconv.FormatUint(seqNo, 10)),
 	}
-	seqNo, err := strconv.ParseUint(string(record.Value), 10, 64)
-	if err != nil {
+	if err := writeRecordFile(db.options.FS, db.options.DirPath, data.SeqNoTempFileName, data.SeqNoFileName, data.OpenSeqNoTempFile, []*data.LogRecord{record}); err != nil {
 		return err
 	}
-	db.seqNo = seqNo
-	db.seqNoFileExists = true
-	return os.Remove(fileName)
+	db.seqNoReserved = seqNo
+	return nil
 }
 
 // Close the database
@@ -611,6 +962,8 @@ func (db *DB) Close() error {
 	}
 	db.mu.Lock()
 	defer db.mu.Unlock()
+	db.filesMu.Lock()
+	defer db.filesMu.Unlock()
 
 	// For B+ tree, because B+ tree itself is a database
 	// It needs to close index
@@ -621,19 +974,7 @@ func (db *DB) Close() error {
 	// Save current seqNo
 	// B+ tree doesn't load index when open
 	// So it cannot get the latest seqNo
-	seqNoFIle, err := data.OpenSeqNoFile(db.options.DirPath)
-	if err != nil {
-		return err
-	}
-	record := &data.LogRecord{
-		Key: []byte(seqNoKey),
-		Value: []byte(strconv.FormatUint(db.seqNo, 10)),
-	}
-	encRecord, _ := data.EncodeLogRecord(record)
-	if err := seqNoFIle.Write(encRecord); err != nil {
-		return err
-	}
-	if err := seqNoFIle.Sync(); err != nil {
+	if err := db.saveSeqNo(db.seqNo); err != nil {
 		return err
 	}
 
@@ -647,6 +988,14 @@ func (db *DB) Close() error {
 			return err
 		}
 	}
+	// Files replaced by merge are deleted at the next start
+	db.refMu.Lock()
+	defer db.refMu.Unlock()
+	for _, file := range db.obsoleteFiles {
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -661,26 +1010,8 @@ func (db *DB) Sync() error {
 	return db.activeFile.Sync()
 }
 
-// Set IO type as standard file IO
-func (db *DB) resetIoType() error {
-	if db.activeFile == nil {
-		return nil
-	}
-
-	if err := db.activeFile.SetIOManager(db.options.DirPath, fio.StandardFIO); err != nil {
-		return err
-	}
-
-	for _, dataFile := range db.olderFiles {
-		if err := dataFile.SetIOManager(db.options.DirPath, fio.StandardFIO); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
 // Return some information of database
-func (db *DB) Stat() *Stat {
+func (db *DB) Stat() (*Stat, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
@@ -689,22 +1020,54 @@ func (db *DB) Stat() *Stat {
 		dataFiles += 1
 	}
 
-	dirSize, err := utils.DirSize(db.options.DirPath)
+	dirSize, err := db.diskSize()
 	if err != nil {
-		panic(fmt.Sprintf("failed to get directory size: %v", err))
+		return nil, err
 	}
-	return &Stat {
+	stat := &Stat {
 		KeyNum: uint(db.index.Size()),
 		DataFileNum: dataFiles,
 		ReclaimableSize: db.reclaimSize,
 		DiskSize: dirSize,
+		IndexMemory: db.index.MemoryUsage(),
 	}
+	if bptree, ok := db.index.(*index.BPlusTree); ok {
+		stat.BloomFalsePositiveRate = bptree.BloomFalsePositiveRate()
+	}
+	return stat, nil
+}
+
+// Total size of the files in the directory
+// The preallocated or memory mapped active file has the size of its whole capacity, only its written part is counted
+// Must have the lock of db when using this method
+func (db *DB) diskSize() (int64, error) {
+	dirSize, err := utils.DirSize(db.options.FS, db.options.DirPath)
+	if err != nil {
+		return 0, err
+	}
+	if db.activeFile == nil {
+		return dirSize, nil
+	}
+	info, err := db.options.FS.Stat(data.GetDataFileName(db.options.DirPath, db.activeFile.FileId))
+	if err != nil {
+		return 0, err
+	}
+	return dirSize - info.Size() + db.activeFile.WriteOff, nil
 }
 
 // Backup database
 func (db *DB) Backup(dir string) error {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
+	// Writing the buffer of the active file changes it, so the write lock is needed
+	db.mu.Lock()
+	defer db.mu.Unlock()
 
-	return utils.CopyDic(db.options.DirPath, dir, []string{fileLockName})
+	// The data in the buffer of the active file must be written to the file first
+	if db.activeFile != nil {
+		if err := db.activeFile.Sync(); err != nil {
+			return err
+		}
+	}
+	// The merge directory is either not finished or installed when opening
+	// The spill file of the hybrid index is made again when opening
+	return utils.CopyDic(db.options.FS, db.options.DirPath, dir, []string{fileLockName, mergeDirName, index.HybridSpillFileName})
 }
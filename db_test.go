@@ -1,22 +1,42 @@
 package kvproject
 
 import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
 	"bitcask-go/utils"
 	"bytes"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
+func init() {
+	// BITCASK_TEST_FS=mem runs the tests in memory
+	if os.Getenv("BITCASK_TEST_FS") == "mem" {
+		DefaultOptions.FS = fio.NewMemFS()
+	}
+}
+
 // Destroy DB data catalogue after testing
 func destroyDB(db *DB) {
 	if db != nil {
 		if db.activeFile != nil {
 			_ = db.Close()
 		}
-		err := os.RemoveAll(db.options.DirPath)
+		err := db.options.FS.RemoveAll(db.options.DirPath)
+		if err != nil {
+			panic(err)
+		}
+		// The directory made by os.MkdirTemp, or the B+ tree index on the disk
+		err = os.RemoveAll(db.options.DirPath)
 		if err != nil {
 			panic(err)
 		}
@@ -150,6 +170,601 @@ func TestDB_Get(t *testing.T) {
 	assert.Equal(t, ErrKeyNotFound, err)
 }
 
+func TestDB_GetFunc(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-get-func")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1. Key doesn't exist
+	err = db.GetFunc(utils.GetTestKey(1), func(value []byte) error {
+		return nil
+	})
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// Data are in more than one data files
+	values := make(map[int][]byte)
+	for i := 0; i < 1000; i++ {
+		values[i] = utils.RandomValue(128)
+		err := db.Put(utils.GetTestKey(i), values[i])
+		assert.Nil(t, err)
+	}
+	// Older files are mapped after they are full
+	// Files of other file systems cannot be mapped
+	assert.True(t, len(db.olderFiles) > 0)
+	for _, dataFile := range db.olderFiles {
+		_, ok := dataFile.IoManager.(*fio.MMap)
+		assert.Equal(t, fio.IsOSFS(opts.FS), ok)
+	}
+
+	check := func(db *DB) {
+		for i := 0; i < 1000; i++ {
+			err := db.GetFunc(utils.GetTestKey(i), func(value []byte) error {
+				assert.Equal(t, values[i], value)
+				return nil
+			})
+			assert.Nil(t, err)
+		}
+	}
+	check(db)
+
+	// 2. Error of fn is returned
+	errStop := errors.New("stop")
+	err = db.GetFunc(utils.GetTestKey(1), func(value []byte) error {
+		return errStop
+	})
+	assert.Equal(t, errStop, err)
+
+	// 3. Restart, older files are still mapped and the active file can be written
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	check(db2)
+	for _, dataFile := range db2.olderFiles {
+		_, ok := dataFile.IoManager.(*fio.MMap)
+		assert.Equal(t, fio.IsOSFS(opts.FS), ok)
+	}
+	err = db2.Put(utils.GetTestKey(1), []byte("new value"))
+	assert.Nil(t, err)
+	err = db2.GetFunc(utils.GetTestKey(1), func(value []byte) error {
+		assert.Equal(t, []byte("new value"), value)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+// B+ tree can write after restart when MMapAtStartUp is set
+func TestDB_Open_BPlusTree_MMap(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-bptree-mmap")
+	opts.DirPath = dir
+	opts.IndexType = BPlusTree
+	opts.MMapAtStartUp = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(24))
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	err = db2.Put(utils.GetTestKey(2), utils.RandomValue(24))
+	assert.Nil(t, err)
+	_, err = db2.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+}
+
+// Missing keys of the B+ tree index are answered by its Bloom filter
+func TestDB_BPlusTree_BloomFilter(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-bptree-bloom")
+	opts.DirPath = dir
+	opts.IndexType = BPlusTree
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), utils.RandomValue(24)))
+	}
+	assert.Nil(t, wb.Commit())
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	for i := 1000; i < 3000; i++ {
+		_, err := db2.Get(utils.GetTestKey(i))
+		assert.Equal(t, ErrKeyNotFound, err)
+		assert.Nil(t, db2.Delete(utils.GetTestKey(i)))
+	}
+	for i := 0; i < 1000; i += 10 {
+		_, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	stat, err := db2.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1000), stat.KeyNum)
+	assert.Less(t, stat.BloomFalsePositiveRate, 0.05)
+}
+
+// Most keys of the hybrid index are spilled to the disk
+func TestDB_HybridIndex(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-hybrid")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.IndexType = Hybrid
+	opts.IndexMemoryLimit = 0
+	_, err := Open(opts)
+	assert.NotNil(t, err)
+
+	// The spill file is only on the disk of the OS
+	opts.IndexMemoryLimit = 64 * 1024
+	opts.FS = fio.NewMemFS()
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+	opts.FS = fio.OSFS
+
+	opts.IndexMemoryLimit = 16 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	expected := putManifestTestData(t, db)
+	checkManifestTestData(t, db, expected)
+
+	err = db.DeletePrefix(utils.GetTestKey(1000)[:len(utils.GetTestKey(1000)) - 2])
+	assert.Nil(t, err)
+	for i := 1000; i < 1100; i++ {
+		delete(expected, string(utils.GetTestKey(i)))
+	}
+	err = db.Merge()
+	assert.Nil(t, err)
+	checkManifestTestData(t, db, expected)
+	err = db.Close()
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(dir, index.HybridSpillFileName))
+	assert.True(t, os.IsNotExist(err))
+
+	// The index is loaded from the hint file and the data files again
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	checkManifestTestData(t, db2, expected)
+	iterator := db2.NewIterator(DefaultIteratorOptions)
+	var count int
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		value, err := iterator.Value()
+		assert.Nil(t, err)
+		assert.Equal(t, expected[string(iterator.Key())], value)
+		count++
+	}
+	iterator.Close()
+	assert.Equal(t, len(expected), count)
+}
+
+func TestDB_SkiplistIndex(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-skiplist")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.IndexType = Skiplist
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	expected := putManifestTestData(t, db)
+
+	// Iterating while writing, the iterator walks the keys when it was created
+	iterator := db.NewIterator(DefaultIteratorOptions)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	var count int
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		count++
+	}
+	iterator.Close()
+	assert.Equal(t, len(expected), count)
+	for i := 0; i < 100; i++ {
+		delete(expected, string(utils.GetTestKey(i)))
+	}
+
+	err = db.Merge()
+	assert.Nil(t, err)
+	checkManifestTestData(t, db, expected)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	checkManifestTestData(t, db2, expected)
+}
+
+// Get doesn't hold the lock of the database with Skiplist and Hash
+// It runs while the active file is switched and the merged files replace the old ones
+func TestDB_ConcurrentGet(t *testing.T) {
+	for _, indexType := range []IndexerType{Skiplist, Hash} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-concurrent-get")
+		opts.DirPath = dir
+		opts.DataFileSize = 32 * 1024
+		opts.DataFileMergeRatio = 0
+		opts.IndexType = indexType
+		db, err := Open(opts)
+		assert.Nil(t, err)
+		assert.True(t, db.concurrentGet)
+		for i := 0; i < 500; i++ {
+			err := db.Put(utils.GetTestKey(i), []byte(fmt.Sprintf("value-%d", i)))
+			assert.Nil(t, err)
+		}
+
+		done := make(chan struct{})
+		wg := new(sync.WaitGroup)
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; ; i++ {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					k := i % 500
+					value, err := db.Get(utils.GetTestKey(k))
+					if !assert.Nil(t, err) {
+						return
+					}
+					assert.Equal(t, []byte(fmt.Sprintf("value-%d", k)), value)
+				}
+			}()
+		}
+		for round := 0; round < 5; round++ {
+			for i := 0; i < 500; i++ {
+				err := db.Put(utils.GetTestKey(i), []byte(fmt.Sprintf("value-%d", i)))
+				assert.Nil(t, err)
+			}
+			err = db.Merge()
+			assert.Nil(t, err)
+		}
+		close(done)
+		wg.Wait()
+		destroyDB(db)
+	}
+}
+
+func TestDB_HashIndex(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-hash")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.IndexType = Hash
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	expected := putManifestTestData(t, db)
+	err = db.Merge()
+	assert.Nil(t, err)
+	checkManifestTestData(t, db, expected)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	checkManifestTestData(t, db2, expected)
+
+	// Iterating still works, in the order of the keys
+	var prev []byte
+	var count int
+	err = db2.Fold(func(key []byte, value []byte) bool {
+		assert.True(t, bytes.Compare(prev, key) < 0)
+		prev = key
+		count++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, len(expected), count)
+}
+
+func TestDB_MMapIO(t *testing.T) {
+	testActiveFileIOType(t, MMapIO)
+}
+
+func testActiveFileIOType(t *testing.T, ioType FileIOType) {
+	for _, indexType := range []IndexerType{Btree, BPlusTree} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-mmap-io")
+		opts.DirPath = dir
+		opts.DataFileSize = 64 * 1024
+		opts.IndexType = indexType
+		opts.ActiveFileIOType = ioType
+		db, err := Open(opts)
+		assert.Nil(t, err)
+		assert.NotNil(t, db)
+
+		// Data are in more than one data files
+		values := make(map[int][]byte)
+		for i := 0; i < 1000; i++ {
+			values[i] = utils.RandomValue(128)
+			err := db.Put(utils.GetTestKey(i), values[i])
+			assert.Nil(t, err)
+		}
+		check := func(db *DB) {
+			for i, value := range values {
+				val, err := db.Get(utils.GetTestKey(i))
+				assert.Nil(t, err)
+				assert.Equal(t, value, val)
+			}
+		}
+		check(db)
+
+		// Older files are truncated to the real size
+		for fid := range db.olderFiles {
+			stat, err := opts.FS.Stat(data.GetDataFileName(dir, fid))
+			assert.Nil(t, err)
+			assert.True(t, stat.Size() < opts.DataFileSize)
+		}
+
+		// Restart like a crash after syncing, the active file is not closed
+		err = db.Sync()
+		assert.Nil(t, err)
+		if indexType == BPlusTree {
+			err = db.index.Close()
+			assert.Nil(t, err)
+		}
+		err = db.fileLock.Unlock()
+		assert.Nil(t, err)
+		if (ioType == MMapIO || ioType == PreallocatedIO || ioType == DirectIO) && fio.IsOSFS(opts.FS) {
+			// The file is not truncated
+			stat, err := opts.FS.Stat(data.GetDataFileName(dir, db.activeFile.FileId))
+			assert.Nil(t, err)
+			assert.Equal(t, opts.DataFileSize, stat.Size())
+		}
+
+		db2, err := Open(opts)
+		assert.Nil(t, err)
+		check(db2)
+
+		// Write after the records, not after the zero-filled tail
+		values[1000] = []byte("new value")
+		err = db2.Put(utils.GetTestKey(1000), values[1000])
+		assert.Nil(t, err)
+		err = db2.Close()
+		assert.Nil(t, err)
+
+		db3, err := Open(opts)
+		assert.Nil(t, err)
+		check(db3)
+		destroyDB(db3)
+	}
+}
+
+func TestDB_BufferedIO(t *testing.T) {
+	testActiveFileIOType(t, BufferedIO)
+}
+
+func TestDB_PreallocatedIO(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("fallocate is only supported on linux")
+	}
+	testActiveFileIOType(t, PreallocatedIO)
+}
+
+// The zero-filled tail of the preallocated active file is not counted in the size of the database
+func TestDB_PreallocatedIO_DiskSize(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("fallocate is only supported on linux")
+	}
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-prealloc-size")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024 * 1024
+	opts.DataFileMergeRatio = 0.3
+	opts.ActiveFileIOType = PreallocatedIO
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 500; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+	assert.True(t, stat.DiskSize < opts.DataFileSize / 64)
+
+	// Half of the written data is reclaimable
+	err = db.Merge()
+	assert.Nil(t, err)
+}
+
+func TestDB_DirectIO(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("O_DIRECT is only supported on linux")
+	}
+	testActiveFileIOType(t, DirectIO)
+}
+
+func TestDB_MMapIO_TornRecord(t *testing.T) {
+	testActiveFileTornRecord(t, MMapIO)
+}
+
+func TestDB_PreallocatedIO_TornRecord(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("fallocate is only supported on linux")
+	}
+	testActiveFileTornRecord(t, PreallocatedIO)
+}
+
+func TestDB_DirectIO_TornRecord(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("O_DIRECT is only supported on linux")
+	}
+	testActiveFileTornRecord(t, DirectIO)
+}
+
+// Crash while writing a record to the pre-allocated active file
+// The part of the record written is followed by the zero-filled tail, not by the end of the file
+func testActiveFileTornRecord(t *testing.T, ioType FileIOType) {
+	if !fio.IsOSFS(DefaultOptions.FS) {
+		t.Skip("the IO type needs the file system of the OS")
+	}
+	for _, written := range []int{6, 60} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-torn-record")
+		opts.DirPath = dir
+		opts.DataFileSize = 64 * 1024
+		opts.ActiveFileIOType = ioType
+		db, err := Open(opts)
+		assert.Nil(t, err)
+
+		values := make(map[int][]byte)
+		for i := 0; i < 100; i++ {
+			values[i] = utils.RandomValue(128)
+			err := db.Put(utils.GetTestKey(i), values[i])
+			assert.Nil(t, err)
+		}
+		err = db.Sync()
+		assert.Nil(t, err)
+
+		// Only the first bytes of the record reach the disk, the process crashes
+		record := &data.LogRecord{Key: logRecordKeyWithSeq([]byte("torn"), nonTransactionSeqNo), Value: utils.RandomValue(128)}
+		enc, _ := db.activeFile.EncodeLogRecord(record)
+		file, err := os.OpenFile(data.GetDataFileName(dir, db.activeFile.FileId), os.O_RDWR, fio.DataFilePerm)
+		assert.Nil(t, err)
+		_, err = file.WriteAt(enc[:written], db.activeFile.WriteOff)
+		assert.Nil(t, err)
+		assert.Nil(t, file.Close())
+		err = db.fileLock.Unlock()
+		assert.Nil(t, err)
+
+		db2, err := Open(opts)
+		if !assert.Nil(t, err, "%d bytes written", written) {
+			continue
+		}
+		for i, value := range values {
+			val, err := db2.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, value, val)
+		}
+		_, err = db2.Get([]byte("torn"))
+		assert.Equal(t, ErrKeyNotFound, err)
+
+		// The torn record is overwritten
+		err = db2.Put([]byte("after-crash"), []byte("value"))
+		assert.Nil(t, err)
+		err = db2.Close()
+		assert.Nil(t, err)
+		db3, err := Open(opts)
+		assert.Nil(t, err)
+		val, err := db3.Get([]byte("after-crash"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("value"), val)
+		destroyDB(db3)
+	}
+}
+
+func TestDB_OpenCorruptedActiveFile(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-corrupted-active")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(100))
+		assert.Nil(t, err)
+	}
+	pos := db.index.Get(utils.GetTestKey(0))
+	fileName := data.GetDataFileName(dir, pos.Fid)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// Flipped bit in the value size of the first record, it runs past the end of the file
+	file, err := opts.FS.OpenFile(fileName, os.O_RDWR, fio.DataFilePerm)
+	assert.Nil(t, err)
+	buf := make([]byte, 1)
+	_, err = file.ReadAt(buf, pos.Offset + 7)
+	assert.Nil(t, err)
+	_, err = file.WriteAt([]byte{buf[0] ^ 0x40}, pos.Offset + 7)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+	stat, err := opts.FS.Stat(fileName)
+	assert.Nil(t, err)
+
+	// Opening fails, the records after the corrupted one are not dropped
+	_, err = Open(opts)
+	assert.True(t, errors.Is(err, data.ErrInvalidLogRecordSize))
+	assert.Contains(t, err.Error(), fmt.Sprintf("offset %d", pos.Offset))
+	stat2, err := opts.FS.Stat(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, stat.Size(), stat2.Size())
+
+	// The directory is not left locked by the failed Open
+	_, err = Open(opts)
+	assert.True(t, errors.Is(err, data.ErrInvalidLogRecordSize))
+}
+
+// A failed Open releases the index and the directory, it can be tried again in the same process
+func TestDB_OpenAgainAfterFailure(t *testing.T) {
+	for _, indexType := range []IndexerType{Btree, BPlusTree} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-open-again")
+		opts.DirPath = dir
+		opts.IndexType = indexType
+		db, err := Open(opts)
+		assert.Nil(t, err)
+		err = db.Put(utils.GetTestKey(1), utils.RandomValue(24))
+		assert.Nil(t, err)
+		err = db.Close()
+		assert.Nil(t, err)
+
+		// A data file whose name is not a number
+		badFile := filepath.Join(dir, "bad" + data.DataFileNameSuffix)
+		file, err := opts.FS.OpenFile(badFile, os.O_CREATE|os.O_RDWR, fio.DataFilePerm)
+		assert.Nil(t, err)
+		assert.Nil(t, file.Close())
+		for i := 0; i < 2; i++ {
+			result := make(chan error)
+			go func() {
+				_, err := Open(opts)
+				result <- err
+			}()
+			select {
+			case err := <-result:
+				assert.Equal(t, ErrDataDirectoryCorrupted, err)
+			case <-time.After(10 * time.Second):
+				t.Fatal("Open waits for the index file of the failed Open")
+			}
+		}
+
+		err = opts.FS.Remove(badFile)
+		assert.Nil(t, err)
+		db2, err := Open(opts)
+		assert.Nil(t, err)
+		_, err = db2.Get(utils.GetTestKey(1))
+		assert.Nil(t, err)
+		destroyDB(db2)
+	}
+}
+
 func TestDB_Delete(t *testing.T) {
 	opts := DefaultOptions
 	dir, _ := os.MkdirTemp("", "bitcask-go-delete")
@@ -202,6 +817,106 @@ func TestDB_Delete(t *testing.T) {
 	assert.Equal(t, val1, val2)
 }
 
+func TestDB_DeleteRange(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-delete-range")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(24))
+		assert.Nil(t, err)
+	}
+
+	// 1. Invalid range
+	err = db.DeleteRange(utils.GetTestKey(20), utils.GetTestKey(10))
+	assert.Equal(t, ErrInvalidRange, err)
+
+	// 2. Delete [10, 20)
+	err = db.DeleteRange(utils.GetTestKey(10), utils.GetTestKey(20))
+	assert.Nil(t, err)
+	assert.Equal(t, 90, len(db.ListKeys()))
+	_, err = db.Get(utils.GetTestKey(10))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get(utils.GetTestKey(19))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db.Get(utils.GetTestKey(20))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+
+	// 3. Put a key in the range again after deletion
+	err = db.Put(utils.GetTestKey(15), []byte("put after range deletion"))
+	assert.Nil(t, err)
+
+	// 4. No end, delete to the last key
+	err = db.DeleteRange(utils.GetTestKey(90), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 81, len(db.ListKeys()))
+
+	// 5. Test after restarting
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 81, len(db2.ListKeys()))
+	_, err = db2.Get(utils.GetTestKey(11))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db2.Get(utils.GetTestKey(95))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err = db2.Get(utils.GetTestKey(15))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("put after range deletion"), val)
+}
+
+func TestDB_DeletePrefix(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-delete-prefix")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put([]byte(fmt.Sprintf("tenant-a:%03d", i)), utils.RandomValue(24))
+		assert.Nil(t, err)
+		err = db.Put([]byte(fmt.Sprintf("tenant-b:%03d", i)), utils.RandomValue(24))
+		assert.Nil(t, err)
+	}
+
+	// 1. Empty prefix
+	err = db.DeletePrefix(nil)
+	assert.Equal(t, ErrKeyIsEmpty, err)
+
+	// 2. Delete all the keys of tenant-a
+	err = db.DeletePrefix([]byte("tenant-a:"))
+	assert.Nil(t, err)
+	assert.Equal(t, 100, len(db.ListKeys()))
+	_, err = db.Get([]byte("tenant-a:001"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+	assert.True(t, stat.ReclaimableSize > 0)
+
+	// 3. Test after restarting
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	keys := db2.ListKeys()
+	assert.Equal(t, 100, len(keys))
+	for _, key := range keys {
+		assert.True(t, bytes.HasPrefix(key, []byte("tenant-b:")))
+	}
+}
+
 func TestDB_ListKeys(t *testing.T) {
 	opts := DefaultOptions
 	dir, _ := os.MkdirTemp("", "bitcask-go-keys")
@@ -341,6 +1056,45 @@ func TestDB_Open(t *testing.T) {
 	t.Log("open time ", time.Since(now))
 }
 
+func TestDB_MemFS(t *testing.T) {
+	opts := DefaultOptions
+	opts.FS = fio.NewMemFS()
+	opts.DirPath = filepath.Join(os.TempDir(), "bitcask-go-memfs", "db")
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 500; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// Merged files are loaded when opening again
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	keys := db2.ListKeys()
+	assert.Equal(t, 500, len(keys))
+	stat, err := db2.Stat()
+	assert.Nil(t, err)
+	assert.True(t, stat.DiskSize > 0)
+
+	// Nothing is written to the disk
+	_, err = os.Stat(filepath.Join(os.TempDir(), "bitcask-go-memfs"))
+	assert.True(t, os.IsNotExist(err))
+}
+
 func TestDB_Stat(t *testing.T) {
 	opts := DefaultOptions
 	dir, _ := os.MkdirTemp("", "bitcask-go-stat")
@@ -364,9 +1118,13 @@ func TestDB_Stat(t *testing.T) {
 		assert.Nil(t, err)
 	}
 
-	stat := db.Stat()
+	stat, err := db.Stat()
+	assert.Nil(t, err)
 	t.Log(stat)
 	assert.NotNil(t, stat)
+	assert.Equal(t, uint(3000), stat.KeyNum)
+	assert.Equal(t, db.index.MemoryUsage(), stat.IndexMemory)
+	assert.Greater(t, stat.IndexMemory, int64(3000 * len(utils.GetTestKey(2000))))
 }
 
 func TestDB_Backup(t *testing.T) {
@@ -13,4 +13,11 @@ var (
 	ErrDatabaseIsInUse = errors.New("the database directory is in use")
 	ErrMergeRatioUnreached = errors.New("the merge ratio does not reach the threshold")
 	ErrNoEnoughSpaceForMerge = errors.New("no enough disk space for merge")
+	ErrInvalidRange = errors.New("the start of the range must be smaller than the end")
+	// Deprecated: seqNo is found in the data files if the seq-no file is missing, it is not returned any more
+	ErrSeqNoFileNotExist = errors.New("cannot use transaction, seq no file not exists")
+	ErrValueIsNotInteger = errors.New("the value is not an integer")
+	ErrIntegerOverflow = errors.New("increment or decrement would overflow")
+	ErrMergeOperatorNotSet = errors.New("merge operator is not set in options")
+	ErrLogRecordTooLarge = errors.New("the key and value are too large for one log record")
 )
\ No newline at end of file
@@ -0,0 +1,341 @@
+package kvproject
+
+import (
+	"bitcask-go/fio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// Faults injected by faultFS
+type faultKind int
+const (
+	faultNone faultKind = iota
+
+	// The operation and all the following ones fail, data not synced is dropped by restart
+	faultCrash
+
+	// Only half of the data is written
+	faultShortWrite
+
+	// Sync fails, the data is not made durable
+	faultSyncError
+
+	// Write fails with ENOSPC, nothing is written
+	faultNoSpace
+)
+
+var errCrashed = errors.New("injected crash")
+
+// File system for crash-consistency tests, built on MemFS
+// Every operation changing the file system is counted, a fault can be injected at a chosen operation
+// Content of the files is durable only after Sync, creating, renaming and removing are durable at once
+type faultFS struct {
+	fs *fio.MemFS
+	mu sync.Mutex
+	durable map[string][]byte      // Synced content of the files
+	ops int                        // Number of operations so far
+	faultAt int                    // The fault is injected at the first matching operation from this one
+	kind faultKind
+	injected bool                  // Has the fault been injected?
+	crashed bool
+}
+
+func newFaultFS() *faultFS {
+	return &faultFS{fs: fio.NewMemFS(), durable: make(map[string][]byte)}
+}
+
+// Inject the fault at the n-th operation from now
+// Faults other than crash are injected only once
+func (ffs *faultFS) injectAfter(n int, kind faultKind) {
+	ffs.mu.Lock()
+	defer ffs.mu.Unlock()
+	ffs.faultAt = ffs.ops + n
+	ffs.kind = kind
+	ffs.injected = false
+}
+
+// Stop injecting the fault, return whether it has been injected
+func (ffs *faultFS) stopFault() bool {
+	ffs.mu.Lock()
+	defer ffs.mu.Unlock()
+	ffs.kind = faultNone
+	return ffs.injected
+}
+
+func (ffs *faultFS) hasCrashed() bool {
+	ffs.mu.Lock()
+	defer ffs.mu.Unlock()
+	return ffs.crashed
+}
+
+// Count an operation, return the fault to inject
+// isWrite and isSync tell which faults the operation can have
+func (ffs *faultFS) operate(isWrite bool, isSync bool) (faultKind, error) {
+	ffs.mu.Lock()
+	defer ffs.mu.Unlock()
+	if ffs.crashed {
+		return faultCrash, errCrashed
+	}
+	ffs.ops++
+	if ffs.kind == faultNone || ffs.ops < ffs.faultAt {
+		return faultNone, nil
+	}
+
+	switch ffs.kind {
+	case faultCrash:
+		ffs.crashed = true
+		ffs.injected = true
+		return faultCrash, errCrashed
+	case faultShortWrite, faultNoSpace:
+		if !isWrite {
+			return faultNone, nil
+		}
+	case faultSyncError:
+		if !isSync {
+			return faultNone, nil
+		}
+	}
+	kind := ffs.kind
+	ffs.kind = faultNone
+	ffs.injected = true
+	return kind, nil
+}
+
+// Return the file system after a crash, only the synced data of the files are kept
+func (ffs *faultFS) restart() *faultFS {
+	ffs.mu.Lock()
+	defer ffs.mu.Unlock()
+
+	restarted := newFaultFS()
+	var walk func(dir string)
+	walk = func(dir string) {
+		entries, _ := ffs.fs.ReadDir(dir)
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			if entry.IsDir() {
+				_ = restarted.fs.MkdirAll(path, os.ModePerm)
+				walk(path)
+				continue
+			}
+			file, err := restarted.fs.OpenFile(path, os.O_CREATE|os.O_RDWR, fio.DataFilePerm)
+			if err != nil {
+				panic(err)
+			}
+			if data := ffs.durable[path]; len(data) > 0 {
+				_, _ = file.Write(data)
+				restarted.durable[path] = append([]byte(nil), data...)
+			}
+			_ = file.Close()
+		}
+	}
+	walk("/")
+	return restarted
+}
+
+func (ffs *faultFS) OpenFile(name string, flag int, perm os.FileMode) (fio.File, error) {
+	if flag&os.O_CREATE != 0 {
+		if _, err := ffs.operate(false, false); err != nil {
+			return nil, err
+		}
+	}
+	file, err := ffs.fs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &faultFile{ffs: ffs, name: filepath.Clean(name), file: file}, nil
+}
+
+func (ffs *faultFS) ReadDir(name string) ([]os.DirEntry, error) {
+	if ffs.hasCrashed() {
+		return nil, errCrashed
+	}
+	return ffs.fs.ReadDir(name)
+}
+
+func (ffs *faultFS) Stat(name string) (os.FileInfo, error) {
+	if ffs.hasCrashed() {
+		return nil, errCrashed
+	}
+	return ffs.fs.Stat(name)
+}
+
+func (ffs *faultFS) MkdirAll(path string, perm os.FileMode) error {
+	if _, err := ffs.operate(false, false); err != nil {
+		return err
+	}
+	return ffs.fs.MkdirAll(path, perm)
+}
+
+func (ffs *faultFS) Rename(oldpath, newpath string) error {
+	if _, err := ffs.operate(false, false); err != nil {
+		return err
+	}
+	if err := ffs.fs.Rename(oldpath, newpath); err != nil {
+		return err
+	}
+	ffs.mu.Lock()
+	defer ffs.mu.Unlock()
+	oldpath, newpath = filepath.Clean(oldpath), filepath.Clean(newpath)
+	delete(ffs.durable, newpath)
+	if data, ok := ffs.durable[oldpath]; ok {
+		ffs.durable[newpath] = data
+		delete(ffs.durable, oldpath)
+	}
+	// Files in the directory
+	prefix := oldpath + string(filepath.Separator)
+	for path, data := range ffs.durable {
+		if len(path) > len(prefix) && path[:len(prefix)] == prefix {
+			ffs.durable[filepath.Join(newpath, path[len(prefix):])] = data
+			delete(ffs.durable, path)
+		}
+	}
+	return nil
+}
+
+// Renames are already durable, syncing the directory is only counted
+func (ffs *faultFS) SyncDir(name string) error {
+	kind, err := ffs.operate(false, true)
+	if err != nil {
+		return err
+	}
+	if kind == faultSyncError {
+		return syscall.EIO
+	}
+	return ffs.fs.SyncDir(name)
+}
+
+func (ffs *faultFS) Remove(name string) error {
+	if _, err := ffs.operate(false, false); err != nil {
+		return err
+	}
+	if err := ffs.fs.Remove(name); err != nil {
+		return err
+	}
+	ffs.mu.Lock()
+	defer ffs.mu.Unlock()
+	delete(ffs.durable, filepath.Clean(name))
+	return nil
+}
+
+func (ffs *faultFS) RemoveAll(path string) error {
+	if _, err := ffs.operate(false, false); err != nil {
+		return err
+	}
+	if err := ffs.fs.RemoveAll(path); err != nil {
+		return err
+	}
+	ffs.mu.Lock()
+	defer ffs.mu.Unlock()
+	path = filepath.Clean(path)
+	prefix := path + string(filepath.Separator)
+	for p := range ffs.durable {
+		if p == path || (len(p) > len(prefix) && p[:len(prefix)] == prefix) {
+			delete(ffs.durable, p)
+		}
+	}
+	return nil
+}
+
+func (ffs *faultFS) Lock(name string) fio.Locker {
+	return ffs.fs.Lock(name)
+}
+
+func (ffs *faultFS) DiskFree(path string) (uint64, error) {
+	return ffs.fs.DiskFree(path)
+}
+
+// File opened from faultFS
+type faultFile struct {
+	ffs *faultFS
+	name string
+	file fio.File
+}
+
+func (f *faultFile) ReadAt(b []byte, offset int64) (int, error) {
+	if f.ffs.hasCrashed() {
+		return 0, errCrashed
+	}
+	return f.file.ReadAt(b, offset)
+}
+
+func (f *faultFile) Write(b []byte) (int, error) {
+	kind, err := f.ffs.operate(true, false)
+	if err != nil {
+		return 0, err
+	}
+	switch kind {
+	case faultShortWrite:
+		n, err := f.file.Write(b[:len(b) / 2])
+		if err != nil {
+			return n, err
+		}
+		return n, io.ErrShortWrite
+	case faultNoSpace:
+		return 0, syscall.ENOSPC
+	}
+	return f.file.Write(b)
+}
+
+func (f *faultFile) WriteAt(b []byte, offset int64) (int, error) {
+	kind, err := f.ffs.operate(true, false)
+	if err != nil {
+		return 0, err
+	}
+	switch kind {
+	case faultShortWrite:
+		n, err := f.file.WriteAt(b[:len(b) / 2], offset)
+		if err != nil {
+			return n, err
+		}
+		return n, io.ErrShortWrite
+	case faultNoSpace:
+		return 0, syscall.ENOSPC
+	}
+	return f.file.WriteAt(b, offset)
+}
+
+// Make the current content of the file durable
+func (f *faultFile) Sync() error {
+	kind, err := f.ffs.operate(false, true)
+	if err != nil {
+		return err
+	}
+	if kind == faultSyncError {
+		return syscall.EIO
+	}
+
+	stat, err := f.file.Stat()
+	if err != nil {
+		return err
+	}
+	data := make([]byte, stat.Size())
+	if _, err := f.file.ReadAt(data, 0); err != nil && err != io.EOF {
+		return err
+	}
+	f.ffs.mu.Lock()
+	defer f.ffs.mu.Unlock()
+	f.ffs.durable[f.name] = data
+	return nil
+}
+
+func (f *faultFile) Truncate(size int64) error {
+	if _, err := f.ffs.operate(false, false); err != nil {
+		return err
+	}
+	return f.file.Truncate(size)
+}
+
+func (f *faultFile) Stat() (os.FileInfo, error) {
+	if f.ffs.hasCrashed() {
+		return nil, errCrashed
+	}
+	return f.file.Stat()
+}
+
+func (f *faultFile) Close() error {
+	return f.file.Close()
+}
@@ -0,0 +1,71 @@
+package kvproject
+
+import "bitcask-go/data"
+
+// Data files replaced by merge are used by the iterators created before the merge
+// Iterators hold references to the files, a replaced file is obsolete and deleted when the last reference is released
+// Reading by key holds the read lock, or filesMu which is held when closing the replaced files, so it needs no reference
+
+// Hold references to all the data files
+// Must have lock when using this method
+func (db *DB) refDataFiles() []uint32 {
+	db.refMu.Lock()
+	defer db.refMu.Unlock()
+
+	fids := make([]uint32, 0, len(db.olderFiles) + 1)
+	for fid := range db.olderFiles {
+		fids = append(fids, fid)
+	}
+	if db.activeFile != nil {
+		fids = append(fids, db.activeFile.FileId)
+	}
+	for _, fid := range fids {
+		db.fileRefs[fid]++
+	}
+	return fids
+}
+
+// Release the references, obsolete files are deleted when they are not used any more
+func (db *DB) unrefDataFiles(fids []uint32) {
+	db.refMu.Lock()
+	defer db.refMu.Unlock()
+
+	for _, fid := range fids {
+		db.fileRefs[fid]--
+		if db.fileRefs[fid] > 0 {
+			continue
+		}
+		delete(db.fileRefs, fid)
+		if dataFile, ok := db.obsoleteFiles[fid]; ok {
+			delete(db.obsoleteFiles, fid)
+			db.removeDataFile(dataFile)
+		}
+	}
+}
+
+// The file is replaced by merge, delete it now or when the last reference is released
+// Must have lock when using this method
+func (db *DB) retireDataFile(dataFile *data.DataFile) {
+	db.refMu.Lock()
+	defer db.refMu.Unlock()
+
+	if db.fileRefs[dataFile.FileId] > 0 {
+		db.obsoleteFiles[dataFile.FileId] = dataFile
+		return
+	}
+	db.removeDataFile(dataFile)
+}
+
+// Find the obsolete file still used by iterators
+func (db *DB) getObsoleteDataFile(fid uint32) *data.DataFile {
+	db.refMu.Lock()
+	defer db.refMu.Unlock()
+	return db.obsoleteFiles[fid]
+}
+
+// Close the file and delete it, the mapping of the file is released when closing
+// The file is not live in the manifest, if deleting fails it is deleted at the next start
+func (db *DB) removeDataFile(dataFile *data.DataFile) {
+	_ = dataFile.Close()
+	_ = db.options.FS.Remove(data.GetDataFileName(db.options.DirPath, dataFile.FileId))
+}
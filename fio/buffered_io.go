@@ -0,0 +1,133 @@
+package fio
+
+import (
+	"io"
+	"os"
+)
+
+// Size of the buffer of BufferedFileIO
+const bufferedIOSize = 64 * 1024
+
+// File IO with a user-space buffer for appending
+// Written data is kept in the buffer, and written to the file when the buffer is full, or Sync and Close are called
+// Data in the buffer is lost if the process crashes
+type BufferedFileIO struct {
+	fd File
+	buf []byte          // Data not written to the file
+	flushed int64       // Size of the data in the file
+}
+
+// Initialize BufferedFileIO, the file is opened in fs
+func NewBufferedFileIOManager(fs FS, fileName string) (*BufferedFileIO, error) {
+	fd, err := fs.OpenFile(
+		fileName,
+		os.O_CREATE|os.O_RDWR|os.O_APPEND, // If the file does not exist, create a new file
+		DataFilePerm,
+	)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+	return &BufferedFileIO{
+		fd: fd,
+		buf: make([]byte, 0, bufferedIOSize),
+		flushed: stat.Size(),
+	}, nil
+}
+
+// Data before flushed is read from the file, the rest is read from the buffer
+func (bio *BufferedFileIO) Read(b []byte, offset int64) (int, error) {
+	var n int
+	if offset < bio.flushed {
+		end := offset + int64(len(b))
+		if end > bio.flushed {
+			end = bio.flushed
+		}
+		m, err := bio.fd.ReadAt(b[:end - offset], offset)
+		n += m
+		if err != nil {
+			return n, err
+		}
+		offset = end
+	}
+	if n == len(b) {
+		return n, nil
+	}
+
+	bufOffset := offset - bio.flushed
+	if bufOffset >= int64(len(bio.buf)) {
+		return n, io.EOF
+	}
+	n += copy(b[n:], bio.buf[bufOffset:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (bio *BufferedFileIO) Write(b []byte) (int, error) {
+	if len(bio.buf) + len(b) > cap(bio.buf) {
+		if err := bio.flush(); err != nil {
+			return 0, err
+		}
+	}
+	if len(b) > cap(bio.buf) {
+		// Larger than the buffer, write it directly
+		n, err := bio.fd.Write(b)
+		bio.flushed += int64(n)
+		return n, err
+	}
+	bio.buf = append(bio.buf, b...)
+	return len(b), nil
+}
+
+// Write the buffer to the file
+func (bio *BufferedFileIO) flush() error {
+	if len(bio.buf) == 0 {
+		return nil
+	}
+	n, err := bio.fd.Write(bio.buf)
+	bio.flushed += int64(n)
+	// Keep the part not written if something went wrong
+	bio.buf = bio.buf[:copy(bio.buf, bio.buf[n:])]
+	return err
+}
+
+func (bio *BufferedFileIO) Sync() error {
+	if err := bio.flush(); err != nil {
+		return err
+	}
+	return bio.fd.Sync()
+}
+
+// Drop the data after size
+func (bio *BufferedFileIO) Truncate(size int64) error {
+	if size >= bio.flushed {
+		if size - bio.flushed > int64(len(bio.buf)) {
+			return io.EOF
+		}
+		bio.buf = bio.buf[:size - bio.flushed]
+		return nil
+	}
+	bio.buf = bio.buf[:0]
+	if err := bio.fd.Truncate(size); err != nil {
+		return err
+	}
+	bio.flushed = size
+	return nil
+}
+
+func (bio *BufferedFileIO) Close() error {
+	if err := bio.flush(); err != nil {
+		return err
+	}
+	return bio.fd.Close()
+}
+
+func (bio *BufferedFileIO) Size() (int64, error) {
+	return bio.flushed + int64(len(bio.buf)), nil
+}
@@ -0,0 +1,117 @@
+package fio
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBufferedFileIO_Write(t *testing.T) {
+	path := filepath.Join("/tmp", "buffered-a.data")
+	defer destroyFile(path)
+
+	bio, err := NewBufferedFileIOManager(OSFS, path)
+	assert.Nil(t, err)
+
+	n, err := bio.Write([]byte("bitcask kv"))
+	assert.Nil(t, err)
+	assert.Equal(t, 10, n)
+
+	// Still in the buffer
+	stat, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), stat.Size())
+	size, err := bio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), size)
+
+	// Written to the file when syncing
+	err = bio.Sync()
+	assert.Nil(t, err)
+	stat, err = os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), stat.Size())
+
+	// Written to the file when the buffer is full
+	_, err = bio.Write(make([]byte, bufferedIOSize - 1))
+	assert.Nil(t, err)
+	_, err = bio.Write([]byte("storage"))
+	assert.Nil(t, err)
+	stat, err = os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10 + bufferedIOSize - 1), stat.Size())
+
+	// Larger than the buffer
+	_, err = bio.Write(make([]byte, 2 * bufferedIOSize))
+	assert.Nil(t, err)
+	size, err = bio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10 + bufferedIOSize - 1 + 7 + 2 * bufferedIOSize), size)
+
+	err = bio.Close()
+	assert.Nil(t, err)
+	stat, err = os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, size, stat.Size())
+}
+
+func TestBufferedFileIO_Read(t *testing.T) {
+	path := filepath.Join("/tmp", "buffered-b.data")
+	defer destroyFile(path)
+
+	bio, err := NewBufferedFileIOManager(OSFS, path)
+	assert.Nil(t, err)
+
+	_, err = bio.Write([]byte("key-a"))
+	assert.Nil(t, err)
+	err = bio.Sync()
+	assert.Nil(t, err)
+	_, err = bio.Write([]byte("key-b"))
+	assert.Nil(t, err)
+
+	// In the file
+	b1 := make([]byte, 5)
+	n, err := bio.Read(b1, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, []byte("key-a"), b1)
+
+	// In the buffer
+	b2 := make([]byte, 5)
+	_, err = bio.Read(b2, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-b"), b2)
+
+	// Part in the file and part in the buffer
+	b3 := make([]byte, 6)
+	_, err = bio.Read(b3, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("y-akey"), b3)
+
+	// After the end
+	b4 := make([]byte, 6)
+	n, err = bio.Read(b4, 6)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 4, n)
+
+	// Drop the data in the buffer and in the file
+	err = bio.Truncate(8)
+	assert.Nil(t, err)
+	size, err := bio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(8), size)
+	err = bio.Truncate(3)
+	assert.Nil(t, err)
+	_, err = bio.Write([]byte("-c"))
+	assert.Nil(t, err)
+	b5 := make([]byte, 5)
+	_, err = bio.Read(b5, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-c"), b5)
+
+	err = bio.Close()
+	assert.Nil(t, err)
+}
@@ -4,12 +4,12 @@ import "os"
 
 // standard file IO
 type FileIO struct {
-	fd *os.File // File description of standard file
+	fd File // File description of standard file
 }
 
-// Initialize FileIO, get file description and open the file
-func NewFileIOManager(fileName string) (*FileIO, error) {
-	fd, err := os.OpenFile(
+// Initialize FileIO, get file description and open the file in fs
+func NewFileIOManager(fs FS, fileName string) (*FileIO, error) {
+	fd, err := fs.OpenFile(
 		fileName,
 		os.O_CREATE|os.O_RDWR|os.O_APPEND, // If the file does not exist, create a new file
 		DataFilePerm,
@@ -44,3 +44,7 @@ func (fio *FileIO) Size() (int64, error) {
 	return stat.Size(), nil
 }
 
+// Drop the data after size
+func (fio *FileIO) Truncate(size int64) error {
+	return fio.fd.Truncate(size)
+}
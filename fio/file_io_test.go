@@ -17,7 +17,7 @@ func destroyFile(name string){
 
 func TestNewFileIOManager(t *testing.T) {
 	path := filepath.Join("/tmp", "a.data")
-	fio, err := NewFileIOManager(path)
+	fio, err := NewFileIOManager(OSFS, path)
 	defer destroyFile(path)
 
 	assert.Nil(t, err)
@@ -26,7 +26,7 @@ func TestNewFileIOManager(t *testing.T) {
 
 func TestFileIO_Write(t *testing.T) {
 	path := filepath.Join("/tmp", "a.data")
-	fio, err := NewFileIOManager(path)
+	fio, err := NewFileIOManager(OSFS, path)
 	defer destroyFile(path)
 
 	assert.Nil(t, err)
@@ -44,7 +44,7 @@ func TestFileIO_Write(t *testing.T) {
 
 func TestFileIO_Read(t *testing.T) {
 	path := filepath.Join("/tmp", "a.data")
-	fio, err := NewFileIOManager(path)
+	fio, err := NewFileIOManager(OSFS, path)
 	defer destroyFile(path)
 
 	assert.Nil(t, err)
@@ -71,7 +71,7 @@ func TestFileIO_Read(t *testing.T) {
 
 func TestFileIO_Sync(t *testing.T) {
 	path := filepath.Join("/tmp", "a.data")
-	fio, err := NewFileIOManager(path)
+	fio, err := NewFileIOManager(OSFS, path)
 	defer destroyFile(path)
 
 	assert.Nil(t, err)
@@ -83,7 +83,7 @@ func TestFileIO_Sync(t *testing.T) {
 
 func TestFileID_Close(t *testing.T) {
 	path := filepath.Join("/tmp", "a.data")
-	fio, err := NewFileIOManager(path)
+	fio, err := NewFileIOManager(OSFS, path)
 	defer destroyFile(path)
 
 	assert.Nil(t, err)
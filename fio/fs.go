@@ -0,0 +1,72 @@
+package fio
+
+import (
+	"io"
+	"os"
+)
+
+// File opened by FS
+// *os.File implements it
+type File interface {
+	io.ReaderAt
+	io.Writer
+	io.WriterAt
+	io.Closer
+
+	// make data persistent
+	Sync() error
+
+	// Change the size of the file
+	Truncate(size int64) error
+
+	Stat() (os.FileInfo, error)
+}
+
+// Lock which is held by only one user at a time
+// *flock.Flock implements it
+type Locker interface {
+	// Get the lock without waiting, return false if someone else holds it
+	TryLock() (bool, error)
+
+	// Release the lock
+	Unlock() error
+}
+
+// File system where the database stores its files
+// The errors of missing files are the same as package os, so os.IsNotExist can be used
+type FS interface {
+	// Open the file with flags of os.OpenFile
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+
+	// Return the entries of the directory sorted by name
+	ReadDir(name string) ([]os.DirEntry, error)
+
+	Stat(name string) (os.FileInfo, error)
+
+	// Create the directory and all the parents
+	MkdirAll(path string, perm os.FileMode) error
+
+	Rename(oldpath, newpath string) error
+
+	// Make the entries of the directory durable, such as files created, renamed or removed in it
+	SyncDir(name string) error
+
+	// Remove a file or an empty directory
+	Remove(name string) error
+
+	// Remove path and everything in it, return nil if path doesn't exist
+	RemoveAll(path string) error
+
+	// Return a lock on the file, the file is created when locking
+	Lock(name string) Locker
+
+	// Get the remaining available space for path
+	DiskFree(path string) (uint64, error)
+}
+
+// Is fs the file system of the OS?
+// Memory map and the IO types using system calls need files of the OS
+func IsOSFS(fs FS) bool {
+	_, ok := fs.(osFS)
+	return ok
+}
@@ -0,0 +1,444 @@
+package fio
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errIsDir = errors.New("is a directory")
+	errNotDir = errors.New("not a directory")
+	errDirNotEmpty = errors.New("directory not empty")
+	errReadOnly = errors.New("file is opened read-only")
+)
+
+// File system in memory
+// Data is lost when the process exits, it is used for ephemeral caches and tests
+// Locks only work in the same MemFS
+type MemFS struct {
+	mu sync.Mutex
+	nodes map[string]*memNode      // Cleaned path -> file or directory
+	locks map[string]bool          // Paths of the files which are locked
+}
+
+// File or directory of MemFS
+type memNode struct {
+	mu sync.RWMutex
+	dir bool
+	perm os.FileMode
+	modTime time.Time
+	data []byte
+}
+
+// Initialize MemFS, only the root directory exists
+func NewMemFS() *MemFS {
+	now := time.Now()
+	return &MemFS{
+		nodes: map[string]*memNode{
+			"/": {dir: true, perm: os.ModePerm, modTime: now},
+			".": {dir: true, perm: os.ModePerm, modTime: now},
+		},
+		locks: make(map[string]bool),
+	}
+}
+
+func (mfs *MemFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	name = filepath.Clean(name)
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	node, ok := mfs.nodes[name]
+	if ok {
+		if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+		}
+		if node.dir {
+			return nil, &os.PathError{Op: "open", Path: name, Err: errIsDir}
+		}
+		if flag&os.O_TRUNC != 0 {
+			node.mu.Lock()
+			node.data = nil
+			node.modTime = time.Now()
+			node.mu.Unlock()
+		}
+	} else {
+		if flag&os.O_CREATE == 0 {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		if err := mfs.checkParent("open", name); err != nil {
+			return nil, err
+		}
+		node = &memNode{perm: perm, modTime: time.Now()}
+		mfs.nodes[name] = node
+	}
+	return &memFile{node: node, name: name, flag: flag}, nil
+}
+
+// The parent directory of name must exist, called with mu held
+func (mfs *MemFS) checkParent(op string, name string) error {
+	parent, ok := mfs.nodes[filepath.Dir(name)]
+	if !ok {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	if !parent.dir {
+		return &os.PathError{Op: op, Path: name, Err: errNotDir}
+	}
+	return nil
+}
+
+func (mfs *MemFS) ReadDir(name string) ([]os.DirEntry, error) {
+	name = filepath.Clean(name)
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	node, ok := mfs.nodes[name]
+	if !ok {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: os.ErrNotExist}
+	}
+	if !node.dir {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: errNotDir}
+	}
+
+	var entries []os.DirEntry
+	for path, child := range mfs.nodes {
+		if path != name && filepath.Dir(path) == name {
+			entries = append(entries, fs.FileInfoToDirEntry(child.info(path)))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+func (mfs *MemFS) Stat(name string) (os.FileInfo, error) {
+	name = filepath.Clean(name)
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	node, ok := mfs.nodes[name]
+	if !ok {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return node.info(name), nil
+}
+
+func (mfs *MemFS) MkdirAll(path string, perm os.FileMode) error {
+	path = filepath.Clean(path)
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	// Create from the first missing parent
+	var missing []string
+	for p := path; ; p = filepath.Dir(p) {
+		node, ok := mfs.nodes[p]
+		if ok {
+			if !node.dir {
+				return &os.PathError{Op: "mkdir", Path: p, Err: errNotDir}
+			}
+			break
+		}
+		missing = append(missing, p)
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		mfs.nodes[missing[i]] = &memNode{dir: true, perm: perm, modTime: time.Now()}
+	}
+	return nil
+}
+
+// Files and directories can be renamed, the file at newpath is replaced
+func (mfs *MemFS) Rename(oldpath, newpath string) error {
+	oldpath, newpath = filepath.Clean(oldpath), filepath.Clean(newpath)
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	node, ok := mfs.nodes[oldpath]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrNotExist}
+	}
+	if oldpath == newpath {
+		return nil
+	}
+	if err := mfs.checkParent("rename", newpath); err != nil {
+		return err
+	}
+	if target, ok := mfs.nodes[newpath]; ok && target.dir != node.dir {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: errIsDir}
+	}
+
+	delete(mfs.nodes, oldpath)
+	mfs.nodes[newpath] = node
+	if node.dir {
+		// Move everything in the directory
+		prefix := oldpath + string(filepath.Separator)
+		for path, child := range mfs.nodes {
+			if strings.HasPrefix(path, prefix) {
+				delete(mfs.nodes, path)
+				mfs.nodes[filepath.Join(newpath, path[len(prefix):])] = child
+			}
+		}
+	}
+	return nil
+}
+
+// Entries of MemFS are durable at once, only check the directory exists
+func (mfs *MemFS) SyncDir(name string) error {
+	name = filepath.Clean(name)
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	node, ok := mfs.nodes[name]
+	if !ok {
+		return &os.PathError{Op: "sync", Path: name, Err: os.ErrNotExist}
+	}
+	if !node.dir {
+		return &os.PathError{Op: "sync", Path: name, Err: errNotDir}
+	}
+	return nil
+}
+
+func (mfs *MemFS) Remove(name string) error {
+	name = filepath.Clean(name)
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	node, ok := mfs.nodes[name]
+	if !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	if node.dir {
+		prefix := name + string(filepath.Separator)
+		for path := range mfs.nodes {
+			if strings.HasPrefix(path, prefix) {
+				return &os.PathError{Op: "remove", Path: name, Err: errDirNotEmpty}
+			}
+		}
+	}
+	delete(mfs.nodes, name)
+	return nil
+}
+
+func (mfs *MemFS) RemoveAll(path string) error {
+	path = filepath.Clean(path)
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	prefix := path + string(filepath.Separator)
+	for p := range mfs.nodes {
+		if p == path || strings.HasPrefix(p, prefix) {
+			delete(mfs.nodes, p)
+		}
+	}
+	return nil
+}
+
+func (mfs *MemFS) Lock(name string) Locker {
+	return &memLock{fs: mfs, name: filepath.Clean(name)}
+}
+
+// Memory is not limited by MemFS
+func (mfs *MemFS) DiskFree(path string) (uint64, error) {
+	return math.MaxUint64, nil
+}
+
+func (node *memNode) info(path string) os.FileInfo {
+	node.mu.RLock()
+	defer node.mu.RUnlock()
+	mode := node.perm
+	if node.dir {
+		mode |= os.ModeDir
+	}
+	return &memFileInfo{
+		name: filepath.Base(path),
+		size: int64(len(node.data)),
+		mode: mode,
+		modTime: node.modTime,
+	}
+}
+
+// File opened from MemFS
+type memFile struct {
+	node *memNode
+	name string
+	flag int
+	offset int64       // Where Write writes to, unless the file is opened with O_APPEND
+	closed bool
+}
+
+func (f *memFile) ReadAt(b []byte, offset int64) (int, error) {
+	if f.closed {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: os.ErrClosed}
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: errors.New("negative offset")}
+	}
+	f.node.mu.RLock()
+	defer f.node.mu.RUnlock()
+	if offset >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, f.node.data[offset:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) Write(b []byte) (int, error) {
+	if err := f.checkWrite("write"); err != nil {
+		return 0, err
+	}
+	f.node.mu.Lock()
+	defer f.node.mu.Unlock()
+	if f.flag&os.O_APPEND != 0 {
+		f.offset = int64(len(f.node.data))
+	}
+	n := f.node.writeAt(b, f.offset)
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *memFile) WriteAt(b []byte, offset int64) (int, error) {
+	if err := f.checkWrite("write"); err != nil {
+		return 0, err
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: errors.New("negative offset")}
+	}
+	f.node.mu.Lock()
+	defer f.node.mu.Unlock()
+	return f.node.writeAt(b, offset), nil
+}
+
+// Write b at offset, the file is extended with 0 if needed, called with mu held
+func (node *memNode) writeAt(b []byte, offset int64) int {
+	end := offset + int64(len(b))
+	if end > int64(len(node.data)) {
+		if end > int64(cap(node.data)) {
+			// At least double the capacity, so appending is not slow
+			capacity := 2 * int64(cap(node.data))
+			if capacity < end {
+				capacity = end
+			}
+			data := make([]byte, len(node.data), capacity)
+			copy(data, node.data)
+			node.data = data
+		}
+		node.data = node.data[:end]
+	}
+	node.modTime = time.Now()
+	return copy(node.data[offset:], b)
+}
+
+func (f *memFile) checkWrite(op string) error {
+	if f.closed {
+		return &os.PathError{Op: op, Path: f.name, Err: os.ErrClosed}
+	}
+	if f.flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return &os.PathError{Op: op, Path: f.name, Err: errReadOnly}
+	}
+	return nil
+}
+
+// Data is already in memory, nothing to do
+func (f *memFile) Sync() error {
+	if f.closed {
+		return &os.PathError{Op: "sync", Path: f.name, Err: os.ErrClosed}
+	}
+	return nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	if err := f.checkWrite("truncate"); err != nil {
+		return err
+	}
+	f.node.mu.Lock()
+	defer f.node.mu.Unlock()
+	if size < int64(len(f.node.data)) {
+		// Clear the dropped part, extending the file again must fill it with 0
+		for i := size; i < int64(len(f.node.data)); i++ {
+			f.node.data[i] = 0
+		}
+		f.node.data = f.node.data[:size]
+	} else if size > int64(len(f.node.data)) {
+		f.node.writeAt(make([]byte, size - int64(len(f.node.data))), int64(len(f.node.data)))
+	}
+	f.node.modTime = time.Now()
+	return nil
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	if f.closed {
+		return nil, &os.PathError{Op: "stat", Path: f.name, Err: os.ErrClosed}
+	}
+	return f.node.info(f.name), nil
+}
+
+func (f *memFile) Close() error {
+	if f.closed {
+		return &os.PathError{Op: "close", Path: f.name, Err: os.ErrClosed}
+	}
+	f.closed = true
+	return nil
+}
+
+type memFileInfo struct {
+	name string
+	size int64
+	mode os.FileMode
+	modTime time.Time
+}
+
+func (fi *memFileInfo) Name() string       { return fi.name }
+func (fi *memFileInfo) Size() int64        { return fi.size }
+func (fi *memFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi *memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *memFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *memFileInfo) Sys() interface{}   { return nil }
+
+// Lock of a file in MemFS
+type memLock struct {
+	fs *MemFS
+	name string
+	held bool
+}
+
+// Like flock, the file is created when locking
+func (l *memLock) TryLock() (bool, error) {
+	if l.held {
+		return true, nil
+	}
+	file, err := l.fs.OpenFile(l.name, os.O_CREATE|os.O_RDONLY, DataFilePerm)
+	if err != nil {
+		return false, err
+	}
+	_ = file.Close()
+
+	l.fs.mu.Lock()
+	defer l.fs.mu.Unlock()
+	if l.fs.locks[l.name] {
+		return false, nil
+	}
+	l.fs.locks[l.name] = true
+	l.held = true
+	return true, nil
+}
+
+func (l *memLock) Unlock() error {
+	if !l.held {
+		return nil
+	}
+	l.fs.mu.Lock()
+	defer l.fs.mu.Unlock()
+	delete(l.fs.locks, l.name)
+	l.held = false
+	return nil
+}
@@ -0,0 +1,190 @@
+package fio
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The same tests run on both file systems, so MemFS behaves like the OS
+func testFileSystems(t *testing.T, fn func(t *testing.T, fs FS, dir string)) {
+	t.Run("OS", func(t *testing.T) {
+		dir, _ := os.MkdirTemp("", "bitcask-go-fs")
+		defer destroyFile(dir)
+		fn(t, OSFS, dir)
+	})
+	t.Run("Mem", func(t *testing.T) {
+		fs := NewMemFS()
+		err := fs.MkdirAll("/tmp/bitcask-go-fs", os.ModePerm)
+		assert.Nil(t, err)
+		fn(t, fs, "/tmp/bitcask-go-fs")
+	})
+}
+
+func TestFS_File(t *testing.T) {
+	testFileSystems(t, func(t *testing.T, fs FS, dir string) {
+		name := filepath.Join(dir, "a.data")
+
+		// Not exist
+		_, err := fs.OpenFile(name, os.O_RDWR, DataFilePerm)
+		assert.True(t, os.IsNotExist(err))
+		_, err = fs.Stat(name)
+		assert.True(t, os.IsNotExist(err))
+		_, err = fs.OpenFile(filepath.Join(dir, "x", "a.data"), os.O_CREATE|os.O_RDWR, DataFilePerm)
+		assert.True(t, os.IsNotExist(err))
+
+		file, err := fs.OpenFile(name, os.O_CREATE|os.O_RDWR|os.O_APPEND, DataFilePerm)
+		assert.Nil(t, err)
+		_, err = file.Write([]byte("bitcask"))
+		assert.Nil(t, err)
+		_, err = file.Write([]byte(" kv"))
+		assert.Nil(t, err)
+		err = file.Sync()
+		assert.Nil(t, err)
+
+		b := make([]byte, 5)
+		n, err := file.ReadAt(b, 8)
+		assert.Equal(t, io.EOF, err)
+		assert.Equal(t, "kv", string(b[:n]))
+
+		// Extending after truncating fills 0
+		err = file.Truncate(3)
+		assert.Nil(t, err)
+		err = file.Truncate(5)
+		assert.Nil(t, err)
+		n, err = file.ReadAt(b, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte{'b', 'i', 't', 0, 0}, b[:n])
+
+		stat, err := file.Stat()
+		assert.Nil(t, err)
+		assert.Equal(t, int64(5), stat.Size())
+		assert.Equal(t, "a.data", stat.Name())
+		err = file.Close()
+		assert.Nil(t, err)
+
+		// WriteAt and O_TRUNC
+		file, err = fs.OpenFile(name, os.O_RDWR|os.O_TRUNC, DataFilePerm)
+		assert.Nil(t, err)
+		_, err = file.WriteAt([]byte("go"), 2)
+		assert.Nil(t, err)
+		n, err = file.ReadAt(b, 0)
+		assert.Equal(t, io.EOF, err)
+		assert.Equal(t, []byte{0, 0, 'g', 'o'}, b[:n])
+		err = file.Close()
+		assert.Nil(t, err)
+
+		// Read-only
+		file, err = fs.OpenFile(name, os.O_RDONLY, 0)
+		assert.Nil(t, err)
+		_, err = file.Write([]byte("x"))
+		assert.NotNil(t, err)
+		err = file.Close()
+		assert.Nil(t, err)
+	})
+}
+
+func TestFS_Dir(t *testing.T) {
+	testFileSystems(t, func(t *testing.T, fs FS, dir string) {
+		err := fs.MkdirAll(filepath.Join(dir, "sub", "sub"), os.ModePerm)
+		assert.Nil(t, err)
+		for _, name := range []string{"b.data", "a.data", "sub/c.data"} {
+			file, err := fs.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_RDWR, DataFilePerm)
+			assert.Nil(t, err)
+			_ = file.Close()
+		}
+
+		entries, err := fs.ReadDir(dir)
+		assert.Nil(t, err)
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		assert.Equal(t, []string{"a.data", "b.data", "sub"}, names)
+		assert.True(t, entries[2].IsDir())
+
+		// Rename files and directories
+		err = fs.Rename(filepath.Join(dir, "a.data"), filepath.Join(dir, "b.data"))
+		assert.Nil(t, err)
+		_, err = fs.Stat(filepath.Join(dir, "a.data"))
+		assert.True(t, os.IsNotExist(err))
+		err = fs.Rename(filepath.Join(dir, "sub"), filepath.Join(dir, "moved"))
+		assert.Nil(t, err)
+		_, err = fs.Stat(filepath.Join(dir, "moved", "c.data"))
+		assert.Nil(t, err)
+		stat, err := fs.Stat(filepath.Join(dir, "moved", "sub"))
+		assert.Nil(t, err)
+		assert.True(t, stat.IsDir())
+		err = fs.SyncDir(dir)
+		assert.Nil(t, err)
+		err = fs.SyncDir(filepath.Join(dir, "sub"))
+		assert.True(t, os.IsNotExist(err))
+
+		// Remove
+		err = fs.Remove(filepath.Join(dir, "moved"))
+		assert.NotNil(t, err)
+		err = fs.Remove(filepath.Join(dir, "b.data"))
+		assert.Nil(t, err)
+		err = fs.Remove(filepath.Join(dir, "b.data"))
+		assert.True(t, os.IsNotExist(err))
+		err = fs.RemoveAll(filepath.Join(dir, "moved"))
+		assert.Nil(t, err)
+		err = fs.RemoveAll(filepath.Join(dir, "moved"))
+		assert.Nil(t, err)
+		entries, err = fs.ReadDir(dir)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(entries))
+
+		free, err := fs.DiskFree(dir)
+		assert.Nil(t, err)
+		assert.True(t, free > 0)
+	})
+}
+
+func TestFS_Lock(t *testing.T) {
+	testFileSystems(t, func(t *testing.T, fs FS, dir string) {
+		name := filepath.Join(dir, "flock")
+		lock1 := fs.Lock(name)
+		hold, err := lock1.TryLock()
+		assert.Nil(t, err)
+		assert.True(t, hold)
+		_, err = fs.Stat(name)
+		assert.Nil(t, err)
+
+		lock2 := fs.Lock(name)
+		hold, err = lock2.TryLock()
+		assert.Nil(t, err)
+		assert.False(t, hold)
+
+		err = lock1.Unlock()
+		assert.Nil(t, err)
+		hold, err = lock2.TryLock()
+		assert.Nil(t, err)
+		assert.True(t, hold)
+		err = lock2.Unlock()
+		assert.Nil(t, err)
+	})
+}
+
+func TestNewIOManager_MemFS(t *testing.T) {
+	fs := NewMemFS()
+	// IO types which need files of the OS use standard file IO
+	for _, ioType := range []FileIOType{StandardFIO, MemoryMap, WritableMemoryMap, BufferedFIO, PreallocatedFIO, DirectFIO} {
+		ioManager, err := NewIOManagerWithCapacity(fs, "/a.data", ioType, 4096)
+		assert.Nil(t, err)
+		_, err = ioManager.Write([]byte("bitcask kv"))
+		assert.Nil(t, err)
+		err = ioManager.Sync()
+		assert.Nil(t, err)
+		size, err := ioManager.Size()
+		assert.Nil(t, err)
+		assert.Equal(t, int64(10), size)
+		err = ioManager.Close()
+		assert.Nil(t, err)
+		err = fs.Remove("/a.data")
+		assert.Nil(t, err)
+	}
+}
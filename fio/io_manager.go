@@ -9,6 +9,18 @@ const (
 
 	// Memory file mapping
 	MemoryMap
+
+	// Memory file mapping which can be written, the file is pre-allocated
+	WritableMemoryMap
+
+	// Standard file IO with a user-space buffer for appending
+	BufferedFIO
+
+	// File IO writing in a file pre-allocated by fallocate
+	PreallocatedFIO
+
+	// Same as PreallocatedFIO, but the file is opened with O_DIRECT
+	DirectFIO
 )
 
 // Abstract IOManager interface
@@ -31,13 +43,42 @@ type IOManager interface {
 	Size() (int64, error)
 }
 
-// Initialize IOManager, only support standard FileIO
-func NewIOManager(filename string, ioType FileIOType) (IOManager, error) {
+// IOManager which can return the data of the file without copying
+type BytesReader interface {
+	// Return n bytes at offset. The slice is only valid before the IOManager is closed, and must not be modified
+	Bytes(offset int64, n int) ([]byte, error)
+}
+
+// IOManager which can drop the data at the end of the file
+type Truncater interface {
+	// Drop the data after size
+	Truncate(size int64) error
+}
+
+// Initialize IOManager, the file is opened in fs
+func NewIOManager(fs FS, filename string, ioType FileIOType) (IOManager, error) {
+	return NewIOManagerWithCapacity(fs, filename, ioType, 0)
+}
+
+// Initialize IOManager, the file is pre-allocated to capacity if the IO type needs
+// Memory map and the IO types using system calls need files of the OS, standard file IO is used on other file systems
+func NewIOManagerWithCapacity(fs FS, filename string, ioType FileIOType, capacity int64) (IOManager, error) {
+	if !IsOSFS(fs) && ioType != BufferedFIO {
+		ioType = StandardFIO
+	}
 	switch ioType {
 	case StandardFIO:
-		return NewFileIOManager(filename)
+		return NewFileIOManager(fs, filename)
 	case MemoryMap:
 		return NewMMapIOManager(filename)
+	case WritableMemoryMap:
+		return NewWritableMMapIOManager(filename, capacity)
+	case BufferedFIO:
+		return NewBufferedFileIOManager(fs, filename)
+	case PreallocatedFIO:
+		return NewPreallocatedFileIOManager(filename, capacity, false)
+	case DirectFIO:
+		return NewPreallocatedFileIOManager(filename, capacity, true)
 	default:
 		panic("unsupported IO type")
 	}
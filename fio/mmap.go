@@ -2,19 +2,21 @@ package fio
 
 // MMap in this package can only be used to read
 import (
+	"errors"
+	"io"
 	"os"
-
-	"golang.org/x/exp/mmap"
+	"syscall"
 )
 
 // MMap memory map
+// The whole file is mapped when opening, data appended to the file later can't be seen
 type MMap struct {
-	readerAt *mmap.ReaderAt
+	data []byte
 }
 
 // Initialize MMap
 func NewMMapIOManager(fileName string) (*MMap, error){
-	_, err := os.OpenFile(
+	fd, err := os.OpenFile(
 		fileName,
 		os.O_CREATE|os.O_RDWR|os.O_APPEND, // If the file does not exist, create a new file
 		DataFilePerm,
@@ -22,16 +24,47 @@ func NewMMapIOManager(fileName string) (*MMap, error){
 	if err != nil {
 		return nil, err
 	}
-	
-	readerAt, err := mmap.Open(fileName)
+	// The mapping is still valid after the file is closed
+	defer fd.Close()
+
+	stat, err := fd.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := stat.Size()
+	if size == 0 {
+		// Empty file cannot be mapped
+		return &MMap{}, nil
+	}
+	if int64(int(size)) != size {
+		return nil, errors.New("file is too large to be mapped")
+	}
+
+	data, err := syscall.Mmap(int(fd.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
 	if err != nil {
 		return nil, err
 	}
-	return &MMap{readerAt: readerAt}, nil
+	return &MMap{data: data}, nil
 }
 
 func (mmap *MMap) Read(b []byte, offset int64) (int, error) {
-	return mmap.readerAt.ReadAt(b, offset)
+	if offset < 0 || offset >= int64(len(mmap.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, mmap.data[offset:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Return the n bytes at offset in the mapping without copying
+// The slice is only valid before Close, and must not be modified
+func (mmap *MMap) Bytes(offset int64, n int) ([]byte, error) {
+	if offset < 0 || n < 0 || offset + int64(n) > int64(len(mmap.data)) {
+		return nil, io.EOF
+	}
+	return mmap.data[offset : offset + int64(n) : offset + int64(n)], nil
 }
 
 func (mmap *MMap) Write([]byte) (int, error) {
@@ -45,9 +78,14 @@ func (mmap *MMap) Sync() error {
 }
 
 func (mmap *MMap) Close() error {
-	return mmap.readerAt.Close()
+	if mmap.data == nil {
+		return nil
+	}
+	data := mmap.data
+	mmap.data = nil
+	return syscall.Munmap(data)
 }
 
 func (mmap *MMap) Size() (int64, error) {
-	return int64(mmap.readerAt.Len()), nil
-}
\ No newline at end of file
+	return int64(len(mmap.data)), nil
+}
@@ -25,7 +25,7 @@ func TestMMap_Read(t *testing.T) {
 
 	// Need some data to test MMap, but MMap cannot be used to write
 	// So use fileIO to write some data
-	fio, err := NewFileIOManager(path)
+	fio, err := NewFileIOManager(OSFS, path)
 	assert.Nil(t, err)
 	_, err = fio.Write([]byte("aa"))
 	assert.Nil(t, err)
@@ -48,4 +48,34 @@ func TestMMap_Read(t *testing.T) {
 	assert.Nil(t, err)
 	t.Log(string(b2))
 	assert.Equal(t, string(b2), "aa")
-}
\ No newline at end of file
+}
+func TestMMap_Bytes(t *testing.T) {
+	path := filepath.Join("/tmp", "mmap-b.data")
+	defer destroyFile(path)
+
+	fio, err := NewFileIOManager(OSFS, path)
+	assert.Nil(t, err)
+	_, err = fio.Write([]byte("bitcask kv"))
+	assert.Nil(t, err)
+
+	mmapIO, err := NewMMapIOManager(path)
+	assert.Nil(t, err)
+	b1, err := mmapIO.Bytes(0, 7)
+	assert.Nil(t, err)
+	assert.Equal(t, "bitcask", string(b1))
+	b2, err := mmapIO.Bytes(8, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "kv", string(b2))
+
+	// Out of the file
+	_, err = mmapIO.Bytes(8, 3)
+	assert.Equal(t, io.EOF, err)
+
+	// Closed
+	err = mmapIO.Close()
+	assert.Nil(t, err)
+	_, err = mmapIO.Bytes(0, 1)
+	assert.Equal(t, io.EOF, err)
+	err = mmapIO.Close()
+	assert.Nil(t, err)
+}
@@ -0,0 +1,160 @@
+package fio
+
+import (
+	"io"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Writable memory map
+// The file is pre-allocated to the capacity, writes are copied into the mapping
+// The part after the written data is filled with 0, which is regarded as the end of the file when reading records
+// The file is truncated to the written size when closing
+type WritableMMap struct {
+	fd *os.File
+	data []byte      // Mapping of the whole file
+	size int64       // Size of the written data
+}
+
+// Initialize WritableMMap, the file is pre-allocated to capacity
+func NewWritableMMapIOManager(fileName string, capacity int64) (*WritableMMap, error) {
+	fd, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR, DataFilePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+	// The data written before is kept
+	// If the file was not truncated after a crash, its zero-filled tail and a record not written completely before it
+	// are regarded as written, the data file finds the end of the complete records and Truncate drops the rest
+	size := stat.Size()
+
+	wm := &WritableMMap{fd: fd, size: size}
+	if err := wm.remap(capacity); err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+	return wm, nil
+}
+
+// Extend the file to capacity and map the whole file again
+func (wm *WritableMMap) remap(capacity int64) error {
+	if capacity < wm.size {
+		capacity = wm.size
+	}
+	if capacity == 0 {
+		// Empty file cannot be mapped, map it when writing
+		return nil
+	}
+	if wm.data != nil {
+		if err := syscall.Munmap(wm.data); err != nil {
+			return err
+		}
+		wm.data = nil
+	}
+
+	if err := wm.fd.Truncate(capacity); err != nil {
+		return err
+	}
+	// Make the new size of the file persistent, then msync is enough for the data
+	if err := wm.fd.Sync(); err != nil {
+		return err
+	}
+	data, err := syscall.Mmap(int(wm.fd.Fd()), 0, int(capacity), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return err
+	}
+	wm.data = data
+	return nil
+}
+
+func (wm *WritableMMap) Read(b []byte, offset int64) (int, error) {
+	if offset < 0 || offset >= wm.size {
+		return 0, io.EOF
+	}
+	n := copy(b, wm.data[offset:wm.size])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (wm *WritableMMap) Write(b []byte) (int, error) {
+	end := wm.size + int64(len(b))
+	if end > int64(len(wm.data)) {
+		// Larger than the capacity, at least double it
+		capacity := 2 * int64(len(wm.data))
+		if capacity < end {
+			capacity = end
+		}
+		if err := wm.remap(capacity); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(wm.data[wm.size:end], b)
+	wm.size = end
+	return n, nil
+}
+
+func (wm *WritableMMap) Sync() error {
+	if wm.size == 0 {
+		return nil
+	}
+	return unix.Msync(wm.data[:wm.size], unix.MS_SYNC)
+}
+
+// Return n bytes at offset in the mapping without copying
+// The slice is only valid before the next Write or Close, and must not be modified
+func (wm *WritableMMap) Bytes(offset int64, n int) ([]byte, error) {
+	if offset < 0 || n < 0 || offset + int64(n) > wm.size {
+		return nil, io.EOF
+	}
+	return wm.data[offset : offset + int64(n) : offset + int64(n)], nil
+}
+
+// Drop the data after size
+// The file is truncated and extended again, so the dropped part is filled with 0 without writing it
+func (wm *WritableMMap) Truncate(size int64) error {
+	if size < 0 || size > wm.size {
+		return io.EOF
+	}
+	capacity := int64(len(wm.data))
+	if wm.data != nil {
+		if err := syscall.Munmap(wm.data); err != nil {
+			return err
+		}
+		wm.data = nil
+	}
+	if err := wm.fd.Truncate(size); err != nil {
+		return err
+	}
+	wm.size = size
+	return wm.remap(capacity)
+}
+
+// Release the mapping and truncate the file to the written size
+func (wm *WritableMMap) Close() error {
+	if wm.data != nil {
+		if err := unix.Msync(wm.data, unix.MS_SYNC); err != nil {
+			return err
+		}
+		if err := syscall.Munmap(wm.data); err != nil {
+			return err
+		}
+		wm.data = nil
+	}
+	if err := wm.fd.Truncate(wm.size); err != nil {
+		return err
+	}
+	return wm.fd.Close()
+}
+
+func (wm *WritableMMap) Size() (int64, error) {
+	return wm.size, nil
+}
@@ -0,0 +1,103 @@
+package fio
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWritableMMap_Write(t *testing.T) {
+	path := filepath.Join("/tmp", "mmap-w.data")
+	defer destroyFile(path)
+
+	wm, err := NewWritableMMapIOManager(path, 1024)
+	assert.Nil(t, err)
+
+	// The file is pre-allocated
+	stat, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1024), stat.Size())
+	size, err := wm.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), size)
+
+	n, err := wm.Write([]byte("bitcask kv"))
+	assert.Nil(t, err)
+	assert.Equal(t, 10, n)
+	_, err = wm.Write([]byte("storage"))
+	assert.Nil(t, err)
+	err = wm.Sync()
+	assert.Nil(t, err)
+
+	b := make([]byte, 7)
+	n, err = wm.Read(b, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 7, n)
+	assert.Equal(t, "storage", string(b))
+
+	// Read after the written data
+	_, err = wm.Read(b, 14)
+	assert.Equal(t, io.EOF, err)
+
+	// Larger than the capacity
+	_, err = wm.Write(make([]byte, 2048))
+	assert.Nil(t, err)
+	size, err = wm.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(17 + 2048), size)
+	n, err = wm.Read(b, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, "storage", string(b))
+
+	// The file is truncated when closing
+	err = wm.Close()
+	assert.Nil(t, err)
+	stat, err = os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(17 + 2048), stat.Size())
+}
+
+func TestWritableMMap_Truncate(t *testing.T) {
+	path := filepath.Join("/tmp", "mmap-t.data")
+	defer destroyFile(path)
+
+	wm, err := NewWritableMMapIOManager(path, 1024)
+	assert.Nil(t, err)
+	_, err = wm.Write([]byte("bitcask kv"))
+	assert.Nil(t, err)
+	err = wm.Sync()
+	assert.Nil(t, err)
+
+	// Open again without closing, like a crash
+	// The zero-filled tail is regarded as written until truncated
+	wm2, err := NewWritableMMapIOManager(path, 1024)
+	assert.Nil(t, err)
+	size, err := wm2.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1024), size)
+
+	err = wm2.Truncate(7)
+	assert.Nil(t, err)
+	size, err = wm2.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), size)
+
+	// The dropped part is filled with 0
+	_, err = wm2.Write([]byte("-go"))
+	assert.Nil(t, err)
+	b := make([]byte, 12)
+	n, err := wm2.Read(b, 0)
+	assert.Equal(t, 10, n)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "bitcask-go", string(b[:n]))
+	bytes, err := wm2.Bytes(0, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, "bitcask-go", string(bytes))
+
+	err = wm2.Close()
+	assert.Nil(t, err)
+	_ = wm.Close()
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package fio
+
+import "errors"
+
+// Initialize PreallocatedFileIO, fallocate and O_DIRECT are only supported on linux
+func NewPreallocatedFileIOManager(fileName string, capacity int64, direct bool) (IOManager, error) {
+	return nil, errors.New("pre-allocated file IO is only supported on linux")
+}
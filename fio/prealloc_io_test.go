@@ -0,0 +1,162 @@
+//go:build linux
+
+package fio
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreallocatedFileIO_Write(t *testing.T) {
+	for _, direct := range []bool{false, true} {
+		path := filepath.Join("/tmp", "prealloc-a.data")
+
+		pio, err := NewPreallocatedFileIOManager(path, 64 * 1024, direct)
+		assert.Nil(t, err)
+
+		// The file is pre-allocated
+		stat, err := os.Stat(path)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(64 * 1024), stat.Size())
+
+		// Small writes in the same block, and writes across blocks
+		var expected []byte
+		for i := 0; i < 1000; i++ {
+			b := []byte("bitcask kv storage")
+			if i % 100 == 0 {
+				b = make([]byte, 5000)
+				b[0] = byte(i)
+			}
+			n, err := pio.Write(b)
+			assert.Nil(t, err)
+			assert.Equal(t, len(b), n)
+			expected = append(expected, b...)
+		}
+		err = pio.Sync()
+		assert.Nil(t, err)
+
+		// Larger than the capacity
+		size, err := pio.Size()
+		assert.Nil(t, err)
+		assert.Equal(t, int64(len(expected)), size)
+		assert.True(t, size > 64 * 1024)
+
+		b := make([]byte, len(expected))
+		n, err := pio.Read(b, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, len(expected), n)
+		assert.Equal(t, expected, b)
+		b = make([]byte, 10)
+		_, err = pio.Read(b, 4093)
+		assert.Nil(t, err)
+		assert.Equal(t, expected[4093:4103], b)
+
+		// Read after the written data
+		n, err = pio.Read(b, size - 5)
+		assert.Equal(t, io.EOF, err)
+		assert.Equal(t, 5, n)
+
+		// The file is truncated when closing
+		err = pio.Close()
+		assert.Nil(t, err)
+		stat, err = os.Stat(path)
+		assert.Nil(t, err)
+		assert.Equal(t, size, stat.Size())
+
+		destroyFile(path)
+	}
+}
+
+func TestPreallocatedFileIO_Truncate(t *testing.T) {
+	for _, direct := range []bool{false, true} {
+		path := filepath.Join("/tmp", "prealloc-b.data")
+
+		pio, err := NewPreallocatedFileIOManager(path, 8192, direct)
+		assert.Nil(t, err)
+		_, err = pio.Write([]byte("bitcask kv"))
+		assert.Nil(t, err)
+		err = pio.Sync()
+		assert.Nil(t, err)
+
+		// Open again without closing, like a crash
+		// The zero-filled tail is regarded as written until truncated
+		pio2, err := NewPreallocatedFileIOManager(path, 8192, direct)
+		assert.Nil(t, err)
+		size, err := pio2.Size()
+		assert.Nil(t, err)
+		assert.Equal(t, int64(8192), size)
+
+		err = pio2.(Truncater).Truncate(7)
+		assert.Nil(t, err)
+		_, err = pio2.Write([]byte("-go"))
+		assert.Nil(t, err)
+		b := make([]byte, 12)
+		n, err := pio2.Read(b, 0)
+		assert.Equal(t, io.EOF, err)
+		assert.Equal(t, "bitcask-go", string(b[:n]))
+
+		err = pio2.Close()
+		assert.Nil(t, err)
+		_ = pio.Close()
+		destroyFile(path)
+	}
+}
+
+// Bytes written to the files by the process so far, wchar of /proc/self/io
+func processWrittenBytes(b *testing.B) int64 {
+	f, err := os.Open("/proc/self/io")
+	if err != nil {
+		b.Skip("/proc/self/io is not available")
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "wchar: ") {
+			n, err := strconv.ParseInt(strings.TrimPrefix(line, "wchar: "), 10, 64)
+			if err != nil {
+				b.Fatal(err)
+			}
+			return n
+		}
+	}
+	b.Skip("no wchar in /proc/self/io")
+	return 0
+}
+
+// With O_DIRECT every write rewrites the last block which isn't full
+// write-amplification is the bytes written to the file for each byte of the records
+func BenchmarkPreallocatedFileIO_Write(b *testing.B) {
+	for _, direct := range []bool{false, true} {
+		for _, recordSize := range []int{64, 512, 4096, 32 * 1024} {
+			b.Run(fmt.Sprintf("direct=%v/record=%d", direct, recordSize), func(b *testing.B) {
+				path := filepath.Join(b.TempDir(), "prealloc-bench.data")
+				pio, err := NewPreallocatedFileIOManager(path, 256 * 1024 * 1024, direct)
+				if err != nil {
+					b.Skip(err)
+				}
+				defer pio.Close()
+				record := make([]byte, recordSize)
+
+				b.SetBytes(int64(recordSize))
+				b.ResetTimer()
+				start := processWrittenBytes(b)
+				for i := 0; i < b.N; i++ {
+					if _, err := pio.Write(record); err != nil {
+						b.Fatal(err)
+					}
+				}
+				written := processWrittenBytes(b) - start
+				b.StopTimer()
+				b.ReportMetric(float64(written) / float64(b.N * recordSize), "write-amplification")
+			})
+		}
+	}
+}
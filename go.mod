@@ -1,23 +1,22 @@
 module bitcask-go
 
-go 1.19
+// go 1.22 is required by go.etcd.io/bbolt v1.3.11, the code itself only uses the language of go 1.19
+go 1.22
 
 require github.com/google/btree v1.1.3
 
 require (
 	github.com/tidwall/btree v1.1.0 // indirect
 	github.com/tidwall/match v1.1.1 // indirect
-	golang.org/x/sys v0.22.0 // indirect
+	golang.org/x/sys v0.22.0
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/gofrs/flock v0.12.1
-	github.com/plar/go-adaptive-radix-tree v1.0.5
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/stretchr/testify v1.9.0 // indirect
+	github.com/stretchr/testify v1.9.0
 	github.com/tidwall/redcon v1.6.2
 	go.etcd.io/bbolt v1.3.11
-	golang.org/x/exp v0.0.0-20241009180824-f66d83c29e7c
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -3,154 +3,665 @@ package index
 import (
 	"bitcask-go/data"
 	"bytes"
-	"sort"
 	"sync"
-
-	goart "github.com/plar/go-adaptive-radix-tree"
 )
 
 // Adaptive radix tree
-// https://github.com/plar/go-adaptive-radix-tree
+// https://db.in.tum.de/~leis/papers/ART.pdf
+// Inner nodes grow from 4 to 16, 48 and 256 children, and shrink back when children are removed.
+// Nodes are copy-on-write, so that an iterator can walk a snapshot of the tree without holding the lock.
 
 type AdaptiveRadixTree struct {
-	tree goart.Tree
+	tree *artTree
 	lock *sync.RWMutex
 }
 
 // Initialize an AdaptiveRadixTree
 func NewART() *AdaptiveRadixTree {
 	return &AdaptiveRadixTree{
-		tree: goart.New(),
+		tree: newARTTree(),
 		lock: new(sync.RWMutex),
 	}
 }
 
 func (art *AdaptiveRadixTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
 	art.lock.Lock()
-	oldValue, _ := art.tree.Insert(key, pos)
+	oldLeaf := art.tree.insert(key, pos)
 	art.lock.Unlock()
-	if oldValue == nil {
-		return nil 
+	if oldLeaf == nil {
+		return nil
 	}
-	return oldValue.(*data.LogRecordPos)
+	return &oldLeaf.pos
 }
 
 func (art *AdaptiveRadixTree) Get(key []byte) *data.LogRecordPos {
-	art.lock.Lock()
-	defer art.lock.Unlock()
+	art.lock.RLock()
+	defer art.lock.RUnlock()
 
-	value, found := art.tree.Search(key)
-	if !found {
+	leaf := art.tree.search(key)
+	if leaf == nil {
 		return nil
 	}
-	// value is an interface. Must be transferred to LogRecordPos
-	return value.(*data.LogRecordPos)
+	return &leaf.pos
 }
 
 func (art *AdaptiveRadixTree) Delete(key []byte) (*data.LogRecordPos, bool) {
 	art.lock.Lock()
-	oldValue, deleted := art.tree.Delete(key)
+	oldLeaf := art.tree.delete(key)
 	art.lock.Unlock()
-	if oldValue == nil {
+	if oldLeaf == nil {
 		return nil, false
 	}
-	return oldValue.(*data.LogRecordPos), deleted
+	return &oldLeaf.pos, true
 }
 
-func (art *AdaptiveRadixTree) Size() int {
+func (art *AdaptiveRadixTree) ApplyBatch(ops []BatchOp) []*data.LogRecordPos {
+	oldPositions := make([]*data.LogRecordPos, len(ops))
+	// Hold the lock once for the whole batch
 	art.lock.Lock()
-	size := art.tree.Size()
-	art.lock.Unlock()
+	defer art.lock.Unlock()
+	for i, op := range ops {
+		var oldLeaf *artLeaf
+		if op.Pos == nil {
+			oldLeaf = art.tree.delete(op.Key)
+		} else {
+			oldLeaf = art.tree.insert(op.Key, op.Pos)
+		}
+		if oldLeaf != nil {
+			oldPositions[i] = &oldLeaf.pos
+		}
+	}
+	return oldPositions
+}
+
+func (art *AdaptiveRadixTree) DeleteRange(start, end []byte) []*data.LogRecordPos {
+	art.lock.Lock()
+	defer art.lock.Unlock()
+
+	// The iterator walks a snapshot, so the tree can be modified while iterating
+	var positions []*data.LogRecordPos
+	iter := newARTIterator(art.tree.snapshot(), false)
+	for iter.Seek(start); iter.Valid() && beforeEnd(iter.Key(), end); iter.Next() {
+		if oldLeaf := art.tree.delete(iter.Key()); oldLeaf != nil {
+			positions = append(positions, &oldLeaf.pos)
+		}
+	}
+	return positions
+}
+
+func (art *AdaptiveRadixTree) Size() int {
+	art.lock.RLock()
+	size := art.tree.size
+	art.lock.RUnlock()
 	return size
 }
 
+func (art *AdaptiveRadixTree) MemoryUsage() int64 {
+	art.lock.RLock()
+	defer art.lock.RUnlock()
+	return art.tree.memoryUsage
+}
+
 func (art *AdaptiveRadixTree) Iterator(reverse bool) Iterator {
 	art.lock.Lock()
 	defer art.lock.Unlock()
-	return newARTIterator(art.tree, reverse)
+	return newARTIterator(art.tree.snapshot(), reverse)
 }
 
 func (art *AdaptiveRadixTree) Close() error {
 	return nil
 }
 
-// ART iterator
-type artIterator struct {
-	// Iterator creates an array to temporarily save keys
-	// currIndex is the place of the iterator in the array
-	crrIndex int
+// Kinds of inner nodes, named after the maximum number of children
+const (
+	artNode4 uint8 = iota
+	artNode16
+	artNode48
+	artNode256
+)
 
-	// Reverse iterate?
-	reverse bool
+// Leaf is never modified after creation, updating a key creates a new leaf
+// So the position is stored in the leaf, and pointers to it are given to the callers
+type artLeaf struct {
+	key []byte
+	pos data.LogRecordPos
+}
+
+// Approximate memory used by a key besides the key itself
+// The leaf, and about one inner node for each key
+const artEntryOverhead = 192
+
+// Nodes with the same cow can be modified in place by the tree owning it
+// Otherwise they are shared with a snapshot, and must be copied first
+// It must not be zero-sized, or every new(artCow) may return the same address
+type artCow struct{ _ byte }
+
+type artNode struct {
+	kind uint8
+	cow *artCow
+
+	// Compressed path, the bytes shared by all the keys below, after the edge byte from parent
+	prefix []byte
+
+	// The key which ends exactly at this node
+	leaf *artLeaf
+
+	// node4 and node16: edges are sorted edge bytes, children[i] is the child of edges[i]
+	// node48: edges has 256 slots, edges[b] is the index of the child in children plus 1, 0 means no child
+	// node256: children[b] is the child of edge byte b
+	edges []byte
+	children []*artNode
+	numChildren int
+}
 
-	// Items saved in ART
-	values []*Item
+type artTree struct {
+	root *artNode
+	cow *artCow
+	size int
+	memoryUsage int64
 }
 
-func newARTIterator(tree goart.Tree, reverse bool) *artIterator {
-	var idx int
+func newARTTree() *artTree {
+	return &artTree{cow: new(artCow)}
+}
+
+// Return the current root as a read-only snapshot
+// All the nodes existing now belong to the old cow, so the tree copies them before any change
+func (t *artTree) snapshot() *artNode {
+	t.cow = new(artCow)
+	return t.root
+}
+
+func (t *artTree) newNode(kind uint8, prefix []byte) *artNode {
+	n := &artNode{kind: kind, cow: t.cow, prefix: prefix}
+	switch kind {
+	case artNode4:
+		n.edges = make([]byte, 0, 4)
+		n.children = make([]*artNode, 0, 4)
+	case artNode16:
+		n.edges = make([]byte, 0, 16)
+		n.children = make([]*artNode, 0, 16)
+	case artNode48:
+		n.edges = make([]byte, 256)
+		n.children = make([]*artNode, 48)
+	case artNode256:
+		n.children = make([]*artNode, 256)
+	}
+	return n
+}
+
+// Get a node which can be modified by the tree
+func (t *artTree) writable(n *artNode) *artNode {
+	if n.cow == t.cow {
+		return n
+	}
+	cp := &artNode{
+		kind: n.kind,
+		cow: t.cow,
+		prefix: n.prefix,
+		leaf: n.leaf,
+		numChildren: n.numChildren,
+	}
+	if n.edges != nil {
+		cp.edges = make([]byte, len(n.edges), cap(n.edges))
+		copy(cp.edges, n.edges)
+	}
+	cp.children = make([]*artNode, len(n.children), cap(n.children))
+	copy(cp.children, n.children)
+	return cp
+}
+
+func (t *artTree) search(key []byte) *artLeaf {
+	n, depth := t.root, 0
+	for n != nil {
+		if !bytes.HasPrefix(key[depth:], n.prefix) {
+			return nil
+		}
+		depth += len(n.prefix)
+		if depth == len(key) {
+			return n.leaf
+		}
+		n = n.findChild(key[depth])
+		depth++
+	}
+	return nil
+}
+
+// Insert or replace the key, return the old leaf
+func (t *artTree) insert(key []byte, pos *data.LogRecordPos) *artLeaf {
+	leaf := &artLeaf{key: key, pos: *pos}
+	var oldLeaf *artLeaf
+	t.root, oldLeaf = t.insertNode(t.root, leaf, 0)
+	if oldLeaf == nil {
+		t.size++
+		t.memoryUsage += int64(len(key)) + artEntryOverhead
+	}
+	return oldLeaf
+}
+
+// Insert leaf into the subtree of n, depth is the number of key bytes before n.prefix
+// Return the new root of the subtree, which may be a copy of n
+func (t *artTree) insertNode(n *artNode, leaf *artLeaf, depth int) (*artNode, *artLeaf) {
+	key := leaf.key
+	if n == nil {
+		// Lazy expansion: the rest of the key becomes the prefix of a node without children
+		nn := t.newNode(artNode4, key[depth:])
+		nn.leaf = leaf
+		return nn, nil
+	}
+
+	// The key leaves the compressed path, split it
+	matched := commonPrefixLen(n.prefix, key[depth:])
+	if matched < len(n.prefix) {
+		prefix := n.prefix
+		parent := t.newNode(artNode4, prefix[:matched])
+		child := t.writable(n)
+		child.prefix = prefix[matched + 1:]
+		parent.addChild(t, prefix[matched], child)
+		if depth + matched == len(key) {
+			parent.leaf = leaf
+		} else {
+			newChild, _ := t.insertNode(nil, leaf, depth + matched + 1)
+			parent = parent.addChild(t, key[depth + matched], newChild)
+		}
+		return parent, nil
+	}
+
+	depth += len(n.prefix)
+	n = t.writable(n)
+	if depth == len(key) {
+		oldLeaf := n.leaf
+		n.leaf = leaf
+		return n, oldLeaf
+	}
 
-	if reverse {
-		// Store data in reverse
-		idx = tree.Size() - 1
+	c := key[depth]
+	child := n.findChild(c)
+	newChild, oldLeaf := t.insertNode(child, leaf, depth + 1)
+	if child == nil {
+		n = n.addChild(t, c, newChild)
+	} else {
+		n.replaceChild(c, newChild)
 	}
+	return n, oldLeaf
+}
 
-	values := make([]*Item, tree.Size())
-	saveValues := func(node goart.Node) bool {
-		item := &Item{
-			key: node.Key(),
-			pos: node.Value().(*data.LogRecordPos),
+// Delete the key, return the old leaf
+func (t *artTree) delete(key []byte) *artLeaf {
+	var oldLeaf *artLeaf
+	t.root, oldLeaf = t.deleteNode(t.root, key, 0)
+	if oldLeaf != nil {
+		t.size--
+		t.memoryUsage -= int64(len(oldLeaf.key)) + artEntryOverhead
+	}
+	return oldLeaf
+}
+
+func (t *artTree) deleteNode(n *artNode, key []byte, depth int) (*artNode, *artLeaf) {
+	if n == nil || !bytes.HasPrefix(key[depth:], n.prefix) {
+		return n, nil
+	}
+	depth += len(n.prefix)
+
+	var oldLeaf *artLeaf
+	if depth == len(key) {
+		if n.leaf == nil {
+			return n, nil
+		}
+		oldLeaf = n.leaf
+		n = t.writable(n)
+		n.leaf = nil
+	} else {
+		c := key[depth]
+		child := n.findChild(c)
+		var newChild *artNode
+		newChild, oldLeaf = t.deleteNode(child, key, depth + 1)
+		if oldLeaf == nil {
+			return n, nil
 		}
-		values[idx] = item
-		if reverse {
-			idx--
+		n = t.writable(n)
+		if newChild == nil {
+			n = n.removeChild(t, c)
 		} else {
-			idx++
+			n.replaceChild(c, newChild)
+		}
+	}
+
+	// Keep the tree compact
+	if n.leaf == nil {
+		switch n.numChildren {
+		case 0:
+			return nil, oldLeaf
+		case 1:
+			// Merge the only child into this node
+			c, child := n.nextChild(-1)
+			merged := t.writable(child)
+			prefix := make([]byte, 0, len(n.prefix) + 1 + len(child.prefix))
+			prefix = append(prefix, n.prefix...)
+			prefix = append(prefix, c)
+			merged.prefix = append(prefix, child.prefix...)
+			return merged, oldLeaf
+		}
+	}
+	return n, oldLeaf
+}
+
+func (n *artNode) findChild(c byte) *artNode {
+	switch n.kind {
+	case artNode4, artNode16:
+		for i, e := range n.edges {
+			if e == c {
+				return n.children[i]
+			}
+		}
+	case artNode48:
+		if idx := n.edges[c]; idx > 0 {
+			return n.children[idx - 1]
+		}
+	case artNode256:
+		return n.children[c]
+	}
+	return nil
+}
+
+func (n *artNode) replaceChild(c byte, child *artNode) {
+	switch n.kind {
+	case artNode4, artNode16:
+		for i, e := range n.edges {
+			if e == c {
+				n.children[i] = child
+				return
+			}
+		}
+	case artNode48:
+		n.children[n.edges[c] - 1] = child
+	case artNode256:
+		n.children[c] = child
+	}
+}
+
+// Add a child, n must be writable
+// Return n itself, or a bigger node if n is full
+func (n *artNode) addChild(t *artTree, c byte, child *artNode) *artNode {
+	switch n.kind {
+	case artNode4, artNode16:
+		if len(n.edges) == cap(n.edges) {
+			kind := artNode16
+			if n.kind == artNode16 {
+				kind = artNode48
+			}
+			return n.grow(t, kind).addChild(t, c, child)
+		}
+		// Keep edges sorted
+		i := 0
+		for i < len(n.edges) && n.edges[i] < c {
+			i++
+		}
+		n.edges = append(n.edges, 0)
+		copy(n.edges[i + 1:], n.edges[i:])
+		n.edges[i] = c
+		n.children = append(n.children, nil)
+		copy(n.children[i + 1:], n.children[i:])
+		n.children[i] = child
+	case artNode48:
+		if n.numChildren == 48 {
+			return n.grow(t, artNode256).addChild(t, c, child)
+		}
+		// Find a free slot
+		i := 0
+		for n.children[i] != nil {
+			i++
+		}
+		n.children[i] = child
+		n.edges[c] = byte(i + 1)
+	case artNode256:
+		n.children[c] = child
+	}
+	n.numChildren++
+	return n
+}
+
+// Remove a child, n must be writable
+// Return n itself, or a smaller node if there are few children left
+func (n *artNode) removeChild(t *artTree, c byte) *artNode {
+	switch n.kind {
+	case artNode4, artNode16:
+		for i, e := range n.edges {
+			if e == c {
+				n.edges = append(n.edges[:i], n.edges[i + 1:]...)
+				copy(n.children[i:], n.children[i + 1:])
+				n.children[len(n.children) - 1] = nil
+				n.children = n.children[:len(n.children) - 1]
+				break
+			}
+		}
+	case artNode48:
+		n.children[n.edges[c] - 1] = nil
+		n.edges[c] = 0
+	case artNode256:
+		n.children[c] = nil
+	}
+	n.numChildren--
+
+	switch {
+	case n.kind == artNode16 && n.numChildren <= 3:
+		return n.grow(t, artNode4)
+	case n.kind == artNode48 && n.numChildren <= 12:
+		return n.grow(t, artNode16)
+	case n.kind == artNode256 && n.numChildren <= 37:
+		return n.grow(t, artNode48)
+	}
+	return n
+}
+
+// Move all the children of n to a new node of the kind
+func (n *artNode) grow(t *artTree, kind uint8) *artNode {
+	nn := t.newNode(kind, n.prefix)
+	nn.leaf = n.leaf
+	for c, child := n.nextChild(-1); child != nil; c, child = n.nextChild(int(c)) {
+		nn.addChild(t, c, child)
+	}
+	return nn
+}
+
+// The child with the smallest edge byte bigger than c
+// c is -1 to get the first child
+func (n *artNode) nextChild(c int) (byte, *artNode) {
+	switch n.kind {
+	case artNode4, artNode16:
+		for i, e := range n.edges {
+			if int(e) > c {
+				return e, n.children[i]
+			}
+		}
+	case artNode48:
+		for b := c + 1; b < 256; b++ {
+			if idx := n.edges[b]; idx > 0 {
+				return byte(b), n.children[idx - 1]
+			}
+		}
+	case artNode256:
+		for b := c + 1; b < 256; b++ {
+			if n.children[b] != nil {
+				return byte(b), n.children[b]
+			}
 		}
-		return true
 	}
+	return 0, nil
+}
+
+// The child with the biggest edge byte smaller than c
+// c is 256 to get the last child
+func (n *artNode) prevChild(c int) (byte, *artNode) {
+	switch n.kind {
+	case artNode4, artNode16:
+		for i := len(n.edges) - 1; i >= 0; i-- {
+			if int(n.edges[i]) < c {
+				return n.edges[i], n.children[i]
+			}
+		}
+	case artNode48:
+		for b := c - 1; b >= 0; b-- {
+			if idx := n.edges[b]; idx > 0 {
+				return byte(b), n.children[idx - 1]
+			}
+		}
+	case artNode256:
+		for b := c - 1; b >= 0; b-- {
+			if n.children[b] != nil {
+				return byte(b), n.children[b]
+			}
+		}
+	}
+	return 0, nil
+}
+
+func commonPrefixLen(a, b []byte) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// A node on the path from root to the current leaf of the iterator
+type artFrame struct {
+	node *artNode
+	// Edge byte of the child being visited
+	// Forward: -1 before the first child. Reverse: 256 before the last child
+	edge int
+	// Forward only: the leaf of this node has been visited?
+	leafDone bool
+}
+
+// ART iterator
+// Walk a snapshot of the tree lazily, only the path to the current key is in memory
+type artIterator struct {
+	root *artNode
+
+	// Reverse iterate?
+	reverse bool
 
-	tree.ForEach(saveValues)
-	
-	return &artIterator{
-		crrIndex: 0,
+	stack []artFrame
+	leaf *artLeaf
+}
+
+func newARTIterator(root *artNode, reverse bool) *artIterator {
+	ai := &artIterator{
+		root: root,
 		reverse: reverse,
-		values: values,
+	}
+	ai.Rewind()
+	return ai
+}
+
+// A frame which visits the whole subtree of n
+func (ai *artIterator) fullFrame(n *artNode) artFrame {
+	if ai.reverse {
+		return artFrame{node: n, edge: 256}
+	}
+	return artFrame{node: n, edge: -1}
+}
+
+// Move to the next leaf according to the stack
+func (ai *artIterator) advance() {
+	ai.leaf = nil
+	for len(ai.stack) > 0 {
+		f := &ai.stack[len(ai.stack) - 1]
+		if ai.reverse {
+			// Children are bigger than the leaf of the node, visit them first
+			if c, child := f.node.prevChild(f.edge); child != nil {
+				f.edge = int(c)
+				ai.stack = append(ai.stack, ai.fullFrame(child))
+				continue
+			}
+			ai.stack = ai.stack[:len(ai.stack) - 1]
+			if f.node.leaf != nil {
+				ai.leaf = f.node.leaf
+				return
+			}
+		} else {
+			if !f.leafDone {
+				f.leafDone = true
+				if f.node.leaf != nil {
+					ai.leaf = f.node.leaf
+					return
+				}
+			}
+			if c, child := f.node.nextChild(f.edge); child != nil {
+				f.edge = int(c)
+				ai.stack = append(ai.stack, ai.fullFrame(child))
+				continue
+			}
+			ai.stack = ai.stack[:len(ai.stack) - 1]
+		}
 	}
 }
 
 func(ai *artIterator) Rewind() {
-	ai.crrIndex = 0
+	ai.stack = ai.stack[:0]
+	if ai.root != nil {
+		ai.stack = append(ai.stack, ai.fullFrame(ai.root))
+	}
+	ai.advance()
 }
 
+// Find the first key bigger than or equal to key (smaller than or equal to key in reverse mode)
 func(ai *artIterator) Seek(key []byte) {
-	if ai.reverse {
-		ai.crrIndex = sort.Search(len(ai.values), func(i int) bool {
-			return bytes.Compare(ai.values[i].key, key) <= 0
-		})
-	} else {
-		ai.crrIndex = sort.Search(len(ai.values), func(i int) bool {
-			return bytes.Compare(ai.values[i].key, key) >= 0
-		})
+	ai.stack = ai.stack[:0]
+	n, depth := ai.root, 0
+	for n != nil {
+		rest := key[depth:]
+		matched := commonPrefixLen(n.prefix, rest)
+		if matched < len(n.prefix) {
+			// The key leaves the compressed path
+			// The whole subtree is either bigger or smaller than key
+			bigger := matched == len(rest) || n.prefix[matched] > rest[matched]
+			if bigger != ai.reverse {
+				ai.stack = append(ai.stack, ai.fullFrame(n))
+			}
+			break
+		}
+
+		depth += len(n.prefix)
+		if depth == len(key) {
+			if ai.reverse {
+				// Only the leaf of this node is not bigger than key
+				ai.stack = append(ai.stack, artFrame{node: n, edge: 0})
+			} else {
+				ai.stack = append(ai.stack, ai.fullFrame(n))
+			}
+			break
+		}
+
+		// Continue after the child on the path of key
+		// The leaf of this node is smaller than key
+		c := key[depth]
+		ai.stack = append(ai.stack, artFrame{node: n, edge: int(c), leafDone: true})
+		n = n.findChild(c)
+		depth++
 	}
+	ai.advance()
 }
 
 func(ai *artIterator) Next() {
-	ai.crrIndex += 1
+	ai.advance()
 }
 
 func(ai *artIterator) Valid() bool {
-	return ai.crrIndex < len(ai.values)
+	return ai.leaf != nil
 }
 
 func(ai *artIterator) Key() []byte {
-	return ai.values[ai.crrIndex].key
+	return ai.leaf.key
 }
 
 func(ai *artIterator) Value() *data.LogRecordPos {
-	return ai.values[ai.crrIndex].pos
+	return &ai.leaf.pos
 }
 
 func(ai *artIterator) Close() {
-	ai.values = nil
+	ai.root = nil
+	ai.stack = nil
+	ai.leaf = nil
 }
@@ -2,6 +2,10 @@ package index
 
 import (
 	"bitcask-go/data"
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -93,4 +97,285 @@ func TestAdaptiveRadixTree_Iterator(t *testing.T) {
 		assert.NotNil(t, iter2.Key())
 		assert.NotNil(t, iter2.Value())
 	}
-}
\ No newline at end of file
+}
+
+func TestAdaptiveRadixTree_DeleteRange(t *testing.T) {
+	art := NewART()
+	art.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	art.Put([]byte("ab"), &data.LogRecordPos{Fid: 1, Offset: 20})
+	art.Put([]byte("abc"), &data.LogRecordPos{Fid: 1, Offset: 30})
+	art.Put([]byte("b"), &data.LogRecordPos{Fid: 1, Offset: 40})
+	art.Put([]byte("c"), &data.LogRecordPos{Fid: 1, Offset: 50})
+
+	res1 := art.DeleteRange([]byte("x"), []byte("z"))
+	assert.Equal(t, 0, len(res1))
+
+	res2 := art.DeleteRange([]byte("ab"), []byte("b"))
+	assert.Equal(t, 2, len(res2))
+	assert.Equal(t, int64(20), res2[0].Offset)
+	assert.Equal(t, int64(30), res2[1].Offset)
+	assert.Nil(t, art.Get([]byte("abc")))
+	assert.NotNil(t, art.Get([]byte("b")))
+	assert.Equal(t, 3, art.Size())
+
+	res3 := art.DeleteRange([]byte("b"), nil)
+	assert.Equal(t, 2, len(res3))
+	assert.Equal(t, 1, art.Size())
+}
+
+
+func TestAdaptiveRadixTree_Random(t *testing.T) {
+	art := NewART()
+	ref := make(map[string]int64)
+	r := rand.New(rand.NewSource(1))
+
+	// Short keys from a small alphabet, so that there are many shared prefixes
+	// and keys which are prefixes of other keys
+	randKey := func() []byte {
+		key := make([]byte, r.Intn(6))
+		for i := range key {
+			key[i] = "abc\x00\xff"[r.Intn(5)]
+		}
+		return key
+	}
+	for i := 0; i < 20000; i++ {
+		key := randKey()
+		if r.Intn(3) == 0 {
+			pos, ok := art.Delete(key)
+			offset, exist := ref[string(key)]
+			assert.Equal(t, exist, ok)
+			if exist {
+				assert.Equal(t, offset, pos.Offset)
+			}
+			delete(ref, string(key))
+		} else {
+			art.Put(key, &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+			ref[string(key)] = int64(i)
+		}
+	}
+	// Many children under one node, so that the node grows to node256
+	for i := 0; i < 256; i++ {
+		key := []byte{'b', byte(i)}
+		art.Put(key, &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+		ref[string(key)] = int64(i)
+	}
+	assert.Equal(t, len(ref), art.Size())
+
+	var keys []string
+	for k, offset := range ref {
+		keys = append(keys, k)
+		assert.Equal(t, offset, art.Get([]byte(k)).Offset)
+	}
+	sort.Strings(keys)
+
+	iter1 := art.Iterator(false)
+	var forward []string
+	for iter1.Rewind(); iter1.Valid(); iter1.Next() {
+		forward = append(forward, string(iter1.Key()))
+	}
+	assert.Equal(t, keys, forward)
+
+	iter2 := art.Iterator(true)
+	var reverse []string
+	for iter2.Rewind(); iter2.Valid(); iter2.Next() {
+		reverse = append(reverse, string(iter2.Key()))
+	}
+	assert.Equal(t, len(keys), len(reverse))
+	for i := range reverse {
+		assert.Equal(t, keys[len(keys) - 1 - i], reverse[i])
+	}
+
+	// Seek to random keys in both directions
+	for i := 0; i < 1000; i++ {
+		target := randKey()
+		idx := sort.SearchStrings(keys, string(target))
+		iter1.Seek(target)
+		if idx < len(keys) {
+			assert.Equal(t, keys[idx], string(iter1.Key()), fmt.Sprintf("seek %q", target))
+		} else {
+			assert.False(t, iter1.Valid())
+		}
+
+		iter2.Seek(target)
+		if idx < len(keys) && keys[idx] == string(target) {
+			assert.Equal(t, target, iter2.Key())
+		} else if idx > 0 {
+			assert.Equal(t, keys[idx - 1], string(iter2.Key()), fmt.Sprintf("reverse seek %q", target))
+		} else {
+			assert.False(t, iter2.Valid())
+		}
+	}
+
+	// Shrink the nodes again
+	for _, k := range keys {
+		_, ok := art.Delete([]byte(k))
+		assert.True(t, ok)
+	}
+	assert.Equal(t, 0, art.Size())
+	assert.False(t, art.Iterator(false).Valid())
+}
+
+func TestAdaptiveRadixTree_Iterator_Snapshot(t *testing.T) {
+	art := NewART()
+	for i := 0; i < 100; i++ {
+		art.Put([]byte(fmt.Sprintf("key-%03d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	iter := art.Iterator(false)
+	defer iter.Close()
+
+	// Changes after the iterator is created are not visible to it
+	for i := 0; i < 100; i += 2 {
+		art.Delete([]byte(fmt.Sprintf("key-%03d", i)))
+	}
+	art.Put([]byte("key-050"), &data.LogRecordPos{Fid: 2, Offset: 0})
+	art.Put([]byte("key-new"), &data.LogRecordPos{Fid: 2, Offset: 0})
+
+	var n int
+	var prev []byte
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, uint32(1), iter.Value().Fid)
+		assert.True(t, bytes.Compare(prev, iter.Key()) < 0)
+		prev = iter.Key()
+		n++
+	}
+	assert.Equal(t, 100, n)
+	assert.Equal(t, 52, art.Size())
+	assert.Equal(t, uint32(2), art.Get([]byte("key-050")).Fid)
+}
+
+// Same random operations on the ART and a btree, the results must be the same
+func TestAdaptiveRadixTree_Differential(t *testing.T) {
+	for seed := int64(0); seed < 20; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		ops := make([]byte, 20000)
+		r.Read(ops)
+		testARTAgainstBTree(t, ops)
+	}
+}
+
+func FuzzAdaptiveRadixTree(f *testing.F) {
+	f.Add([]byte{0, 2, 1, 'a', 0, 2, 1, 'b', 5, 0, 7, 1})
+	f.Add([]byte{6, 3, 200, 6, 2, 100, 4, 3, 0, 8, 7, 2})
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		testARTAgainstBTree(t, ops)
+	})
+}
+
+// Run the operations read from ops on both indexes
+// Keys share long prefixes, and a place after them has any of the 256 bytes,
+// so the compressed paths are split and merged, and the nodes grow and shrink through all the sizes
+func testARTAgainstBTree(t *testing.T, ops []byte) {
+	art, bt := NewART(), NewBTree()
+	next := func() byte {
+		if len(ops) == 0 {
+			return 0
+		}
+		b := ops[0]
+		ops = ops[1:]
+		return b
+	}
+	prefixes := [][]byte{nil, []byte("a"), []byte("key-"), []byte("key-long-shared-prefix-"), {0}, {0xff, 0xff}}
+	randKey := func() []byte {
+		key := append([]byte{}, prefixes[int(next()) % len(prefixes)]...)
+		for n := next() % 3; n > 0; n-- {
+			key = append(key, next())
+		}
+		return key
+	}
+	sameIter := func(artIter, btIter Iterator, steps int) {
+		for i := 0; i < steps && btIter.Valid(); i++ {
+			assert.True(t, artIter.Valid())
+			if !artIter.Valid() {
+				return
+			}
+			assert.Equal(t, btIter.Key(), artIter.Key())
+			assert.Equal(t, btIter.Value(), artIter.Value())
+			artIter.Next()
+			btIter.Next()
+		}
+		if steps < 0 || !btIter.Valid() {
+			assert.Equal(t, btIter.Valid(), artIter.Valid())
+		}
+	}
+
+	// Iterators of both indexes created at the same time, they must not see the changes after it
+	type snapshot struct{ art, bt Iterator }
+	var snapshots []snapshot
+	checkSnapshots := func() {
+		for _, s := range snapshots {
+			s.art.Rewind()
+			s.bt.Rewind()
+			sameIter(s.art, s.bt, -1)
+			s.art.Close()
+			s.bt.Close()
+		}
+		snapshots = nil
+	}
+
+	for step := 0; len(ops) > 0; step++ {
+		pos := &data.LogRecordPos{Fid: 1, Offset: int64(step)}
+		switch next() % 9 {
+		case 0, 1:
+			key := randKey()
+			assert.Equal(t, bt.Put(key, pos), art.Put(key, pos))
+		case 2:
+			key := randKey()
+			btPos, btOk := bt.Delete(key)
+			artPos, artOk := art.Delete(key)
+			assert.Equal(t, btOk, artOk)
+			assert.Equal(t, btPos, artPos)
+		case 3:
+			key := randKey()
+			assert.Equal(t, bt.Get(key), art.Get(key))
+		case 4:
+			start, end := randKey(), randKey()
+			if bytes.Compare(start, end) > 0 {
+				start, end = end, start
+			}
+			btPositions, artPositions := bt.DeleteRange(start, end), art.DeleteRange(start, end)
+			assert.Equal(t, len(btPositions), len(artPositions))
+			if len(btPositions) > 0 {
+				assert.Equal(t, btPositions, artPositions)
+			}
+		case 5:
+			batch := make([]BatchOp, next() % 8)
+			for i := range batch {
+				batch[i].Key = randKey()
+				if next() % 3 != 0 {
+					batch[i].Pos = &data.LogRecordPos{Fid: 2, Offset: int64(step)}
+				}
+			}
+			assert.Equal(t, bt.ApplyBatch(batch), art.ApplyBatch(batch))
+		case 6:
+			// Children with every byte under one node
+			key := randKey()
+			for n := int(next()); n >= 0; n-- {
+				child := append(append([]byte{}, key...), byte(n))
+				assert.Equal(t, bt.Put(child, pos), art.Put(child, pos))
+			}
+		case 7:
+			reverse := next() % 2 == 0
+			artIter, btIter := art.Iterator(reverse), bt.Iterator(reverse)
+			key := randKey()
+			artIter.Seek(key)
+			btIter.Seek(key)
+			sameIter(artIter, btIter, 4)
+			artIter.Close()
+			btIter.Close()
+		case 8:
+			if len(snapshots) >= 4 {
+				checkSnapshots()
+			}
+			reverse := next() % 2 == 0
+			snapshots = append(snapshots, snapshot{art: art.Iterator(reverse), bt: bt.Iterator(reverse)})
+		}
+		assert.Equal(t, bt.Size(), art.Size())
+		if t.Failed() {
+			t.FailNow()
+		}
+	}
+	checkSnapshots()
+	sameIter(art.Iterator(false), bt.Iterator(false), -1)
+	sameIter(art.Iterator(true), bt.Iterator(true), -1)
+}
@@ -0,0 +1,121 @@
+package index
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+)
+
+const (
+	// About 1% false positives with 7 hash functions
+	bloomBitsPerKey = 10
+	bloomHashCount = 7
+	// A new filter is sized for at least this number of keys
+	bloomMinCapacity = 1 << 16
+	// k, capacity and the number of keys added, before the bits
+	bloomHeaderSize = 4 + 8 + 8
+)
+
+// Bloom filter
+// Answers a key is not in the set, or may be in it. A key added is never missed
+// Keys cannot be removed, deleted keys only make false positives more likely
+// When more keys than the capacity are added, the owner builds a bigger filter from the keys left
+type bloomFilter struct {
+	bits []uint64
+	k uint32
+	capacity int64
+	added atomic.Int64
+}
+
+func newBloomFilter(capacity int64) *bloomFilter {
+	if capacity < bloomMinCapacity {
+		capacity = bloomMinCapacity
+	}
+	return &bloomFilter{
+		bits: make([]uint64, (capacity * bloomBitsPerKey + 63) / 64),
+		k: bloomHashCount,
+		capacity: capacity,
+	}
+}
+
+// FNV-1a, and a second hash mixed from it
+// The hashes are saved with the filter, so they must not change between runs
+func bloomHash(key []byte) (uint64, uint64) {
+	h := uint64(14695981039346656037)
+	for _, b := range key {
+		h ^= uint64(b)
+		h *= 1099511628211
+	}
+	// Finalizer of splitmix64
+	h2 := h ^ (h >> 30)
+	h2 *= 0xbf58476d1ce4e5b9
+	h2 ^= h2 >> 27
+	h2 *= 0x94d049bb133111eb
+	h2 ^= h2 >> 31
+	return h, h2 | 1
+}
+
+// Bits are set atomically, readers check the filter while keys are added
+func (bf *bloomFilter) add(key []byte) {
+	h1, h2 := bloomHash(key)
+	m := uint64(len(bf.bits)) * 64
+	for i := uint64(0); i < uint64(bf.k); i++ {
+		bit := (h1 + i * h2) % m
+		word, mask := &bf.bits[bit / 64], uint64(1) << (bit % 64)
+		for {
+			old := atomic.LoadUint64(word)
+			if old & mask != 0 || atomic.CompareAndSwapUint64(word, old, old | mask) {
+				break
+			}
+		}
+	}
+	bf.added.Add(1)
+}
+
+func (bf *bloomFilter) mayContain(key []byte) bool {
+	h1, h2 := bloomHash(key)
+	m := uint64(len(bf.bits)) * 64
+	for i := uint64(0); i < uint64(bf.k); i++ {
+		bit := (h1 + i * h2) % m
+		if atomic.LoadUint64(&bf.bits[bit / 64]) & (uint64(1) << (bit % 64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// More keys than the capacity are added, false positives are more than expected
+func (bf *bloomFilter) full() bool {
+	return bf.added.Load() > bf.capacity
+}
+
+// Must not be called while keys are added
+func (bf *bloomFilter) encode() []byte {
+	buf := make([]byte, bloomHeaderSize + len(bf.bits) * 8)
+	binary.LittleEndian.PutUint32(buf[0:], bf.k)
+	binary.LittleEndian.PutUint64(buf[4:], uint64(bf.capacity))
+	binary.LittleEndian.PutUint64(buf[12:], uint64(bf.added.Load()))
+	for i, word := range bf.bits {
+		binary.LittleEndian.PutUint64(buf[bloomHeaderSize + i * 8:], word)
+	}
+	return buf
+}
+
+// Return nil if buf is not a filter, it is rebuilt then
+func decodeBloomFilter(buf []byte) *bloomFilter {
+	if len(buf) <= bloomHeaderSize || (len(buf) - bloomHeaderSize) % 8 != 0 {
+		return nil
+	}
+	bf := &bloomFilter{
+		bits: make([]uint64, (len(buf) - bloomHeaderSize) / 8),
+		k: binary.LittleEndian.Uint32(buf[0:]),
+		capacity: int64(binary.LittleEndian.Uint64(buf[4:])),
+	}
+	if bf.k == 0 {
+		return nil
+	}
+	bf.added.Store(int64(binary.LittleEndian.Uint64(buf[12:])))
+	for i := range bf.bits {
+		bf.bits[i] = binary.LittleEndian.Uint64(buf[bloomHeaderSize + i * 8:])
+	}
+	return bf
+}
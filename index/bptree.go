@@ -2,13 +2,21 @@ package index
 
 import (
 	"bitcask-go/data"
+	"bytes"
+	"encoding/binary"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 
 	"go.etcd.io/bbolt"
 )
 
-const bptreeIndexFileName = "bptree-index"
+const BPlusTreeIndexFileName = "bptree-index"
 var indexBucketName = []byte("bitcask-index")
+// Bucket of the information about the index itself
+var metaBucketName = []byte("bitcask-meta")
+var highWaterMarkKey = []byte("high-water-mark")
+var bloomFilterKey = []byte("bloom-filter")
 
 // b+ tree index
 // go.etcd.io/bbolt
@@ -16,36 +24,168 @@ type BPlusTree struct {
 	// bbolt itself is a storage engine
 	// bbolt support Concurrent reading and writing. No need to add lock
 	tree *bbolt.DB
+	markLock *sync.Mutex
+	mark *data.LogRecordPos         // High-water mark not saved yet, it is saved with the next change
+
+	// Bloom filter of the keys, lookups of missing keys are mostly answered without bbolt
+	// Keys are added before their transaction is committed, so a committed key is never missed
+	bloom atomic.Pointer[bloomFilter]
+	bloomNegatives atomic.Int64      // Lookups answered by the filter
+	bloomFalsePositives atomic.Int64 // Lookups the filter let through, but the key is missing
 }
 
 // Initialize BPlusTree
-func NewBPlusTree(dirPath string, syncWrite bool) *BPlusTree {
-	opts := bbolt.DefaultOptions
-	opts.NoSync = !syncWrite
+func NewBPlusTree(dirPath string, syncWrite bool) (*BPlusTree, error) {
 	// B+ tree stores indexes on disk
 	// So it needs a filepath to open
-	bptree, err := bbolt.Open(filepath.Join(dirPath, bptreeIndexFileName), 0644, opts)
+	return openBPlusTree(filepath.Join(dirPath, BPlusTreeIndexFileName), syncWrite)
+}
+
+// Open the B+ tree stored in fileName
+func openBPlusTree(fileName string, syncWrite bool) (*BPlusTree, error) {
+	opts := bbolt.DefaultOptions
+	opts.NoSync = !syncWrite
+	bptree, err := bbolt.Open(fileName, 0644, opts)
 	if err != nil {
-		panic("failed to open bptree")
+		return nil, err
 	}
 
+	bpt := &BPlusTree{tree: bptree, markLock: new(sync.Mutex)}
 	// Because bbolt itself is a database
 	// The method Update supports transaction.  
 	// Create a bucket. This database uses bucket to put in data
 	if err := bptree.Update(func(tx *bbolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(indexBucketName)
-		return err
+		if _, err := tx.CreateBucketIfNotExists(indexBucketName); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(metaBucketName); err != nil {
+			return err
+		}
+		bpt.bloom.Store(loadBloomFilter(tx))
+		return nil
 	}); err != nil {
-		panic("failed to create bucket in bptree")
+		_ = bptree.Close()
+		return nil, err
 	}
-	return &BPlusTree{tree: bptree}
+	return bpt, nil
 }
 
-func (bpt *BPlusTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
-	var oldValue []byte
+// Load the Bloom filter saved when closing, or build it from the keys
+// The filter is saved with the id of the transaction saving it
+// If this is not the next transaction, the index may be changed without the filter, by a crash or an older version
+func loadBloomFilter(tx *bbolt.Tx) *bloomFilter {
+	value := tx.Bucket(metaBucketName).Get(bloomFilterKey)
+	if len(value) > 8 && binary.LittleEndian.Uint64(value) == uint64(tx.ID() - 1) {
+		if bf := decodeBloomFilter(value[8:]); bf != nil {
+			return bf
+		}
+	}
+	return buildBloomFilter(tx.Bucket(indexBucketName))
+}
+
+// Build a Bloom filter of the keys in bucket, with room for as many new keys
+func buildBloomFilter(bucket *bbolt.Bucket) *bloomFilter {
+	// Stats doesn't see the changes of the transaction, count the keys with a cursor
+	var keyNum int64
+	cursor := bucket.Cursor()
+	for k, _ := cursor.First(); k != nil; k, _ = cursor.Next() {
+		keyNum++
+	}
+	bf := newBloomFilter(keyNum * 2)
+	_ = bucket.ForEach(func(k, _ []byte) error {
+		bf.add(k)
+		return nil
+	})
+	return bf
+}
+
+// Observed false-positive rate of the Bloom filter since the index is opened
+// The share of the lookups of missing keys the filter didn't answer
+func (bpt *BPlusTree) BloomFalsePositiveRate() float64 {
+	negatives, falsePositives := bpt.bloomNegatives.Load(), bpt.bloomFalsePositives.Load()
+	if negatives + falsePositives == 0 {
+		return 0
+	}
+	return float64(falsePositives) / float64(negatives + falsePositives)
+}
+
+// Is key possibly in the index? Lookups answered here are counted
+func (bpt *BPlusTree) mayContain(key []byte) bool {
+	if bpt.bloom.Load().mayContain(key) {
+		return true
+	}
+	bpt.bloomNegatives.Add(1)
+	return false
+}
+
+// The end of the data files applied to the index, nil if it is unknown
+// Records after it may be missing in the index, they need to be applied again
+func (bpt *BPlusTree) HighWaterMark() *data.LogRecordPos {
+	bpt.markLock.Lock()
+	mark := bpt.mark
+	bpt.markLock.Unlock()
+	if mark != nil {
+		return mark
+	}
+
+	if err := bpt.tree.View(func(tx *bbolt.Tx) error {
+		if value := tx.Bucket(metaBucketName).Get(highWaterMarkKey); len(value) != 0 {
+			mark = decodePos(value)
+		}
+		return nil
+	}); err != nil {
+		panic("failed to get high-water mark in bptree")
+	}
+	return mark
+}
+
+// Set the high-water mark, it is saved with the next change of the index or when closing
+func (bpt *BPlusTree) SetHighWaterMark(mark *data.LogRecordPos) {
+	bpt.markLock.Lock()
+	bpt.mark = mark
+	bpt.markLock.Unlock()
+}
+
+// Run fn with the index bucket in a writable transaction
+// The high-water mark set is saved in the same transaction, so it never goes beyond the changes saved
+func (bpt *BPlusTree) update(fn func(bucket *bbolt.Bucket) error) error {
+	bpt.markLock.Lock()
+	mark := bpt.mark
+	bpt.markLock.Unlock()
+
 	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(indexBucketName)
-		oldValue = bucket.Get(key)
+		if err := fn(bucket); err != nil {
+			return err
+		}
+		// Writers are serialized by bbolt, no key is added while the bigger filter is built
+		if bpt.bloom.Load().full() {
+			bpt.bloom.Store(buildBloomFilter(bucket))
+		}
+		if mark == nil {
+			return nil
+		}
+		return tx.Bucket(metaBucketName).Put(highWaterMarkKey, data.EncodeLogRecordPos(mark))
+	}); err != nil {
+		return err
+	}
+
+	// Unless a newer one is set, the mark doesn't need to be saved again
+	bpt.markLock.Lock()
+	if bpt.mark == mark {
+		bpt.mark = nil
+	}
+	bpt.markLock.Unlock()
+	return nil
+}
+
+func (bpt *BPlusTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	var oldValue []byte
+	if err := bpt.update(func(bucket *bbolt.Bucket) error {
+		// A key already in the bucket is in the filter, only new keys are counted for its capacity
+		if oldValue = bucket.Get(key); len(oldValue) == 0 {
+			bpt.bloom.Load().add(key)
+		}
 		return bucket.Put(key, data.EncodeLogRecordPos(pos))
 	}); err != nil {
 		panic("failed to put value in bptree")
@@ -53,10 +193,13 @@ func (bpt *BPlusTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos
 	if len(oldValue) == 0 {
 		return nil
 	}
-	return data.DecodeLogRecordPos(oldValue)
+	return decodePos(oldValue)
 }
 
 func (bpt *BPlusTree) Get(key []byte) *data.LogRecordPos {
+	if !bpt.mayContain(key) {
+		return nil
+	}
 	var pos *data.LogRecordPos
 
 	// Method View can only be used to read
@@ -64,19 +207,25 @@ func (bpt *BPlusTree) Get(key []byte) *data.LogRecordPos {
 		bucket := tx.Bucket(indexBucketName)
 		value := bucket.Get(key)
 		if len(value) != 0 {
-			pos = data.DecodeLogRecordPos(value)
+			pos = decodePos(value)
 		}
 		return nil
 	}); err != nil {
 		panic("failed to get value in bptree")
 	}
+	if pos == nil {
+		bpt.bloomFalsePositives.Add(1)
+	}
 	return pos
 }
 
 func (bpt *BPlusTree) Delete(key []byte) (*data.LogRecordPos, bool) {
+	// No write transaction for a missing key
+	if !bpt.mayContain(key) {
+		return nil, false
+	}
 	var oldVal []byte
-	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
-		bucket := tx.Bucket(indexBucketName)
+	if err := bpt.update(func(bucket *bbolt.Bucket) error {
 		if oldVal = bucket.Get(key); len(oldVal) != 0 {
 			return bucket.Delete(key)
 		}
@@ -85,9 +234,74 @@ func (bpt *BPlusTree) Delete(key []byte) (*data.LogRecordPos, bool) {
 		panic("failed to delete value in bptree")
 	}
 	if len(oldVal) == 0 {
+		bpt.bloomFalsePositives.Add(1)
 		return nil, false
 	}
-	return data.DecodeLogRecordPos(oldVal), true
+	return decodePos(oldVal), true
+}
+
+func (bpt *BPlusTree) ApplyBatch(ops []BatchOp) []*data.LogRecordPos {
+	oldPositions := make([]*data.LogRecordPos, len(ops))
+	// All the changes are written in one transaction
+	if err := bpt.update(func(bucket *bbolt.Bucket) error {
+		bloom := bpt.bloom.Load()
+		for i, op := range ops {
+			if op.Pos == nil && !bloom.mayContain(op.Key) {
+				continue
+			}
+			if oldValue := bucket.Get(op.Key); len(oldValue) != 0 {
+				oldPositions[i] = decodePos(oldValue)
+			}
+			var err error
+			if op.Pos == nil {
+				err = bucket.Delete(op.Key)
+			} else {
+				if oldPositions[i] == nil {
+					bloom.add(op.Key)
+				}
+				err = bucket.Put(op.Key, data.EncodeLogRecordPos(op.Pos))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		panic("failed to apply batch in bptree")
+	}
+	return oldPositions
+}
+
+func (bpt *BPlusTree) DeleteRange(start, end []byte) []*data.LogRecordPos {
+	var positions []*data.LogRecordPos
+	// All the keys in the range are deleted in one transaction
+	if err := bpt.update(func(bucket *bbolt.Bucket) error {
+		cursor := bucket.Cursor()
+
+		// Deleting under the cursor may skip the next key
+		// Collect the keys in the range first
+		var keys [][]byte
+		var k, v []byte
+		if len(start) == 0 {
+			k, v = cursor.First()
+		} else {
+			k, v = cursor.Seek(start)
+		}
+		for ; k != nil && beforeEnd(k, end); k, v = cursor.Next() {
+			keys = append(keys, append([]byte(nil), k...))
+			positions = append(positions, decodePos(v))
+		}
+
+		for _, key := range keys {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		panic("failed to delete range in bptree")
+	}
+	return positions
 }
 
 func (bpt *BPlusTree) Size() int {
@@ -102,11 +316,28 @@ func (bpt *BPlusTree) Size() int {
 	return size
 }
 
+// The index is on the disk, bbolt maps the file instead of allocating memory
+func (bpt *BPlusTree) MemoryUsage() int64 {
+	return 0
+}
+
 func (bpt *BPlusTree) Iterator(reverse bool) Iterator {
 	return newBptreeIterator(bpt.tree, reverse)
 }
 
+// Save the Bloom filter for the next open, with the high-water mark set after the last change
 func (bpt *BPlusTree) Close() error {
+	if err := bpt.update(func(bucket *bbolt.Bucket) error {
+		tx := bucket.Tx()
+		bloom := bpt.bloom.Load().encode()
+		buf := make([]byte, 8 + len(bloom))
+		binary.LittleEndian.PutUint64(buf, uint64(tx.ID()))
+		copy(buf[8:], bloom)
+		return tx.Bucket(metaBucketName).Put(bloomFilterKey, buf)
+	}); err != nil {
+		_ = bpt.tree.Close()
+		return err
+	}
 	return bpt.tree.Close()
 }
 
@@ -144,6 +375,16 @@ func (bpi *bptreeIterator) Rewind() {
 
 func (bpi *bptreeIterator) Seek(key []byte) {
 	bpi.curKey, bpi.curVal = bpi.cursor.Seek(key)
+	if !bpi.reverse {
+		return
+	}
+	// Cursor.Seek finds the first key bigger than or equal to key
+	// In reverse mode, it should be the first key smaller than or equal to key
+	if bpi.curKey == nil {
+		bpi.curKey, bpi.curVal = bpi.cursor.Last()
+	} else if bytes.Compare(bpi.curKey, key) > 0 {
+		bpi.curKey, bpi.curVal = bpi.cursor.Prev()
+	}
 }
 
 func (bpi *bptreeIterator) Next() {
@@ -163,11 +404,20 @@ func (bpi *bptreeIterator) Key() []byte {
 }
 
 func (bpi *bptreeIterator) Value() *data.LogRecordPos {
-	return data.DecodeLogRecordPos(bpi.curVal)
+	return decodePos(bpi.curVal)
 }
 
 func (bpi *bptreeIterator) Close() {
 	// Submit the temporary transaction
 	// Read-only transactions must be rolled back and not committed.(Written in the comment of Rollback())
 	_ = bpi.tx.Rollback()
-}
\ No newline at end of file
+}
+
+// Decode the position saved in bptree
+func decodePos(buf []byte) *data.LogRecordPos {
+	pos, err := data.DecodeLogRecordPos(buf)
+	if err != nil {
+		panic("failed to decode position in bptree")
+	}
+	return pos
+}
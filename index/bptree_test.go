@@ -2,11 +2,13 @@ package index
 
 import (
 	"bitcask-go/data"
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"go.etcd.io/bbolt"
 )
 
 func TestBPlusTree_Put(t *testing.T) {
@@ -16,7 +18,8 @@ func TestBPlusTree_Put(t *testing.T) {
 	defer func() {
 		_ = os.RemoveAll(path)
 	}()
-	tree := NewBPlusTree(path, false)
+	tree, err := NewBPlusTree(path, false)
+	assert.Nil(t, err)
 
 	res1 := tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 123, Offset: 999})
 	assert.Nil(t, res1)
@@ -37,7 +40,8 @@ func TestBPlusTree_Get(t *testing.T) {
 	defer func() {
 		_ = os.RemoveAll(path)
 	}()
-	tree := NewBPlusTree(path, false)
+	tree, err := NewBPlusTree(path, false)
+	assert.Nil(t, err)
 
 	pos1 := tree.Get([]byte("not exist"))
 	t.Log(pos1)
@@ -61,7 +65,8 @@ func TestBPlusTree_Delete(t *testing.T) {
 	defer func() {
 		_ = os.RemoveAll(path)
 	}()
-	tree := NewBPlusTree(path, false)
+	tree, err := NewBPlusTree(path, false)
+	assert.Nil(t, err)
 
 	res1, ok1 := tree.Delete([]byte("not exist"))
 	assert.Nil(t, res1)
@@ -84,7 +89,8 @@ func TestBPlusTree_Size(t *testing.T) {
 	defer func() {
 		_ = os.RemoveAll(path)
 	}()
-	tree := NewBPlusTree(path, false)
+	tree, err := NewBPlusTree(path, false)
+	assert.Nil(t, err)
 
 	t.Log(tree.Size())
 	assert.Equal(t, tree.Size(), 0)
@@ -103,7 +109,8 @@ func TestBPlusTree_Iterator(t *testing.T) {
 	defer func() {
 		_ = os.RemoveAll(path)
 	}()
-	tree := NewBPlusTree(path, false)
+	tree, err := NewBPlusTree(path, false)
+	assert.Nil(t, err)
 
 	tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 123, Offset: 999})
 	tree.Put([]byte("fs"), &data.LogRecordPos{Fid: 123, Offset: 999})
@@ -124,4 +131,207 @@ func TestBPlusTree_Iterator(t *testing.T) {
 		assert.NotNil(t, iter2.Key())
 		assert.NotNil(t, iter2.Value())
 	}
-}
\ No newline at end of file
+}
+func TestBPlusTree_DeleteRange(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-delete-range")
+	_ = os.MkdirAll(path, os.ModePerm)
+
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	tree, err := NewBPlusTree(path, false)
+	assert.Nil(t, err)
+
+	tree.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	tree.Put([]byte("ab"), &data.LogRecordPos{Fid: 1, Offset: 20})
+	tree.Put([]byte("abc"), &data.LogRecordPos{Fid: 1, Offset: 30})
+	tree.Put([]byte("b"), &data.LogRecordPos{Fid: 1, Offset: 40})
+	tree.Put([]byte("c"), &data.LogRecordPos{Fid: 1, Offset: 50})
+
+	res1 := tree.DeleteRange([]byte("x"), []byte("z"))
+	assert.Equal(t, 0, len(res1))
+
+	res2 := tree.DeleteRange([]byte("ab"), []byte("b"))
+	assert.Equal(t, 2, len(res2))
+	assert.Equal(t, int64(20), res2[0].Offset)
+	assert.Equal(t, int64(30), res2[1].Offset)
+	assert.Nil(t, tree.Get([]byte("abc")))
+	assert.NotNil(t, tree.Get([]byte("b")))
+	assert.Equal(t, 3, tree.Size())
+
+	res3 := tree.DeleteRange(nil, nil)
+	assert.Equal(t, 3, len(res3))
+	assert.Equal(t, 0, tree.Size())
+}
+
+func TestBPlusTree_Iterator_Seek(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-Iterator-seek")
+	_ = os.MkdirAll(path, os.ModePerm)
+
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	tree, err := NewBPlusTree(path, false)
+	assert.Nil(t, err)
+
+	tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 123, Offset: 999})
+	tree.Put([]byte("fs"), &data.LogRecordPos{Fid: 123, Offset: 999})
+	tree.Put([]byte("gg"), &data.LogRecordPos{Fid: 123, Offset: 999})
+
+	iter1 := tree.Iterator(false)
+	iter1.Seek([]byte("b"))
+	assert.Equal(t, []byte("fs"), iter1.Key())
+	iter1.Close()
+
+	// Reversed Seek() finds the first key smaller than or equal to the parameter
+	iter2 := tree.Iterator(true)
+	iter2.Seek([]byte("b"))
+	assert.Equal(t, []byte("aac"), iter2.Key())
+	iter2.Seek([]byte("gg"))
+	assert.Equal(t, []byte("gg"), iter2.Key())
+	iter2.Seek([]byte("zz"))
+	assert.Equal(t, []byte("gg"), iter2.Key())
+	iter2.Seek([]byte("a"))
+	assert.False(t, iter2.Valid())
+	iter2.Close()
+}
+
+func TestBPlusTree_HighWaterMark(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-high-water-mark")
+	_ = os.MkdirAll(path, os.ModePerm)
+
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	tree, err := NewBPlusTree(path, false)
+	assert.Nil(t, err)
+	assert.Nil(t, tree.HighWaterMark())
+
+	// The mark is saved with the next change
+	tree.SetHighWaterMark(&data.LogRecordPos{Fid: 1, Offset: 100})
+	assert.Equal(t, int64(100), tree.HighWaterMark().Offset)
+	tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 1, Offset: 50})
+	tree.SetHighWaterMark(&data.LogRecordPos{Fid: 1, Offset: 200})
+	tree.Delete([]byte("aac"))
+	// Saved when closing
+	tree.SetHighWaterMark(&data.LogRecordPos{Fid: 2, Offset: 300})
+	assert.Nil(t, tree.Close())
+
+	tree2, err := NewBPlusTree(path, false)
+	assert.Nil(t, err)
+	mark := tree2.HighWaterMark()
+	assert.Equal(t, uint32(2), mark.Fid)
+	assert.Equal(t, int64(300), mark.Offset)
+	assert.Equal(t, 0, tree2.Size())
+	assert.Nil(t, tree2.Close())
+}
+
+// Opening fails with an error instead of panicking
+func TestBPlusTree_OpenError(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-open-error")
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+
+	// The directory doesn't exist
+	tree, err := NewBPlusTree(path, false)
+	assert.NotNil(t, err)
+	assert.Nil(t, tree)
+
+	indexer, err := NewIndexer(BPTree, path, false, 0)
+	assert.NotNil(t, err)
+	assert.Nil(t, indexer)
+
+	_, err = NewIndexer(0, path, false, 0)
+	assert.Equal(t, ErrUnsupportedIndexType, err)
+}
+
+func TestBPlusTree_BloomFilter(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-bloom-filter")
+	_ = os.MkdirAll(path, os.ModePerm)
+
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	tree, err := NewBPlusTree(path, false)
+	assert.Nil(t, err)
+
+	// More keys than the capacity of the first filter, a bigger one is built
+	ops := make([]BatchOp, bloomMinCapacity + 1000)
+	for i := range ops {
+		ops[i] = BatchOp{Key: []byte(fmt.Sprintf("key-%06d", i)), Pos: &data.LogRecordPos{Fid: 1, Offset: int64(i)}}
+	}
+	tree.ApplyBatch(ops)
+	assert.Greater(t, tree.bloom.Load().capacity, int64(bloomMinCapacity))
+	for i := 0; i < len(ops); i += 100 {
+		assert.NotNil(t, tree.Get(ops[i].Key))
+	}
+
+	// Misses are answered by the filter
+	for i := 0; i < 10000; i++ {
+		assert.Nil(t, tree.Get([]byte(fmt.Sprintf("missing-%06d", i))))
+	}
+	_, ok := tree.Delete([]byte("missing"))
+	assert.False(t, ok)
+	assert.Less(t, tree.BloomFalsePositiveRate(), 0.05)
+	assert.Greater(t, tree.bloomNegatives.Load(), int64(9000))
+	assert.Nil(t, tree.Close())
+
+	// The filter saved is loaded
+	tree2, err := NewBPlusTree(path, false)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(ops)), tree2.bloom.Load().added.Load())
+	assert.NotNil(t, tree2.Get(ops[0].Key))
+	assert.Nil(t, tree2.Close())
+
+	// The index is changed without the filter, it is rebuilt instead of loaded
+	db, err := bbolt.Open(filepath.Join(path, BPlusTreeIndexFileName), 0644, nil)
+	assert.Nil(t, err)
+	err = db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(indexBucketName).Put([]byte("new key"), data.EncodeLogRecordPos(&data.LogRecordPos{Fid: 2}))
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+
+	tree3, err := NewBPlusTree(path, false)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(ops) + 1), tree3.bloom.Load().added.Load())
+	assert.Equal(t, uint32(2), tree3.Get([]byte("new key")).Fid)
+	assert.Nil(t, tree3.Close())
+}
+
+// Overwriting the keys in the bucket doesn't fill the filter, it is not rebuilt
+func TestBPlusTree_BloomFilter_Overwrite(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-bloom-overwrite")
+	_ = os.MkdirAll(path, os.ModePerm)
+
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	tree, err := NewBPlusTree(path, false)
+	assert.Nil(t, err)
+
+	// As many keys as the capacity of the filter
+	ops := make([]BatchOp, bloomMinCapacity)
+	for i := range ops {
+		ops[i] = BatchOp{Key: []byte(fmt.Sprintf("key-%06d", i)), Pos: &data.LogRecordPos{Fid: 1, Offset: int64(i)}}
+	}
+	tree.ApplyBatch(ops)
+	bloom := tree.bloom.Load()
+	assert.Equal(t, int64(bloomMinCapacity), bloom.added.Load())
+
+	for i := 0; i < 100; i++ {
+		oldPos := tree.Put(ops[0].Key, &data.LogRecordPos{Fid: 2, Offset: int64(i)})
+		assert.NotNil(t, oldPos)
+		oldPositions := tree.ApplyBatch(ops[1:3])
+		assert.NotNil(t, oldPositions[0])
+	}
+	assert.Same(t, bloom, tree.bloom.Load())
+	assert.Equal(t, int64(bloomMinCapacity), bloom.added.Load())
+
+	// A new key is counted
+	tree.Put([]byte("new key"), &data.LogRecordPos{Fid: 2})
+	assert.NotSame(t, bloom, tree.bloom.Load())
+	assert.NotNil(t, tree.Get([]byte("new key")))
+	assert.Nil(t, tree.Close())
+}
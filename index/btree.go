@@ -3,7 +3,6 @@ package index
 import (
 	"bitcask-go/data"
 	"bytes"
-	"sort"
 	"sync"
 
 	"github.com/google/btree"
@@ -13,135 +12,209 @@ import (
 // encapsulation of BTree storage created by Google
 // https://github.com/google/btree
 type BTree struct {
-	tree *btree.BTree
+	tree *btree.BTreeG[Item]
 
 	// It is said in BTree function:
 	// "Write operations are not safe for concurrent mutation by multiple goroutines,
 	// but Read operations are."
 	// So create a lock for concurrent write
 	lock *sync.RWMutex
+
+	memoryUsage int64
 }
 
+// Approximate memory used by an item besides its key
+// The item in a node, with the unused space of the nodes
+const btreeItemOverhead = 56
+
 // Initialize BTree
 func NewBTree() *BTree {
 	return &BTree{
 		// Google btree needs an initialezed parameter to control the number of leaves
-		tree: btree.New(32),
+		tree: btree.NewG(32, lessItem),
 		lock: new(sync.RWMutex),
 	}
 }
 
 func (bt *BTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
-	it := &Item{key: key, pos: pos}
 	bt.lock.Lock() // Add lock before write
-	oldItem := bt.tree.ReplaceOrInsert(it)
+	oldPos := bt.put(key, pos)
 	bt.lock.Unlock()
-	if oldItem == nil {
-		return nil
-	}
-	return oldItem.(*Item).pos
+	return oldPos
 }
 
 func (bt *BTree) Get(key []byte) *data.LogRecordPos {
-	it := &Item{key: key}
-	btreeItem := bt.tree.Get(it)
-	if btreeItem == nil {
+	// Merge reads the index without the lock of the database, so the read lock is needed here
+	bt.lock.RLock()
+	item, ok := bt.tree.Get(Item{key: key})
+	bt.lock.RUnlock()
+	if !ok {
 		return nil
 	}
-	return btreeItem.(*Item).pos
+	return copyPos(item.pos)
 }
 
 func (bt *BTree) Delete(key []byte) (*data.LogRecordPos, bool) {
-	it := &Item{key: key}
 	bt.lock.Lock()
-	oldItem := bt.tree.Delete(it)
+	oldPos := bt.delete(key)
 	bt.lock.Unlock()
-	if oldItem == nil {
-		return nil, false
+	return oldPos, oldPos != nil
+}
+
+func (bt *BTree) ApplyBatch(ops []BatchOp) []*data.LogRecordPos {
+	oldPositions := make([]*data.LogRecordPos, len(ops))
+	// Hold the lock once for the whole batch
+	bt.lock.Lock()
+	defer bt.lock.Unlock()
+	for i, op := range ops {
+		if op.Pos == nil {
+			oldPositions[i] = bt.delete(op.Key)
+		} else {
+			oldPositions[i] = bt.put(op.Key, op.Pos)
+		}
+	}
+	return oldPositions
+}
+
+func (bt *BTree) DeleteRange(start, end []byte) []*data.LogRecordPos {
+	bt.lock.Lock()
+	defer bt.lock.Unlock()
+
+	// Btree cannot be modified while ascending
+	// Collect the keys in the range first, then delete them
+	var keys [][]byte
+	bt.tree.AscendGreaterOrEqual(Item{key: start}, func(item Item) bool {
+		if !beforeEnd(item.key, end) {
+			return false
+		}
+		keys = append(keys, item.key)
+		return true
+	})
+
+	positions := make([]*data.LogRecordPos, 0, len(keys))
+	for _, key := range keys {
+		positions = append(positions, bt.delete(key))
 	}
-	return oldItem.(*Item).pos, true
+	return positions
 }
 
 func (bt *BTree) Size() int {
 	return bt.tree.Len()
 }
 
+func (bt *BTree) MemoryUsage() int64 {
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
+	return bt.memoryUsage
+}
+
 func (bt *BTree) Iterator(reverse bool) Iterator {
 	if bt.tree == nil {
 		return nil
 	}
-	bt.lock.RLock()
-	defer bt.lock.RUnlock()
-	return newBTreeIterator(bt.tree, reverse)
+	// Clone is lazy, it only creates a copy-on-write snapshot
+	// Puts after this point copy the nodes they change, the snapshot is never modified
+	bt.lock.Lock()
+	snapshot := bt.tree.Clone()
+	bt.lock.Unlock()
+	return newBTreeIterator(snapshot, reverse)
 }
 
 func (bt *BTree) Close() error {
 	return nil
 }
 
+// Must have lock when using this method
+func (bt *BTree) put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	oldItem, replaced := bt.tree.ReplaceOrInsert(Item{key: key, pos: *pos})
+	if !replaced {
+		bt.memoryUsage += int64(len(key)) + btreeItemOverhead
+		return nil
+	}
+	return copyPos(oldItem.pos)
+}
+
+// Must have lock when using this method
+func (bt *BTree) delete(key []byte) *data.LogRecordPos {
+	oldItem, ok := bt.tree.Delete(Item{key: key})
+	if !ok {
+		return nil
+	}
+	bt.memoryUsage -= int64(len(oldItem.key)) + btreeItemOverhead
+	return copyPos(oldItem.pos)
+}
+
+// Number of items the btree iterator loads from the snapshot each time
+const btreeIteratorBatchSize = 64
+
 // BTree iterator
+// Walk a snapshot of the btree lazily, only a small batch of items is in memory
 type btreeIterator struct {
-	// Iterator creates an array to temporarily save keys
-	// currIndex is the place of the iterator in the array
-	crrIndex int
+	// Snapshot of the btree when the iterator is created
+	tree *btree.BTreeG[Item]
 
 	// Reverse iterate?
 	reverse bool
 
-	// Items saved in Btree
-	values []*Item
+	// Items loaded from the snapshot
+	// currIndex is the place of the iterator in the batch
+	crrIndex int
+	values []Item
+}
+
+func newBTreeIterator(tree *btree.BTreeG[Item], reverse bool) *btreeIterator {
+	bti := &btreeIterator{
+		tree: tree,
+		reverse: reverse,
+	}
+	bti.Rewind()
+	return bti
 }
 
-func newBTreeIterator(tree *btree.BTree, reverse bool) *btreeIterator {
-	var idx int
-	values := make([]*Item, tree.Len())
+// Load the next batch of items, starting from pivot
+// If pivot is nil, start from the beginning. If exclusive, pivot itself is skipped
+func (bti *btreeIterator) load(pivot *Item, exclusive bool) {
+	bti.crrIndex = 0
+	// A new batch instead of reusing the old one, Value returns pointers into it
+	bti.values = make([]Item, 0, btreeIteratorBatchSize)
 
 	// This anonymous function defines what to do to each element in the tree
-	saveValues := func(it btree.Item) bool {
-		// it.(*Item) 
-		// (it) is an interface. This grammar means turning (it) to *Item
-		// If (it) is actually an instance of *Item, the conversion will succeed; otherwise, trigger a panic.
-		// In btree, each element in the tree is stored as the btree.Item interface type. 
-		// When traversing the tree, elements are passed as btree.Item types. 
-		// If we know these elements are actually of type *Item, 
-		// we can use a type assertion to convert them to *Item in order to access the fields and methods of *Item.
-		values[idx] = it.(*Item)
-		idx++
+	saveValues := func(item Item) bool {
+		if exclusive && bytes.Equal(item.key, pivot.key) {
+			return true
+		}
+		bti.values = append(bti.values, item)
 		// return false means terminating iteration
-		return true
+		return len(bti.values) < btreeIteratorBatchSize
 	}
 
-	if reverse {
-		tree.Descend(saveValues)
-	} else{
-		tree.Ascend(saveValues)
-	}
-	
-	return &btreeIterator{
-		crrIndex: 0,
-		reverse: reverse,
-		values: values,
+	switch {
+	case pivot == nil && bti.reverse:
+		bti.tree.Descend(saveValues)
+	case pivot == nil:
+		bti.tree.Ascend(saveValues)
+	case bti.reverse:
+		bti.tree.DescendLessOrEqual(*pivot, saveValues)
+	default:
+		bti.tree.AscendGreaterOrEqual(*pivot, saveValues)
 	}
 }
 
 func(bti *btreeIterator) Rewind() {
-	bti.crrIndex = 0
+	bti.load(nil, false)
 }
 
 func(bti *btreeIterator) Seek(key []byte) {
-	if bti.reverse {
-		bti.crrIndex = sort.Search(len(bti.values), func(i int) bool {
-			return bytes.Compare(bti.values[i].key, key) <= 0
-		})
-	} else {
-		bti.crrIndex = sort.Search(len(bti.values), func(i int) bool {
-			return bytes.Compare(bti.values[i].key, key) >= 0
-		})
-	}
+	// In reverse mode, it finds the first key smaller than or equal to key
+	bti.load(&Item{key: key}, false)
 }
 
 func(bti *btreeIterator) Next() {
 	bti.crrIndex += 1
+	if bti.crrIndex == len(bti.values) && len(bti.values) == btreeIteratorBatchSize {
+		// The batch is used up, continue after its last item
+		bti.load(&bti.values[len(bti.values) - 1], true)
+	}
 }
 
 func(bti *btreeIterator) Valid() bool {
@@ -153,9 +226,10 @@ func(bti *btreeIterator) Key() []byte {
 }
 
 func(bti *btreeIterator) Value() *data.LogRecordPos {
-	return bti.values[bti.crrIndex].pos
+	return &bti.values[bti.crrIndex].pos
 }
 
 func(bti *btreeIterator) Close() {
+	bti.tree = nil
 	bti.values = nil
 }
@@ -2,6 +2,7 @@ package index
 
 import (
 	"bitcask-go/data"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -106,3 +107,66 @@ func TestBTree_Iterator(t *testing.T) {
 		t.Log("key = ", string(iter6.Key()))
 	}
 }
+
+
+func TestBTree_DeleteRange(t *testing.T) {
+	bt := NewBTree()
+	bt.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	bt.Put([]byte("ab"), &data.LogRecordPos{Fid: 1, Offset: 20})
+	bt.Put([]byte("abc"), &data.LogRecordPos{Fid: 1, Offset: 30})
+	bt.Put([]byte("b"), &data.LogRecordPos{Fid: 1, Offset: 40})
+	bt.Put([]byte("c"), &data.LogRecordPos{Fid: 1, Offset: 50})
+
+	res1 := bt.DeleteRange([]byte("x"), []byte("z"))
+	assert.Equal(t, 0, len(res1))
+
+	res2 := bt.DeleteRange([]byte("ab"), []byte("b"))
+	assert.Equal(t, 2, len(res2))
+	assert.Equal(t, int64(20), res2[0].Offset)
+	assert.Equal(t, int64(30), res2[1].Offset)
+	assert.Nil(t, bt.Get([]byte("abc")))
+	assert.NotNil(t, bt.Get([]byte("b")))
+	assert.Equal(t, 3, bt.Size())
+
+	res3 := bt.DeleteRange([]byte("b"), nil)
+	assert.Equal(t, 2, len(res3))
+	assert.Equal(t, 1, bt.Size())
+}
+
+
+func TestBTree_Iterator_Snapshot(t *testing.T) {
+	bt := NewBTree()
+	// More items than one batch of the iterator
+	for i := 0; i < 1000; i++ {
+		bt.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	iter1 := bt.Iterator(false)
+	defer iter1.Close()
+	iter2 := bt.Iterator(true)
+	defer iter2.Close()
+
+	// Changes after the iterator is created are not visible to it
+	for i := 0; i < 1000; i += 2 {
+		bt.Delete([]byte(fmt.Sprintf("key-%04d", i)))
+	}
+	bt.Put([]byte("key-0500"), &data.LogRecordPos{Fid: 2, Offset: 0})
+
+	var n int
+	for iter1.Rewind(); iter1.Valid(); iter1.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%04d", n)), iter1.Key())
+		assert.Equal(t, uint32(1), iter1.Value().Fid)
+		n++
+	}
+	assert.Equal(t, 1000, n)
+
+	n = 0
+	for iter2.Seek([]byte("key-0899")); iter2.Valid(); iter2.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%04d", 899 - n)), iter2.Key())
+		n++
+	}
+	assert.Equal(t, 900, n)
+
+	assert.Equal(t, 501, bt.Size())
+	assert.Equal(t, uint32(2), bt.Get([]byte("key-0500")).Fid)
+}
@@ -0,0 +1,249 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"hash/maphash"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Number of shards of the hash index, a power of 2
+const hashShardCount = 32
+
+// Hash index
+// For the databases only doing point lookups, keeping the keys in order costs memory and CPU for nothing
+// Keys are spread over shards by their hash, each shard is a map with its own lock
+// The index has no order, the iterator sorts a copy of all the keys when it is created
+type HashIndex struct {
+	seed maphash.Seed
+	shards [hashShardCount]hashShard
+	size atomic.Int64
+	memoryUsage atomic.Int64
+}
+
+// Positions are stored in the map by value, without a pointer for each key
+type hashShard struct {
+	lock sync.RWMutex
+	entries map[string]data.LogRecordPos
+}
+
+// Approximate memory used by an entry besides its key
+// The key header and the position in the bucket, with the unused slots of the buckets
+const hashEntryOverhead = 48
+
+// Initialize a HashIndex
+func NewHashIndex() *HashIndex {
+	hi := &HashIndex{seed: maphash.MakeSeed()}
+	for i := range hi.shards {
+		hi.shards[i].entries = make(map[string]data.LogRecordPos)
+	}
+	return hi
+}
+
+func (hi *HashIndex) shardOf(key []byte) int {
+	return int(maphash.Bytes(hi.seed, key) & (hashShardCount - 1))
+}
+
+func (hi *HashIndex) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	shard := &hi.shards[hi.shardOf(key)]
+	shard.lock.Lock()
+	oldPos := hi.put(shard, key, pos)
+	shard.lock.Unlock()
+	return oldPos
+}
+
+func (hi *HashIndex) Get(key []byte) *data.LogRecordPos {
+	shard := &hi.shards[hi.shardOf(key)]
+	shard.lock.RLock()
+	pos, ok := shard.entries[string(key)]
+	shard.lock.RUnlock()
+	if !ok {
+		return nil
+	}
+	return copyPos(pos)
+}
+
+func (hi *HashIndex) Delete(key []byte) (*data.LogRecordPos, bool) {
+	shard := &hi.shards[hi.shardOf(key)]
+	shard.lock.Lock()
+	oldPos := hi.delete(shard, key)
+	shard.lock.Unlock()
+	return oldPos, oldPos != nil
+}
+
+func (hi *HashIndex) ApplyBatch(ops []BatchOp) []*data.LogRecordPos {
+	oldPositions := make([]*data.LogRecordPos, len(ops))
+	if len(ops) == 0 {
+		return oldPositions
+	}
+	// Lock the shards of the batch in order, so an iterator sees none or all of the batch
+	shardIds := make([]int, len(ops))
+	var locked [hashShardCount]bool
+	for i, op := range ops {
+		shardIds[i] = hi.shardOf(op.Key)
+		locked[shardIds[i]] = true
+	}
+	for i := range hi.shards {
+		if locked[i] {
+			hi.shards[i].lock.Lock()
+		}
+	}
+	for i, op := range ops {
+		shard := &hi.shards[shardIds[i]]
+		if op.Pos == nil {
+			oldPositions[i] = hi.delete(shard, op.Key)
+		} else {
+			oldPositions[i] = hi.put(shard, op.Key, op.Pos)
+		}
+	}
+	for i := range hi.shards {
+		if locked[i] {
+			hi.shards[i].lock.Unlock()
+		}
+	}
+	return oldPositions
+}
+
+// The keys have no order, every key is checked
+func (hi *HashIndex) DeleteRange(start, end []byte) []*data.LogRecordPos {
+	hi.lockAll()
+	defer hi.unlockAll()
+
+	var positions []*data.LogRecordPos
+	for i := range hi.shards {
+		shard := &hi.shards[i]
+		for key, pos := range shard.entries {
+			if bytes.Compare([]byte(key), start) >= 0 && beforeEnd([]byte(key), end) {
+				delete(shard.entries, key)
+				hi.memoryUsage.Add(-int64(len(key)) - hashEntryOverhead)
+				positions = append(positions, copyPos(pos))
+			}
+		}
+	}
+	hi.size.Add(-int64(len(positions)))
+	return positions
+}
+
+func (hi *HashIndex) Size() int {
+	return int(hi.size.Load())
+}
+
+func (hi *HashIndex) MemoryUsage() int64 {
+	return hi.memoryUsage.Load()
+}
+
+// Copy and sort all the keys, the iterator walks the copy
+// It takes time and memory of the whole index, the hash index is for the databases rarely iterating
+func (hi *HashIndex) Iterator(reverse bool) Iterator {
+	hi.lockAll()
+	items := make([]Item, 0, hi.size.Load())
+	for i := range hi.shards {
+		for key, pos := range hi.shards[i].entries {
+			items = append(items, Item{key: []byte(key), pos: pos})
+		}
+	}
+	hi.unlockAll()
+
+	sort.Slice(items, func(i, j int) bool {
+		return bytes.Compare(items[i].key, items[j].key) < 0
+	})
+	return &hashIterator{items: items, reverse: reverse}
+}
+
+func (hi *HashIndex) Close() error {
+	return nil
+}
+
+// Must have the lock of the shard when using this method
+func (hi *HashIndex) put(shard *hashShard, key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	oldPos, ok := shard.entries[string(key)]
+	shard.entries[string(key)] = *pos
+	if !ok {
+		hi.size.Add(1)
+		hi.memoryUsage.Add(int64(len(key)) + hashEntryOverhead)
+		return nil
+	}
+	return copyPos(oldPos)
+}
+
+// Must have the lock of the shard when using this method
+func (hi *HashIndex) delete(shard *hashShard, key []byte) *data.LogRecordPos {
+	oldPos, ok := shard.entries[string(key)]
+	if !ok {
+		return nil
+	}
+	delete(shard.entries, string(key))
+	hi.size.Add(-1)
+	hi.memoryUsage.Add(-int64(len(key)) - hashEntryOverhead)
+	return copyPos(oldPos)
+}
+
+// Lock the shards in order
+func (hi *HashIndex) lockAll() {
+	for i := range hi.shards {
+		hi.shards[i].lock.Lock()
+	}
+}
+
+func (hi *HashIndex) unlockAll() {
+	for i := range hi.shards {
+		hi.shards[i].lock.Unlock()
+	}
+}
+
+// Hash index iterator
+// Walk the sorted copy of the keys
+type hashIterator struct {
+	items []Item
+	reverse bool
+	currIndex int               // Place in the order of the iterator
+}
+
+// Place of the current item in items
+func (hit *hashIterator) index() int {
+	if hit.reverse {
+		return len(hit.items) - 1 - hit.currIndex
+	}
+	return hit.currIndex
+}
+
+func (hit *hashIterator) Rewind() {
+	hit.currIndex = 0
+}
+
+func (hit *hashIterator) Seek(key []byte) {
+	i := sort.Search(len(hit.items), func(i int) bool {
+		return bytes.Compare(hit.items[i].key, key) >= 0
+	})
+	if !hit.reverse {
+		hit.currIndex = i
+		return
+	}
+	// In reverse mode, it finds the first key smaller than or equal to key
+	if i < len(hit.items) && bytes.Equal(hit.items[i].key, key) {
+		i++
+	}
+	hit.currIndex = len(hit.items) - i
+}
+
+func (hit *hashIterator) Next() {
+	hit.currIndex++
+}
+
+func (hit *hashIterator) Valid() bool {
+	return hit.currIndex < len(hit.items)
+}
+
+func (hit *hashIterator) Key() []byte {
+	return hit.items[hit.index()].key
+}
+
+func (hit *hashIterator) Value() *data.LogRecordPos {
+	return &hit.items[hit.index()].pos
+}
+
+func (hit *hashIterator) Close() {
+	hit.items = nil
+}
@@ -0,0 +1,132 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashIndex_Put(t *testing.T) {
+	hi := NewHashIndex()
+	res1 := hi.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 12, Size: 5})
+	assert.Nil(t, res1)
+	res2 := hi.Put([]byte("key-2"), &data.LogRecordPos{Fid: 1, Offset: 12})
+	assert.Nil(t, res2)
+
+	res3 := hi.Put([]byte("key-1"), &data.LogRecordPos{Fid: 2, Offset: 13})
+	assert.Equal(t, data.LogRecordPos{Fid: 1, Offset: 12, Size: 5}, *res3)
+	assert.Equal(t, 2, hi.Size())
+
+	pos := hi.Get([]byte("key-1"))
+	assert.Equal(t, uint32(2), pos.Fid)
+	assert.Nil(t, hi.Get([]byte("key-3")))
+}
+
+func TestHashIndex_Delete(t *testing.T) {
+	hi := NewHashIndex()
+	_, ok1 := hi.Delete([]byte("not exist"))
+	assert.False(t, ok1)
+
+	hi.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 12})
+	res2, ok2 := hi.Delete([]byte("key-1"))
+	assert.True(t, ok2)
+	assert.Equal(t, int64(12), res2.Offset)
+	assert.Nil(t, hi.Get([]byte("key-1")))
+	assert.Equal(t, 0, hi.Size())
+}
+
+func TestHashIndex_Random(t *testing.T) {
+	hi := NewHashIndex()
+	ref := make(map[string]int64)
+	r := rand.New(rand.NewSource(1))
+	randKey := func() []byte {
+		return []byte(fmt.Sprintf("key-%03d", r.Intn(500)))
+	}
+	for i := 0; i < 20000; i++ {
+		key := randKey()
+		switch n := r.Intn(100); {
+		case n < 30:
+			pos, ok := hi.Delete(key)
+			offset, exist := ref[string(key)]
+			assert.Equal(t, exist, ok)
+			if exist {
+				assert.Equal(t, offset, pos.Offset)
+			}
+			delete(ref, string(key))
+		case n < 31:
+			end := randKey()
+			positions := hi.DeleteRange(key, end)
+			var deleted int
+			for k := range ref {
+				if k >= string(key) && k < string(end) {
+					delete(ref, k)
+					deleted++
+				}
+			}
+			assert.Equal(t, deleted, len(positions))
+		default:
+			pos := hi.Put(key, &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+			offset, exist := ref[string(key)]
+			if exist {
+				assert.Equal(t, offset, pos.Offset)
+			} else {
+				assert.Nil(t, pos)
+			}
+			ref[string(key)] = int64(i)
+		}
+	}
+	assert.Equal(t, len(ref), hi.Size())
+
+	var keys []string
+	for k, offset := range ref {
+		keys = append(keys, k)
+		assert.Equal(t, offset, hi.Get([]byte(k)).Offset)
+	}
+	sort.Strings(keys)
+
+	// The iterator walks the keys in order
+	iter1 := hi.Iterator(false)
+	var forward []string
+	for iter1.Rewind(); iter1.Valid(); iter1.Next() {
+		forward = append(forward, string(iter1.Key()))
+		assert.Equal(t, ref[string(iter1.Key())], iter1.Value().Offset)
+	}
+	assert.Equal(t, keys, forward)
+
+	iter2 := hi.Iterator(true)
+	var reverse []string
+	for iter2.Rewind(); iter2.Valid(); iter2.Next() {
+		reverse = append(reverse, string(iter2.Key()))
+	}
+	assert.Equal(t, len(keys), len(reverse))
+	for i := range reverse {
+		assert.Equal(t, keys[len(keys) - 1 - i], reverse[i])
+	}
+
+	// Seek to random keys in both directions
+	for i := 0; i < 200; i++ {
+		target := randKey()
+		idx := sort.SearchStrings(keys, string(target))
+		iter1.Seek(target)
+		if idx < len(keys) {
+			assert.Equal(t, keys[idx], string(iter1.Key()))
+		} else {
+			assert.False(t, iter1.Valid())
+		}
+
+		iter2.Seek(target)
+		if idx < len(keys) && keys[idx] == string(target) {
+			assert.Equal(t, target, iter2.Key())
+		} else if idx > 0 {
+			assert.Equal(t, keys[idx - 1], string(iter2.Key()))
+		} else {
+			assert.False(t, iter2.Valid())
+		}
+	}
+	iter1.Close()
+	iter2.Close()
+}
@@ -0,0 +1,435 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"container/list"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/google/btree"
+)
+
+const HybridSpillFileName = "hybrid-index"
+
+// Approximate memory used by an entry in memory besides its key
+// The item, the entry and its place in the list, the map and the btree
+const hybridEntryOverhead = 160
+
+// Hybrid index
+// The recently used keys are kept in an in-memory btree, up to the memory limit
+// When the limit is reached, the least recently used keys are spilled to a B+ tree on the disk
+// Lookups try the btree first, then the B+ tree. A key found on the disk is moved back to memory
+// The B+ tree is only a spill file, the index is loaded from the data files when opening like the in-memory indexes
+type HybridIndex struct {
+	hot *btree.BTreeG[Item]              // Items of the keys in memory. A deleted key spilled to the disk has an item with deletedPos
+	cold *BPlusTree                      // Keys spilled to the disk
+	lock *sync.RWMutex
+	entries map[string]*hybridEntry
+	lru *list.List                       // Entries in memory, the front is the most recently used
+	lruLock *sync.Mutex                  // Protect lru for Get, which only has the read lock
+	spillFileName string
+	memoryLimit int64
+	memoryUsage int64
+	size int                             // Number of keys in both tiers
+	iterators int                        // Number of open iterators, the B+ tree is not written while they read it
+}
+
+// A key in memory
+type hybridEntry struct {
+	item Item
+	inCold bool                          // The key has an older entry on the disk, which the item hides
+	clean bool                           // The item is the same as the entry on the disk, nothing is written when it is spilled
+	elem *list.Element
+}
+
+// Initialize a HybridIndex keeping up to memoryLimit bytes of entries in memory
+// The spill file left by the last run is removed
+func NewHybridIndex(dirPath string, memoryLimit int64) (*HybridIndex, error) {
+	spillFileName := filepath.Join(dirPath, HybridSpillFileName)
+	if err := os.Remove(spillFileName); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	cold, err := openBPlusTree(spillFileName, false)
+	if err != nil {
+		return nil, err
+	}
+	return &HybridIndex{
+		hot: btree.NewG(32, lessItem),
+		cold: cold,
+		lock: new(sync.RWMutex),
+		entries: make(map[string]*hybridEntry),
+		lru: list.New(),
+		lruLock: new(sync.Mutex),
+		spillFileName: spillFileName,
+		memoryLimit: memoryLimit,
+	}, nil
+}
+
+func (hi *HybridIndex) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	hi.lock.Lock()
+	defer hi.lock.Unlock()
+	oldPos := hi.put(key, pos)
+	hi.spill()
+	return oldPos
+}
+
+// A key read from the disk is moved to memory, so the keys read often don't pay for a transaction of the B+ tree
+func (hi *HybridIndex) Get(key []byte) *data.LogRecordPos {
+	hi.lock.RLock()
+	if entry, ok := hi.entries[string(key)]; ok {
+		pos := entry.item.pos
+		hi.lruLock.Lock()
+		hi.lru.MoveToFront(entry.elem)
+		hi.lruLock.Unlock()
+		hi.lock.RUnlock()
+		if isDeletedPos(pos) {
+			return nil
+		}
+		return copyPos(pos)
+	}
+	pos := hi.cold.Get(key)
+	hi.lock.RUnlock()
+	if pos == nil {
+		return nil
+	}
+
+	// The key may be changed before the write lock is taken, so it is looked up again
+	hi.lock.Lock()
+	defer hi.lock.Unlock()
+	if entry, ok := hi.entries[string(key)]; ok {
+		if isDeletedPos(entry.item.pos) {
+			return nil
+		}
+		return copyPos(entry.item.pos)
+	}
+	pos = hi.cold.Get(key)
+	if pos == nil {
+		return nil
+	}
+	entry := hi.addEntry(Item{key: append([]byte(nil), key...), pos: *pos}, true)
+	entry.clean = true
+	hi.spill()
+	return pos
+}
+
+func (hi *HybridIndex) Delete(key []byte) (*data.LogRecordPos, bool) {
+	hi.lock.Lock()
+	defer hi.lock.Unlock()
+	oldPos, ok := hi.delete(key)
+	hi.spill()
+	return oldPos, ok
+}
+
+func (hi *HybridIndex) ApplyBatch(ops []BatchOp) []*data.LogRecordPos {
+	oldPositions := make([]*data.LogRecordPos, len(ops))
+	// Hold the lock once for the whole batch
+	hi.lock.Lock()
+	defer hi.lock.Unlock()
+	for i, op := range ops {
+		if op.Pos == nil {
+			oldPositions[i], _ = hi.delete(op.Key)
+		} else {
+			oldPositions[i] = hi.put(op.Key, op.Pos)
+		}
+	}
+	hi.spill()
+	return oldPositions
+}
+
+func (hi *HybridIndex) DeleteRange(start, end []byte) []*data.LogRecordPos {
+	hi.lock.Lock()
+	defer hi.lock.Unlock()
+	if hi.iterators > 0 {
+		return hi.deleteRangeInMemory(start, end)
+	}
+
+	// Spill the keys of the range in memory first, then delete the range on the disk at once
+	var entries []*hybridEntry
+	hi.hot.AscendGreaterOrEqual(Item{key: start}, func(item Item) bool {
+		if !beforeEnd(item.key, end) {
+			return false
+		}
+		entries = append(entries, hi.entries[string(item.key)])
+		return true
+	})
+	hi.cold.ApplyBatch(hi.removeEntries(entries))
+
+	positions := hi.cold.DeleteRange(start, end)
+	hi.size -= len(positions)
+	return positions
+}
+
+func (hi *HybridIndex) Size() int {
+	hi.lock.RLock()
+	defer hi.lock.RUnlock()
+	return hi.size
+}
+
+// Only the keys in memory, the B+ tree maps its file instead of allocating memory
+func (hi *HybridIndex) MemoryUsage() int64 {
+	hi.lock.RLock()
+	defer hi.lock.RUnlock()
+	return hi.memoryUsage
+}
+
+// The iterator holds a read transaction of the B+ tree like the B+ tree iterator
+// Growing the file of the B+ tree waits for the read transactions, so nothing is spilled until the iterators are closed
+func (hi *HybridIndex) Iterator(reverse bool) Iterator {
+	// Take both snapshots under the lock, so no key is moving between them
+	hi.lock.Lock()
+	defer hi.lock.Unlock()
+	hi.iterators++
+	hit := &hybridIterator{
+		hi: hi,
+		hot: newBTreeIterator(hi.hot.Clone(), reverse),
+		cold: hi.cold.Iterator(reverse),
+		reverse: reverse,
+	}
+	hit.settle()
+	return hit
+}
+
+// Close the spill file and remove it
+// The B+ tree is closed without saving its Bloom filter, the file is removed anyway
+func (hi *HybridIndex) Close() error {
+	if err := hi.cold.tree.Close(); err != nil {
+		return err
+	}
+	if err := os.Remove(hi.spillFileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Must have lock when using this method
+func (hi *HybridIndex) put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	if entry, ok := hi.entries[string(key)]; ok {
+		oldPos := entry.item.pos
+		hi.setItem(entry, Item{key: key, pos: *pos})
+		if isDeletedPos(oldPos) {
+			hi.size++
+			return nil
+		}
+		return copyPos(oldPos)
+	}
+
+	oldPos := hi.cold.Get(key)
+	if oldPos == nil {
+		hi.size++
+	}
+	hi.addEntry(Item{key: key, pos: *pos}, oldPos != nil)
+	return oldPos
+}
+
+// Must have lock when using this method
+func (hi *HybridIndex) delete(key []byte) (*data.LogRecordPos, bool) {
+	if entry, ok := hi.entries[string(key)]; ok {
+		oldPos := entry.item.pos
+		if isDeletedPos(oldPos) {
+			return nil, false
+		}
+		hi.size--
+		if entry.inCold {
+			// Hide the entry on the disk until the deletion is spilled
+			hi.setItem(entry, Item{key: key, pos: deletedPos})
+		} else {
+			hi.removeEntries([]*hybridEntry{entry})
+		}
+		return copyPos(oldPos), true
+	}
+
+	oldPos := hi.cold.Get(key)
+	if oldPos == nil {
+		return nil, false
+	}
+	hi.size--
+	hi.addEntry(Item{key: key, pos: deletedPos}, true)
+	return oldPos, true
+}
+
+// Delete the keys of the range in memory, the keys on the disk are hidden by deleted items
+// Must have lock when using this method
+func (hi *HybridIndex) deleteRangeInMemory(start, end []byte) []*data.LogRecordPos {
+	// Deleting changes the btree, collect the keys in the range first
+	var keys [][]byte
+	hi.hot.AscendGreaterOrEqual(Item{key: start}, func(item Item) bool {
+		if !beforeEnd(item.key, end) {
+			return false
+		}
+		keys = append(keys, item.key)
+		return true
+	})
+	iterator := hi.cold.Iterator(false)
+	if len(start) > 0 {
+		iterator.Seek(start)
+	}
+	for ; iterator.Valid() && beforeEnd(iterator.Key(), end); iterator.Next() {
+		if _, ok := hi.entries[string(iterator.Key())]; !ok {
+			// The key is only valid in the transaction of the iterator
+			keys = append(keys, append([]byte(nil), iterator.Key()...))
+		}
+	}
+	iterator.Close()
+
+	var positions []*data.LogRecordPos
+	for _, key := range keys {
+		if oldPos, ok := hi.delete(key); ok {
+			positions = append(positions, oldPos)
+		}
+	}
+	return positions
+}
+
+func (hi *HybridIndex) addEntry(item Item, inCold bool) *hybridEntry {
+	entry := &hybridEntry{item: item, inCold: inCold}
+	entry.elem = hi.lru.PushFront(entry)
+	hi.entries[string(item.key)] = entry
+	hi.hot.ReplaceOrInsert(item)
+	hi.memoryUsage += int64(len(item.key)) + hybridEntryOverhead
+	return entry
+}
+
+// Iterators may be reading the old item, the btree copies the nodes shared with them before changing
+func (hi *HybridIndex) setItem(entry *hybridEntry, item Item) {
+	entry.item = item
+	entry.clean = false
+	hi.hot.ReplaceOrInsert(item)
+	hi.lru.MoveToFront(entry.elem)
+}
+
+// Remove the entries from memory, return the changes to spill to the disk
+func (hi *HybridIndex) removeEntries(entries []*hybridEntry) []BatchOp {
+	var ops []BatchOp
+	for _, entry := range entries {
+		delete(hi.entries, string(entry.item.key))
+		hi.lru.Remove(entry.elem)
+		hi.hot.Delete(entry.item)
+		hi.memoryUsage -= int64(len(entry.item.key)) + hybridEntryOverhead
+		if entry.clean {
+			continue
+		}
+		if isDeletedPos(entry.item.pos) {
+			if entry.inCold {
+				ops = append(ops, BatchOp{Key: entry.item.key})
+			}
+			continue
+		}
+		ops = append(ops, BatchOp{Key: entry.item.key, Pos: copyPos(entry.item.pos)})
+	}
+	return ops
+}
+
+// Spill the least recently used entries when the memory limit is reached
+// Down to 3/4 of the limit, so the entries are written to the disk in large transactions
+// The memory limit is exceeded while iterators are open, they are spilled when the last one is closed
+// Must have lock when using this method
+func (hi *HybridIndex) spill() {
+	if hi.memoryUsage <= hi.memoryLimit || hi.iterators > 0 {
+		return
+	}
+	var entries []*hybridEntry
+	usage := hi.memoryUsage
+	for elem := hi.lru.Back(); elem != nil && usage > hi.memoryLimit / 4 * 3; elem = elem.Prev() {
+		entry := elem.Value.(*hybridEntry)
+		entries = append(entries, entry)
+		usage -= int64(len(entry.item.key)) + hybridEntryOverhead
+	}
+	hi.cold.ApplyBatch(hi.removeEntries(entries))
+}
+
+// Iterator of the hybrid index
+// Walk the keys in memory and on the disk together, a key in memory hides the same key on the disk
+type hybridIterator struct {
+	hi *HybridIndex
+	hot *btreeIterator
+	cold Iterator
+	reverse bool
+	fromHot bool                         // Is the current key from memory?
+	closed bool
+}
+
+// Is key a before key b in the order of the iterator?
+func (hit *hybridIterator) before(a, b []byte) bool {
+	if hit.reverse {
+		return bytes.Compare(a, b) > 0
+	}
+	return bytes.Compare(a, b) < 0
+}
+
+// Stop at the next key to return
+// Skip the keys on the disk hidden by memory, and the deleted keys
+func (hit *hybridIterator) settle() {
+	for {
+		hotValid, coldValid := hit.hot.Valid(), hit.cold.Valid()
+		if hotValid && coldValid && bytes.Equal(hit.hot.Key(), hit.cold.Key()) {
+			hit.cold.Next()
+			continue
+		}
+		if hotValid && (!coldValid || hit.before(hit.hot.Key(), hit.cold.Key())) {
+			if isDeletedPos(*hit.hot.Value()) {
+				hit.hot.Next()
+				continue
+			}
+			hit.fromHot = true
+			return
+		}
+		hit.fromHot = false
+		return
+	}
+}
+
+func (hit *hybridIterator) Rewind() {
+	hit.hot.Rewind()
+	hit.cold.Rewind()
+	hit.settle()
+}
+
+func (hit *hybridIterator) Seek(key []byte) {
+	hit.hot.Seek(key)
+	hit.cold.Seek(key)
+	hit.settle()
+}
+
+func (hit *hybridIterator) Next() {
+	if hit.fromHot {
+		hit.hot.Next()
+	} else {
+		hit.cold.Next()
+	}
+	hit.settle()
+}
+
+func (hit *hybridIterator) Valid() bool {
+	return hit.hot.Valid() || hit.cold.Valid()
+}
+
+func (hit *hybridIterator) Key() []byte {
+	if hit.fromHot {
+		return hit.hot.Key()
+	}
+	return hit.cold.Key()
+}
+
+func (hit *hybridIterator) Value() *data.LogRecordPos {
+	if hit.fromHot {
+		return hit.hot.Value()
+	}
+	return hit.cold.Value()
+}
+
+// The read transaction is released first, then the entries over the memory limit can be spilled
+func (hit *hybridIterator) Close() {
+	if hit.closed {
+		return
+	}
+	hit.closed = true
+	hit.hot.Close()
+	hit.cold.Close()
+
+	hit.hi.lock.Lock()
+	defer hit.hi.lock.Unlock()
+	hit.hi.iterators--
+	hit.hi.spill()
+}
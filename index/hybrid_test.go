@@ -0,0 +1,312 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Memory limit of about n entries of short keys
+func hybridTestLimit(n int) int64 {
+	return int64(n) * (hybridEntryOverhead + 16)
+}
+
+func TestHybridIndex_Spill(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "hybrid-spill")
+	_ = os.MkdirAll(path, os.ModePerm)
+
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	hi, err := NewHybridIndex(path, hybridTestLimit(100))
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		res := hi.Put([]byte(fmt.Sprintf("key-%05d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+		assert.Nil(t, res)
+	}
+	assert.Equal(t, 1000, hi.Size())
+	assert.LessOrEqual(t, hi.memoryUsage, hi.memoryLimit)
+	assert.Greater(t, hi.cold.Size(), 900)
+	// The least recently written keys are on the disk
+	assert.Nil(t, hi.entries["key-00000"])
+	assert.NotNil(t, hi.entries["key-00999"])
+
+	// Lookups fall through to the disk
+	pos := hi.Get([]byte("key-00000"))
+	assert.Equal(t, int64(0), pos.Offset)
+
+	// Keys on the disk are replaced and deleted in memory
+	res1 := hi.Put([]byte("key-00001"), &data.LogRecordPos{Fid: 2, Offset: 1})
+	assert.Equal(t, uint32(1), res1.Fid)
+	res2, ok := hi.Delete([]byte("key-00002"))
+	assert.True(t, ok)
+	assert.Equal(t, int64(2), res2.Offset)
+	assert.Nil(t, hi.Get([]byte("key-00002")))
+	_, ok = hi.Delete([]byte("key-00002"))
+	assert.False(t, ok)
+	assert.Equal(t, 999, hi.Size())
+
+	// Spill the changes
+	for i := 1000; i < 1200; i++ {
+		hi.Put([]byte(fmt.Sprintf("key-%05d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	assert.Nil(t, hi.entries["key-00001"])
+	assert.Nil(t, hi.entries["key-00002"])
+	assert.Equal(t, uint32(2), hi.Get([]byte("key-00001")).Fid)
+	assert.Nil(t, hi.Get([]byte("key-00002")))
+	assert.Equal(t, 1199, hi.Size())
+
+	// Delete a range across both tiers
+	positions := hi.DeleteRange([]byte("key-00100"), []byte("key-01100"))
+	assert.Equal(t, 1000, len(positions))
+	assert.Equal(t, 199, hi.Size())
+	assert.Nil(t, hi.Get([]byte("key-00500")))
+	assert.Nil(t, hi.Get([]byte("key-01050")))
+	assert.NotNil(t, hi.Get([]byte("key-01100")))
+
+	assert.Nil(t, hi.Close())
+	_, err = os.Stat(filepath.Join(path, HybridSpillFileName))
+	assert.True(t, os.IsNotExist(err))
+}
+
+// A key read often stays in memory though it is not written
+func TestHybridIndex_Get_Promote(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "hybrid-promote")
+	_ = os.MkdirAll(path, os.ModePerm)
+
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	hi, err := NewHybridIndex(path, hybridTestLimit(100))
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		hi.Put([]byte(fmt.Sprintf("key-%05d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	assert.Nil(t, hi.entries["key-00000"])
+
+	// The key read from the disk is moved to memory, the key passed to Get is not kept
+	key := []byte("key-00000")
+	pos := hi.Get(key)
+	assert.Equal(t, int64(0), pos.Offset)
+	key[0] = 'x'
+	entry := hi.entries["key-00000"]
+	assert.NotNil(t, entry)
+	assert.True(t, entry.clean)
+	_, ok := hi.hot.Get(Item{key: []byte("key-00000")})
+	assert.True(t, ok)
+	assert.Equal(t, 1000, hi.Size())
+
+	// Reading it keeps it in memory while other keys are written and spilled
+	for i := 1000; i < 2000; i++ {
+		hi.Put([]byte(fmt.Sprintf("key-%05d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+		assert.Equal(t, int64(0), hi.Get([]byte("key-00000")).Offset)
+	}
+	assert.NotNil(t, hi.entries["key-00000"])
+	assert.Nil(t, hi.entries["key-00001"])
+	assert.LessOrEqual(t, hi.memoryUsage, hi.memoryLimit)
+
+	// Changed after it is moved to memory, the change is spilled
+	hi.Put([]byte("key-00000"), &data.LogRecordPos{Fid: 2, Offset: 0})
+	assert.False(t, hi.entries["key-00000"].clean)
+	for i := 2000; i < 3000; i++ {
+		hi.Put([]byte(fmt.Sprintf("key-%05d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	assert.Nil(t, hi.entries["key-00000"])
+	assert.Equal(t, uint32(2), hi.cold.Get([]byte("key-00000")).Fid)
+	assert.Equal(t, 3000, hi.Size())
+	assert.Nil(t, hi.Close())
+}
+
+func TestHybridIndex_Random(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "hybrid-random")
+	_ = os.MkdirAll(path, os.ModePerm)
+
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	hi, err := NewHybridIndex(path, hybridTestLimit(50))
+	assert.Nil(t, err)
+	defer hi.Close()
+
+	ref := make(map[string]int64)
+	r := rand.New(rand.NewSource(1))
+	randKey := func() []byte {
+		return []byte(fmt.Sprintf("key-%03d", r.Intn(500)))
+	}
+	for i := 0; i < 20000; i++ {
+		key := randKey()
+		switch n := r.Intn(100); {
+		case n < 30:
+			pos, ok := hi.Delete(key)
+			offset, exist := ref[string(key)]
+			assert.Equal(t, exist, ok)
+			if exist {
+				assert.Equal(t, offset, pos.Offset)
+			}
+			delete(ref, string(key))
+		case n < 31:
+			end := randKey()
+			positions := hi.DeleteRange(key, end)
+			var deleted int
+			for k := range ref {
+				if k >= string(key) && k < string(end) {
+					delete(ref, k)
+					deleted++
+				}
+			}
+			assert.Equal(t, deleted, len(positions))
+		default:
+			pos := hi.Put(key, &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+			offset, exist := ref[string(key)]
+			if exist {
+				assert.Equal(t, offset, pos.Offset)
+			} else {
+				assert.Nil(t, pos)
+			}
+			ref[string(key)] = int64(i)
+		}
+	}
+	assert.Equal(t, len(ref), hi.Size())
+
+	var keys []string
+	for k, offset := range ref {
+		keys = append(keys, k)
+		assert.Equal(t, offset, hi.Get([]byte(k)).Offset)
+	}
+	sort.Strings(keys)
+
+	iter1 := hi.Iterator(false)
+	var forward []string
+	for iter1.Rewind(); iter1.Valid(); iter1.Next() {
+		forward = append(forward, string(iter1.Key()))
+		assert.Equal(t, ref[string(iter1.Key())], iter1.Value().Offset)
+	}
+	assert.Equal(t, keys, forward)
+
+	iter2 := hi.Iterator(true)
+	var reverse []string
+	for iter2.Rewind(); iter2.Valid(); iter2.Next() {
+		reverse = append(reverse, string(iter2.Key()))
+	}
+	assert.Equal(t, len(keys), len(reverse))
+	for i := range reverse {
+		assert.Equal(t, keys[len(keys) - 1 - i], reverse[i])
+	}
+
+	// Seek to random keys in both directions
+	for i := 0; i < 200; i++ {
+		target := randKey()
+		idx := sort.SearchStrings(keys, string(target))
+		iter1.Seek(target)
+		if idx < len(keys) {
+			assert.Equal(t, keys[idx], string(iter1.Key()))
+		} else {
+			assert.False(t, iter1.Valid())
+		}
+
+		iter2.Seek(target)
+		if idx < len(keys) && keys[idx] == string(target) {
+			assert.Equal(t, target, iter2.Key())
+		} else if idx > 0 {
+			assert.Equal(t, keys[idx - 1], string(iter2.Key()))
+		} else {
+			assert.False(t, iter2.Valid())
+		}
+	}
+	iter1.Close()
+	iter2.Close()
+}
+
+// The iterator walks the keys when it was created, even if they are spilled later
+func TestHybridIndex_Iterator_Snapshot(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "hybrid-iterator-snapshot")
+	_ = os.MkdirAll(path, os.ModePerm)
+
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	hi, err := NewHybridIndex(path, hybridTestLimit(1000))
+	assert.Nil(t, err)
+	defer hi.Close()
+
+	for i := 0; i < 100; i++ {
+		hi.Put([]byte(fmt.Sprintf("key-%03d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	iter := hi.Iterator(false)
+	for i := 0; i < 100; i += 2 {
+		hi.Put([]byte(fmt.Sprintf("key-%03d", i)), &data.LogRecordPos{Fid: 2, Offset: int64(i)})
+		hi.Delete([]byte(fmt.Sprintf("key-%03d", i + 1)))
+	}
+
+	var n int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, uint32(1), iter.Value().Fid)
+		n++
+	}
+	iter.Close()
+	assert.Equal(t, 100, n)
+	assert.Equal(t, 50, hi.Size())
+}
+
+// Writing while an iterator is open doesn't wait for the read transaction of the iterator
+func TestHybridIndex_Iterator_WriteWhileOpen(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "hybrid-iterator-write")
+	_ = os.MkdirAll(path, os.ModePerm)
+
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	hi, err := NewHybridIndex(path, hybridTestLimit(100))
+	assert.Nil(t, err)
+	defer hi.Close()
+
+	for i := 0; i < 1000; i++ {
+		hi.Put([]byte(fmt.Sprintf("key-%05d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	iter := hi.Iterator(false)
+
+	// Enough keys to grow the file of the B+ tree if they were spilled
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1000; i < 20000; i++ {
+			hi.Put([]byte(fmt.Sprintf("key-%05d", i)), &data.LogRecordPos{Fid: 2, Offset: int64(i)})
+		}
+		positions := hi.DeleteRange([]byte("key-00000"), []byte("key-00500"))
+		assert.Equal(t, 500, len(positions))
+	}()
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		// Release the read transaction, so the index can be closed
+		iter.Close()
+		t.Fatal("writing is blocked by the iterator")
+	}
+	assert.Equal(t, 19500, hi.Size())
+	assert.Nil(t, hi.Get([]byte("key-00000")))
+	assert.NotNil(t, hi.Get([]byte("key-00500")))
+
+	// The iterator walks the keys when it was created
+	var n int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, uint32(1), iter.Value().Fid)
+		n++
+	}
+	assert.Equal(t, 1000, n)
+
+	// The entries over the limit are spilled after the iterator is closed
+	assert.Greater(t, hi.MemoryUsage(), hi.memoryLimit)
+	iter.Close()
+	assert.LessOrEqual(t, hi.MemoryUsage(), hi.memoryLimit)
+	assert.Equal(t, 19500, hi.Size())
+	assert.Nil(t, hi.Get([]byte("key-00000")))
+	assert.Equal(t, int64(19999), hi.Get([]byte("key-19999")).Offset)
+}
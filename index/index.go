@@ -3,8 +3,7 @@ package index
 import (
 	"bitcask-go/data"
 	"bytes"
-
-	"github.com/google/btree"
+	"errors"
 )
 
 // Abstract indexer interface
@@ -19,9 +18,20 @@ type Indexer interface {
 	// Delete a key-value from keydir
 	Delete(key []byte) (*data.LogRecordPos, bool)
 
+	// Delete all the keys in [start, end) from keydir, return their positions
+	// Empty start means from the first key, empty end means to the last key
+	DeleteRange(start, end []byte) []*data.LogRecordPos
+
+	// Apply the changes in order as a whole, readers see none or all of them
+	// Return the position each change replaced or deleted, nil if the key didn't exist
+	ApplyBatch(ops []BatchOp) []*data.LogRecordPos
+
 	// Amount of data in the indexer
 	Size() int
 
+	// Bytes of memory used by the indexer, estimated from the number and the length of the keys
+	MemoryUsage() int64
+
 	// Get iterator
 	Iterator(reverse bool) Iterator
 
@@ -29,6 +39,15 @@ type Indexer interface {
 	Close() error
 }
 
+// A change of the index in a batch
+// Pos is nil to delete the key
+type BatchOp struct {
+	Key []byte
+	Pos *data.LogRecordPos
+}
+
+var ErrUnsupportedIndexType = errors.New("unsupported index type")
+
 type IndexType = int8
 const (
 	// BTree index
@@ -39,31 +58,75 @@ const (
 
 	// B+ tree
 	BPTree
+
+	// Btree in memory, spilled to a B+ tree on the disk
+	Hybrid
+
+	// Skiplist with lock-free reads
+	Skiplist
+
+	// Sharded hash map without order
+	Hash
 )
 
 // Initialize index according to the IndexType
-func NewIndexer(typ IndexType, dirPath string, sync bool) Indexer {
+// memoryLimit is only used by the hybrid index
+func NewIndexer(typ IndexType, dirPath string, sync bool, memoryLimit int64) (Indexer, error) {
 	switch typ {
 	case Btree:
-		return NewBTree()
+		return NewBTree(), nil
 	case ART:
-		return NewART()
+		return NewART(), nil
 	case BPTree:
-		return NewBPlusTree(dirPath, sync)
+		bptree, err := NewBPlusTree(dirPath, sync)
+		if err != nil {
+			return nil, err
+		}
+		return bptree, nil
+	case Hybrid:
+		hybrid, err := NewHybridIndex(dirPath, memoryLimit)
+		if err != nil {
+			return nil, err
+		}
+		return hybrid, nil
+	case Skiplist:
+		return NewSkipList(), nil
+	case Hash:
+		return NewHashIndex(), nil
 	default:
-		panic("unsupported index type")
+		return nil, ErrUnsupportedIndexType
 	}
 }
 
-// Type Item in BTree is an interface
+// Item of the btree
+// The btree keeps items in its nodes by value, and the position is in the item instead of a pointer to it
+// So a key costs no allocation besides the key itself, and the GC has less pointers to scan
 type Item struct {
 	key []byte
-	pos *data.LogRecordPos
+	pos data.LogRecordPos
+}
+
+func lessItem(a, b Item) bool {
+	return bytes.Compare(a.key, b.key) < 0
+}
+
+// Position of a deleted key, for the indexes keeping deleted keys for their iterators
+// Offsets of the records are never negative
+var deletedPos = data.LogRecordPos{Offset: -1}
+
+func isDeletedPos(pos data.LogRecordPos) bool {
+	return pos.Offset < 0
+}
+
+// Positions stored by value are copied for the callers, they must not point into the index
+func copyPos(pos data.LogRecordPos) *data.LogRecordPos {
+	return &pos
 }
 
-// Implement Less method in Item in BTree
-func (ai *Item) Less(bi btree.Item) bool {
-	return bytes.Compare(ai.key, bi.(*Item).key) == -1 // bi.(*Item) is an assertion
+// Judge whether key is smaller than the exclusive end of a range
+// Empty end means the range has no end
+func beforeEnd(key, end []byte) bool {
+	return len(end) == 0 || bytes.Compare(key, end) < 0
 }
 
 // Universal index iterator (for btree or other data structure)
@@ -89,4 +152,65 @@ type Iterator interface {
 
 	// Close iterator, release related resources
 	Close()
-}
\ No newline at end of file
+}
+// Iterator which only returns the keys in [lowerBound, upperBound)
+// Empty bound means no limit on that side
+type rangeIterator struct {
+	Iterator
+	reverse bool
+	lowerBound []byte
+	upperBound []byte
+}
+
+// Restrict iter to [lowerBound, upperBound)
+// Rewind seeks straight to the start of the range, and Valid returns false as soon as the key leaves the range
+func NewRangeIterator(iter Iterator, reverse bool, lowerBound, upperBound []byte) Iterator {
+	if len(lowerBound) == 0 && len(upperBound) == 0 {
+		return iter
+	}
+	ri := &rangeIterator{
+		Iterator: iter,
+		reverse: reverse,
+		lowerBound: lowerBound,
+		upperBound: upperBound,
+	}
+	ri.Rewind()
+	return ri
+}
+
+func (ri *rangeIterator) Rewind() {
+	if ri.reverse && len(ri.upperBound) != 0 {
+		// The first key smaller than the upper bound
+		ri.Iterator.Seek(ri.upperBound)
+		if ri.Iterator.Valid() && !beforeEnd(ri.Iterator.Key(), ri.upperBound) {
+			ri.Iterator.Next()
+		}
+		return
+	}
+	if !ri.reverse && len(ri.lowerBound) != 0 {
+		ri.Iterator.Seek(ri.lowerBound)
+		return
+	}
+	ri.Iterator.Rewind()
+}
+
+func (ri *rangeIterator) Seek(key []byte) {
+	// Keys out of the range are moved to the start of the range
+	if ri.reverse && len(ri.upperBound) != 0 && !beforeEnd(key, ri.upperBound) {
+		ri.Rewind()
+		return
+	}
+	if !ri.reverse && bytes.Compare(key, ri.lowerBound) < 0 {
+		ri.Rewind()
+		return
+	}
+	ri.Iterator.Seek(key)
+}
+
+func (ri *rangeIterator) Valid() bool {
+	if !ri.Iterator.Valid() {
+		return false
+	}
+	key := ri.Iterator.Key()
+	return bytes.Compare(key, ri.lowerBound) >= 0 && beforeEnd(key, ri.upperBound)
+}
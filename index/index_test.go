@@ -0,0 +1,200 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRangeIterator(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-range-iterator")
+	_ = os.MkdirAll(path, os.ModePerm)
+
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+
+	bptree, err := NewBPlusTree(path, false)
+	assert.Nil(t, err)
+	hybrid, err := NewHybridIndex(path, hybridTestLimit(2))
+	assert.Nil(t, err)
+	indexers := map[string]Indexer{
+		"btree": NewBTree(),
+		"art": NewART(),
+		"bptree": bptree,
+		"hybrid": hybrid,
+		"skiplist": NewSkipList(),
+		"hash": NewHashIndex(),
+	}
+	for name, indexer := range indexers {
+		for _, key := range []string{"a", "ab", "abc", "abd", "b", "ba", "c"} {
+			indexer.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: 10})
+		}
+
+		collect := func(iter Iterator) []string {
+			var keys []string
+			for ; iter.Valid(); iter.Next() {
+				keys = append(keys, string(iter.Key()))
+			}
+			iter.Close()
+			return keys
+		}
+
+		// 1. Lower bound and upper bound
+		iter1 := NewRangeIterator(indexer.Iterator(false), false, []byte("ab"), []byte("b"))
+		assert.Equal(t, []string{"ab", "abc", "abd"}, collect(iter1), name)
+
+		// 2. Reversed, the upper bound itself is excluded
+		iter2 := NewRangeIterator(indexer.Iterator(true), true, []byte("ab"), []byte("b"))
+		assert.Equal(t, []string{"abd", "abc", "ab"}, collect(iter2), name)
+
+		// 3. Reversed, the upper bound doesn't exist
+		iter3 := NewRangeIterator(indexer.Iterator(true), true, nil, []byte("bb"))
+		assert.Equal(t, []string{"ba", "b", "abd", "abc", "ab", "a"}, collect(iter3), name)
+
+		// 4. Only lower bound
+		iter4 := NewRangeIterator(indexer.Iterator(false), false, []byte("b"), nil)
+		assert.Equal(t, []string{"b", "ba", "c"}, collect(iter4), name)
+
+		// 5. Seek out of the range goes back to the start of the range
+		iter5 := NewRangeIterator(indexer.Iterator(false), false, []byte("ab"), []byte("b"))
+		iter5.Seek([]byte("a"))
+		assert.Equal(t, []string{"ab", "abc", "abd"}, collect(iter5), name)
+
+		iter6 := NewRangeIterator(indexer.Iterator(true), true, []byte("ab"), []byte("b"))
+		iter6.Seek([]byte("abc"))
+		assert.Equal(t, []string{"abc", "ab"}, collect(iter6), name)
+
+		// 6. Empty range
+		iter7 := NewRangeIterator(indexer.Iterator(false), false, []byte("x"), []byte("z"))
+		assert.False(t, iter7.Valid(), name)
+		iter7.Close()
+
+		_ = indexer.Close()
+	}
+}
+
+// Iterators of in-memory indexes walk a snapshot, Puts during the iteration are safe
+func TestIterator_ConcurrentPut(t *testing.T) {
+	indexers := map[string]Indexer{
+		"btree": NewBTree(),
+		"art": NewART(),
+		"skiplist": NewSkipList(),
+		"hash": NewHashIndex(),
+	}
+	for name, indexer := range indexers {
+		for i := 0; i < 10000; i++ {
+			indexer.Put([]byte(fmt.Sprintf("key-%05d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+		}
+
+		iter := indexer.Iterator(false)
+		wg := new(sync.WaitGroup)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10000; i++ {
+				indexer.Put([]byte(fmt.Sprintf("key-%05d", i)), &data.LogRecordPos{Fid: 2, Offset: int64(i)})
+				indexer.Delete([]byte(fmt.Sprintf("key-%05d", (i + 5000) % 10000)))
+			}
+		}()
+
+		var n int
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			assert.Equal(t, uint32(1), iter.Value().Fid, name)
+			n++
+		}
+		iter.Close()
+		wg.Wait()
+		assert.Equal(t, 10000, n, name)
+	}
+}
+
+// Memory usage grows with the keys, and goes back when they are deleted
+func TestIndexer_MemoryUsage(t *testing.T) {
+	indexers := map[string]Indexer{
+		"btree": NewBTree(),
+		"art": NewART(),
+		"skiplist": NewSkipList(),
+		"hash": NewHashIndex(),
+	}
+	for name, indexer := range indexers {
+		assert.Equal(t, int64(0), indexer.MemoryUsage(), name)
+		for i := 0; i < 1000; i++ {
+			indexer.Put([]byte(fmt.Sprintf("key-%05d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+		}
+		usage := indexer.MemoryUsage()
+		assert.Greater(t, usage, int64(1000 * len("key-00000")), name)
+
+		// Replacing a key uses no more memory
+		for i := 0; i < 1000; i++ {
+			indexer.Put([]byte(fmt.Sprintf("key-%05d", i)), &data.LogRecordPos{Fid: 2, Offset: int64(i)})
+		}
+		assert.Equal(t, usage, indexer.MemoryUsage(), name)
+
+		for i := 0; i < 500; i++ {
+			indexer.Delete([]byte(fmt.Sprintf("key-%05d", i)))
+		}
+		assert.Equal(t, usage / 2, indexer.MemoryUsage(), name)
+		indexer.DeleteRange(nil, nil)
+		assert.Equal(t, int64(0), indexer.MemoryUsage(), name)
+		_ = indexer.Close()
+	}
+}
+
+func TestIndexer_ApplyBatch(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-apply-batch")
+	_ = os.MkdirAll(path, os.ModePerm)
+
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+
+	bptree, err := NewBPlusTree(path, false)
+	assert.Nil(t, err)
+	hybrid, err := NewHybridIndex(path, hybridTestLimit(2))
+	assert.Nil(t, err)
+	indexers := map[string]Indexer{
+		"btree": NewBTree(),
+		"art": NewART(),
+		"bptree": bptree,
+		"hybrid": hybrid,
+		"skiplist": NewSkipList(),
+		"hash": NewHashIndex(),
+	}
+	for name, indexer := range indexers {
+		indexer.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 10})
+		indexer.Put([]byte("b"), &data.LogRecordPos{Fid: 1, Offset: 20})
+
+		oldPositions := indexer.ApplyBatch([]BatchOp{
+			{Key: []byte("a"), Pos: &data.LogRecordPos{Fid: 2, Offset: 10}},
+			{Key: []byte("b")},
+			{Key: []byte("c"), Pos: &data.LogRecordPos{Fid: 2, Offset: 20}},
+			// Later changes of a key see the earlier ones
+			{Key: []byte("c"), Pos: &data.LogRecordPos{Fid: 2, Offset: 30}},
+			{Key: []byte("d")},
+		})
+		assert.Equal(t, 5, len(oldPositions), name)
+		assert.Equal(t, int64(10), oldPositions[0].Offset, name)
+		assert.Equal(t, uint32(1), oldPositions[0].Fid, name)
+		assert.Equal(t, int64(20), oldPositions[1].Offset, name)
+		assert.Nil(t, oldPositions[2], name)
+		assert.Equal(t, int64(20), oldPositions[3].Offset, name)
+		assert.Nil(t, oldPositions[4], name)
+
+		assert.Equal(t, uint32(2), indexer.Get([]byte("a")).Fid, name)
+		assert.Nil(t, indexer.Get([]byte("b")), name)
+		assert.Equal(t, int64(30), indexer.Get([]byte("c")).Offset, name)
+		assert.Equal(t, 2, indexer.Size(), name)
+
+		// An empty batch changes nothing
+		assert.Equal(t, 0, len(indexer.ApplyBatch(nil)), name)
+		assert.Equal(t, 2, indexer.Size(), name)
+
+		_ = indexer.Close()
+	}
+}
@@ -0,0 +1,442 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	skiplistMaxLevel = 20
+	// 1 in skiplistBranching nodes of a level is also in the level above
+	skiplistBranching = 4
+	// Approximate memory used by a node besides its key
+	// The node with its next pointers, and the newest version
+	skiplistNodeOverhead = 112
+)
+
+// Concurrent skiplist
+// Readers don't lock, writers are serialized by a lock
+// Every write has a sequence number, a key keeps the older versions still visible to the iterators
+// So an iterator walks a snapshot of the skiplist like the other in-memory indexes, and Get never sees a write in progress
+type SkipList struct {
+	head *skiplistNode
+	level atomic.Int32                        // Number of levels in use
+	seq atomic.Uint64                         // Sequence number of the last write
+	size atomic.Int64
+	memoryUsage atomic.Int64
+	lock *sync.Mutex
+	rand *rand.Rand
+	snapshots map[uint64]int                  // Sequence numbers of the open iterators and the write in progress
+	pending map[*skiplistNode]struct{}        // Nodes keeping versions or deleted keys for the iterators
+}
+
+type skiplistNode struct {
+	key []byte
+	version atomic.Pointer[skiplistVersion]   // The newest version
+	next []atomic.Pointer[skiplistNode]
+}
+
+type skiplistVersion struct {
+	pos data.LogRecordPos                     // deletedPos if the key is deleted
+	seq uint64
+	older atomic.Pointer[skiplistVersion]
+}
+
+// Initialize a SkipList
+func NewSkipList() *SkipList {
+	sl := &SkipList{
+		head: &skiplistNode{next: make([]atomic.Pointer[skiplistNode], skiplistMaxLevel)},
+		lock: new(sync.Mutex),
+		rand: rand.New(rand.NewSource(1)),
+		snapshots: make(map[uint64]int),
+		pending: make(map[*skiplistNode]struct{}),
+	}
+	sl.level.Store(1)
+	return sl
+}
+
+func (sl *SkipList) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	var oldPos *data.LogRecordPos
+	sl.write(func(seq uint64) {
+		oldPos = sl.put(key, pos, seq)
+	})
+	return oldPos
+}
+
+// Get reads the newest version up to the sequence number of the last write without locking
+// The versions of a write in progress are not seen, so a Get sees none or all of the changes of a batch
+func (sl *SkipList) Get(key []byte) *data.LogRecordPos {
+	for {
+		seq := sl.seq.Load()
+		_, node := sl.find(key)
+		if node == nil {
+			return nil
+		}
+		if version := node.versionAt(seq); version != nil {
+			if isDeletedPos(version.pos) {
+				return nil
+			}
+			return &version.pos
+		}
+		// All the versions are newer than seq
+		// The key is added by the write in progress, or the older versions are dropped after a write is done
+		if sl.seq.Load() == seq {
+			return nil
+		}
+	}
+}
+
+func (sl *SkipList) Delete(key []byte) (*data.LogRecordPos, bool) {
+	var oldPos *data.LogRecordPos
+	sl.write(func(seq uint64) {
+		oldPos = sl.delete(key, seq)
+	})
+	return oldPos, oldPos != nil
+}
+
+// All the changes have the same sequence number, Get and iterators see none or all of them
+func (sl *SkipList) ApplyBatch(ops []BatchOp) []*data.LogRecordPos {
+	oldPositions := make([]*data.LogRecordPos, len(ops))
+	sl.write(func(seq uint64) {
+		for i, op := range ops {
+			if op.Pos == nil {
+				oldPositions[i] = sl.delete(op.Key, seq)
+			} else {
+				oldPositions[i] = sl.put(op.Key, op.Pos, seq)
+			}
+		}
+	})
+	return oldPositions
+}
+
+func (sl *SkipList) DeleteRange(start, end []byte) []*data.LogRecordPos {
+	var positions []*data.LogRecordPos
+	sl.write(func(seq uint64) {
+		// Deleting may unlink the node, collect the keys in the range first
+		var keys [][]byte
+		preds, _ := sl.find(start)
+		for node := preds[0].next[0].Load(); node != nil && beforeEnd(node.key, end); node = node.next[0].Load() {
+			if !isDeletedPos(node.version.Load().pos) {
+				keys = append(keys, node.key)
+			}
+		}
+		for _, key := range keys {
+			positions = append(positions, sl.delete(key, seq))
+		}
+	})
+	return positions
+}
+
+// Make the changes of fn with the next sequence number, then publish it
+// The versions Get may read are kept like for an iterator of the last sequence number until the changes are published
+func (sl *SkipList) write(fn func(seq uint64)) {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+	seq := sl.seq.Load()
+	sl.snapshots[seq]++
+	fn(seq + 1)
+	sl.seq.Store(seq + 1)
+	sl.releaseSnapshotLocked(seq)
+}
+
+func (sl *SkipList) Size() int {
+	return int(sl.size.Load())
+}
+
+// The older versions kept for the iterators are not counted
+func (sl *SkipList) MemoryUsage() int64 {
+	return sl.memoryUsage.Load()
+}
+
+func (sl *SkipList) Iterator(reverse bool) Iterator {
+	sl.lock.Lock()
+	seq := sl.seq.Load()
+	sl.snapshots[seq]++
+	sl.lock.Unlock()
+
+	sli := &skiplistIterator{sl: sl, seq: seq, reverse: reverse}
+	sli.Rewind()
+	return sli
+}
+
+func (sl *SkipList) Close() error {
+	return nil
+}
+
+// Find the last node before key in each level, and the node of key
+// Readers use it without the lock, a node is linked to the levels from the bottom
+func (sl *SkipList) find(key []byte) ([skiplistMaxLevel]*skiplistNode, *skiplistNode) {
+	var preds [skiplistMaxLevel]*skiplistNode
+	var next *skiplistNode
+	pred := sl.head
+	for i := int(sl.level.Load()) - 1; i >= 0; i-- {
+		for next = pred.next[i].Load(); next != nil && bytes.Compare(next.key, key) < 0; next = pred.next[i].Load() {
+			pred = next
+		}
+		preds[i] = pred
+	}
+	// The node compared in the bottom level, loading it again may get a node inserted before key
+	if next != nil && bytes.Equal(next.key, key) {
+		return preds, next
+	}
+	return preds, nil
+}
+
+// The last node before key, nil if there is none
+func (sl *SkipList) findLess(key []byte) *skiplistNode {
+	preds, _ := sl.find(key)
+	if preds[0] == sl.head {
+		return nil
+	}
+	return preds[0]
+}
+
+// Must have lock when using this method
+func (sl *SkipList) put(key []byte, pos *data.LogRecordPos, seq uint64) *data.LogRecordPos {
+	preds, node := sl.find(key)
+	if node != nil {
+		newest := node.version.Load()
+		sl.addVersion(node, &skiplistVersion{pos: *pos, seq: seq})
+		if isDeletedPos(newest.pos) {
+			sl.size.Add(1)
+			return nil
+		}
+		return &newest.pos
+	}
+
+	level := sl.randomLevel()
+	if current := int(sl.level.Load()); level > current {
+		for i := current; i < level; i++ {
+			preds[i] = sl.head
+		}
+		sl.level.Store(int32(level))
+	}
+	node = &skiplistNode{key: key, next: make([]atomic.Pointer[skiplistNode], level)}
+	node.version.Store(&skiplistVersion{pos: *pos, seq: seq})
+	for i := 0; i < level; i++ {
+		node.next[i].Store(preds[i].next[i].Load())
+		preds[i].next[i].Store(node)
+	}
+	sl.size.Add(1)
+	sl.memoryUsage.Add(int64(len(key)) + skiplistNodeOverhead)
+	return nil
+}
+
+// Must have lock when using this method
+func (sl *SkipList) delete(key []byte, seq uint64) *data.LogRecordPos {
+	_, node := sl.find(key)
+	if node == nil {
+		return nil
+	}
+	newest := node.version.Load()
+	if isDeletedPos(newest.pos) {
+		return nil
+	}
+	sl.size.Add(-1)
+	if len(sl.snapshots) == 0 {
+		sl.unlink(node)
+		return &newest.pos
+	}
+	// Iterators may still see the key, unlink it when they are closed
+	sl.addVersion(node, &skiplistVersion{pos: deletedPos, seq: seq})
+	return &newest.pos
+}
+
+// Make version the newest one, and drop the versions no iterator sees
+// Must have lock when using this method
+func (sl *SkipList) addVersion(node *skiplistNode, version *skiplistVersion) {
+	version.older.Store(node.version.Load())
+	node.version.Store(version)
+	if len(sl.snapshots) == 0 {
+		version.older.Store(nil)
+		return
+	}
+	sl.prune(node, sl.oldestSnapshot())
+	sl.pending[node] = struct{}{}
+}
+
+// The versions older than the one seen by the oldest iterator are not seen by any iterator
+// Must have lock when using this method
+func (sl *SkipList) prune(node *skiplistNode, oldest uint64) {
+	for version := node.version.Load(); version != nil; version = version.older.Load() {
+		if version.seq <= oldest {
+			version.older.Store(nil)
+			return
+		}
+	}
+}
+
+// Must have lock when using this method
+func (sl *SkipList) unlink(node *skiplistNode) {
+	preds, _ := sl.find(node.key)
+	// From the top, so the node stays reachable from the levels below while unlinking
+	for i := len(node.next) - 1; i >= 0; i-- {
+		if preds[i].next[i].Load() == node {
+			preds[i].next[i].Store(node.next[i].Load())
+		}
+	}
+	sl.memoryUsage.Add(-int64(len(node.key)) - skiplistNodeOverhead)
+}
+
+// Must have lock when using this method
+func (sl *SkipList) oldestSnapshot() uint64 {
+	oldest := sl.seq.Load()
+	for seq := range sl.snapshots {
+		if seq < oldest {
+			oldest = seq
+		}
+	}
+	return oldest
+}
+
+// An iterator is closed, drop the versions and the deleted keys no iterator sees any more
+func (sl *SkipList) releaseSnapshot(seq uint64) {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+	sl.releaseSnapshotLocked(seq)
+}
+
+// Must have lock when using this method
+func (sl *SkipList) releaseSnapshotLocked(seq uint64) {
+	sl.snapshots[seq]--
+	if sl.snapshots[seq] == 0 {
+		delete(sl.snapshots, seq)
+	}
+	if len(sl.snapshots) > 0 && sl.oldestSnapshot() <= seq {
+		// The versions seen by the oldest snapshot are kept already
+		return
+	}
+	oldest := sl.oldestSnapshot()
+	for node := range sl.pending {
+		newest := node.version.Load()
+		if isDeletedPos(newest.pos) && newest.seq <= oldest {
+			sl.unlink(node)
+			delete(sl.pending, node)
+			continue
+		}
+		sl.prune(node, oldest)
+		if newest.older.Load() == nil {
+			delete(sl.pending, node)
+		}
+	}
+}
+
+// Must have lock when using this method
+func (sl *SkipList) randomLevel() int {
+	level := 1
+	for level < skiplistMaxLevel && sl.rand.Intn(skiplistBranching) == 0 {
+		level++
+	}
+	return level
+}
+
+// Skiplist iterator
+// Walk the versions with sequence numbers up to seq
+type skiplistIterator struct {
+	sl *SkipList
+	seq uint64
+	reverse bool
+	node *skiplistNode
+	version *skiplistVersion
+	closed bool
+}
+
+// The newest version with a sequence number up to seq, nil if all the versions kept are newer
+func (node *skiplistNode) versionAt(seq uint64) *skiplistVersion {
+	version := node.version.Load()
+	for version != nil && version.seq > seq {
+		version = version.older.Load()
+	}
+	return version
+}
+
+// The version of node seen by the iterator, nil if the key doesn't exist in the snapshot
+func (sli *skiplistIterator) visible(node *skiplistNode) *skiplistVersion {
+	version := node.versionAt(sli.seq)
+	if version == nil || isDeletedPos(version.pos) {
+		return nil
+	}
+	return version
+}
+
+// Stop at node or the next node after it existing in the snapshot
+func (sli *skiplistIterator) settle(node *skiplistNode) {
+	for node != nil {
+		if version := sli.visible(node); version != nil {
+			sli.node, sli.version = node, version
+			return
+		}
+		node = sli.step(node)
+	}
+	sli.node, sli.version = nil, nil
+}
+
+// The node after node in the order of the iterator
+func (sli *skiplistIterator) step(node *skiplistNode) *skiplistNode {
+	if sli.reverse {
+		return sli.sl.findLess(node.key)
+	}
+	return node.next[0].Load()
+}
+
+func (sli *skiplistIterator) Rewind() {
+	if !sli.reverse {
+		sli.settle(sli.sl.head.next[0].Load())
+		return
+	}
+	// The last node
+	var last *skiplistNode
+	pred := sli.sl.head
+	for i := int(sli.sl.level.Load()) - 1; i >= 0; i-- {
+		for next := pred.next[i].Load(); next != nil; next = pred.next[i].Load() {
+			pred = next
+			last = next
+		}
+	}
+	sli.settle(last)
+}
+
+func (sli *skiplistIterator) Seek(key []byte) {
+	preds, node := sli.sl.find(key)
+	if node == nil {
+		node = preds[0].next[0].Load()
+	}
+	if sli.reverse && (node == nil || !bytes.Equal(node.key, key)) {
+		// In reverse mode, it finds the first key smaller than or equal to key
+		node = preds[0]
+		if node == sli.sl.head {
+			node = nil
+		}
+	}
+	sli.settle(node)
+}
+
+func (sli *skiplistIterator) Next() {
+	if sli.node != nil {
+		sli.settle(sli.step(sli.node))
+	}
+}
+
+func (sli *skiplistIterator) Valid() bool {
+	return sli.node != nil
+}
+
+func (sli *skiplistIterator) Key() []byte {
+	return sli.node.key
+}
+
+func (sli *skiplistIterator) Value() *data.LogRecordPos {
+	return &sli.version.pos
+}
+
+func (sli *skiplistIterator) Close() {
+	if sli.closed {
+		return
+	}
+	sli.closed = true
+	sli.node, sli.version = nil, nil
+	sli.sl.releaseSnapshot(sli.seq)
+}
@@ -0,0 +1,307 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSkipList_Put(t *testing.T) {
+	sl := NewSkipList()
+	res1 := sl.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 12})
+	assert.Nil(t, res1)
+	res2 := sl.Put([]byte("key-2"), &data.LogRecordPos{Fid: 1, Offset: 12})
+	assert.Nil(t, res2)
+
+	res3 := sl.Put([]byte("key-2"), &data.LogRecordPos{Fid: 2, Offset: 13})
+	assert.Equal(t, uint32(1), res3.Fid)
+	assert.Equal(t, int64(12), res3.Offset)
+	assert.Equal(t, 2, sl.Size())
+
+	pos := sl.Get([]byte("key-2"))
+	assert.Equal(t, uint32(2), pos.Fid)
+	assert.Nil(t, sl.Get([]byte("key-3")))
+}
+
+func TestSkipList_Delete(t *testing.T) {
+	sl := NewSkipList()
+	_, ok1 := sl.Delete([]byte("not exist"))
+	assert.False(t, ok1)
+
+	sl.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 12})
+	res2, ok2 := sl.Delete([]byte("key-1"))
+	assert.True(t, ok2)
+	assert.Equal(t, int64(12), res2.Offset)
+	assert.Nil(t, sl.Get([]byte("key-1")))
+	assert.Equal(t, 0, sl.Size())
+
+	// Deleted while an iterator is open, the key is kept until the iterator is closed
+	sl.Put([]byte("key-2"), &data.LogRecordPos{Fid: 1, Offset: 12})
+	iter := sl.Iterator(false)
+	_, ok3 := sl.Delete([]byte("key-2"))
+	assert.True(t, ok3)
+	_, ok4 := sl.Delete([]byte("key-2"))
+	assert.False(t, ok4)
+	assert.Nil(t, sl.Get([]byte("key-2")))
+	assert.Equal(t, 0, sl.Size())
+	assert.Equal(t, []byte("key-2"), iter.Key())
+
+	iter.Close()
+	assert.Equal(t, 0, len(sl.pending))
+	_, node := sl.find([]byte("key-2"))
+	assert.Nil(t, node)
+}
+
+func TestSkipList_Random(t *testing.T) {
+	sl := NewSkipList()
+	ref := make(map[string]int64)
+	r := rand.New(rand.NewSource(1))
+	randKey := func() []byte {
+		return []byte(fmt.Sprintf("key-%03d", r.Intn(500)))
+	}
+
+	// Iterators are opened and closed during the changes, to keep older versions around
+	var iters []Iterator
+	for i := 0; i < 20000; i++ {
+		key := randKey()
+		switch n := r.Intn(100); {
+		case n < 30:
+			pos, ok := sl.Delete(key)
+			offset, exist := ref[string(key)]
+			assert.Equal(t, exist, ok)
+			if exist {
+				assert.Equal(t, offset, pos.Offset)
+			}
+			delete(ref, string(key))
+		case n < 31:
+			end := randKey()
+			positions := sl.DeleteRange(key, end)
+			var deleted int
+			for k := range ref {
+				if k >= string(key) && k < string(end) {
+					delete(ref, k)
+					deleted++
+				}
+			}
+			assert.Equal(t, deleted, len(positions))
+		case n < 32:
+			iters = append(iters, sl.Iterator(r.Intn(2) == 0))
+		case n < 33:
+			if len(iters) > 0 {
+				iters[0].Close()
+				iters = iters[1:]
+			}
+		default:
+			pos := sl.Put(key, &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+			offset, exist := ref[string(key)]
+			if exist {
+				assert.Equal(t, offset, pos.Offset)
+			} else {
+				assert.Nil(t, pos)
+			}
+			ref[string(key)] = int64(i)
+		}
+	}
+	for _, iter := range iters {
+		iter.Close()
+	}
+	assert.Equal(t, len(ref), sl.Size())
+	// Nothing is kept for the iterators after they are closed
+	assert.Equal(t, 0, len(sl.pending))
+
+	var keys []string
+	for k, offset := range ref {
+		keys = append(keys, k)
+		assert.Equal(t, offset, sl.Get([]byte(k)).Offset)
+	}
+	sort.Strings(keys)
+
+	iter1 := sl.Iterator(false)
+	var forward []string
+	for iter1.Rewind(); iter1.Valid(); iter1.Next() {
+		forward = append(forward, string(iter1.Key()))
+		assert.Equal(t, ref[string(iter1.Key())], iter1.Value().Offset)
+	}
+	assert.Equal(t, keys, forward)
+
+	iter2 := sl.Iterator(true)
+	var reverse []string
+	for iter2.Rewind(); iter2.Valid(); iter2.Next() {
+		reverse = append(reverse, string(iter2.Key()))
+	}
+	assert.Equal(t, len(keys), len(reverse))
+	for i := range reverse {
+		assert.Equal(t, keys[len(keys) - 1 - i], reverse[i])
+	}
+
+	// Seek to random keys in both directions
+	for i := 0; i < 200; i++ {
+		target := randKey()
+		idx := sort.SearchStrings(keys, string(target))
+		iter1.Seek(target)
+		if idx < len(keys) {
+			assert.Equal(t, keys[idx], string(iter1.Key()))
+		} else {
+			assert.False(t, iter1.Valid())
+		}
+
+		iter2.Seek(target)
+		if idx < len(keys) && keys[idx] == string(target) {
+			assert.Equal(t, target, iter2.Key())
+		} else if idx > 0 {
+			assert.Equal(t, keys[idx - 1], string(iter2.Key()))
+		} else {
+			assert.False(t, iter2.Valid())
+		}
+	}
+	iter1.Close()
+	iter2.Close()
+}
+
+func TestSkipList_Iterator_Snapshot(t *testing.T) {
+	sl := NewSkipList()
+	for i := 0; i < 100; i++ {
+		sl.Put([]byte(fmt.Sprintf("key-%03d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	iter1 := sl.Iterator(false)
+	for i := 0; i < 100; i += 2 {
+		sl.Delete([]byte(fmt.Sprintf("key-%03d", i)))
+	}
+	sl.Put([]byte("key-050"), &data.LogRecordPos{Fid: 2, Offset: 0})
+	sl.Put([]byte("key-new"), &data.LogRecordPos{Fid: 2, Offset: 0})
+	iter2 := sl.Iterator(true)
+	sl.Put([]byte("key-051"), &data.LogRecordPos{Fid: 3, Offset: 0})
+
+	// Changes after the iterator is created are not visible to it
+	var n int
+	var prev []byte
+	for iter1.Rewind(); iter1.Valid(); iter1.Next() {
+		assert.Equal(t, uint32(1), iter1.Value().Fid)
+		assert.True(t, bytes.Compare(prev, iter1.Key()) < 0)
+		prev = iter1.Key()
+		n++
+	}
+	assert.Equal(t, 100, n)
+	iter1.Close()
+
+	// The second iterator still sees its own snapshot after the first one is closed
+	var keys []string
+	for iter2.Rewind(); iter2.Valid(); iter2.Next() {
+		keys = append(keys, string(iter2.Key()))
+		if string(iter2.Key()) == "key-051" {
+			assert.Equal(t, uint32(1), iter2.Value().Fid)
+		}
+	}
+	assert.Equal(t, 52, len(keys))
+	assert.Equal(t, "key-new", keys[0])
+	iter2.Close()
+
+	assert.Equal(t, 52, sl.Size())
+	assert.Equal(t, uint32(2), sl.Get([]byte("key-050")).Fid)
+	assert.Equal(t, uint32(3), sl.Get([]byte("key-051")).Fid)
+}
+
+// Gets don't wait for the writer, and always see a whole position
+func TestSkipList_ConcurrentGet(t *testing.T) {
+	sl := NewSkipList()
+	for i := 0; i < 1000; i += 2 {
+		sl.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	wg := new(sync.WaitGroup)
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20000; i++ {
+				k := i % 1000
+				pos := sl.Get([]byte(fmt.Sprintf("key-%04d", k)))
+				// The even keys are never deleted
+				if k % 2 == 0 {
+					assert.NotNil(t, pos)
+				}
+				if pos != nil {
+					assert.Equal(t, int64(k), pos.Offset)
+				}
+			}
+		}()
+	}
+	for i := 0; i < 20000; i++ {
+		k := i % 1000
+		key := []byte(fmt.Sprintf("key-%04d", k))
+		if k % 2 == 0 {
+			sl.Put(key, &data.LogRecordPos{Fid: 2, Offset: int64(k)})
+		} else if i % 3 == 0 {
+			sl.Delete(key)
+		} else {
+			sl.Put(key, &data.LogRecordPos{Fid: 2, Offset: int64(k)})
+		}
+	}
+	wg.Wait()
+	for k := 0; k < 1000; k += 2 {
+		assert.Equal(t, uint32(2), sl.Get([]byte(fmt.Sprintf("key-%04d", k))).Fid)
+	}
+}
+
+// A Get during a batch sees none or all of its changes
+func TestSkipList_ConcurrentApplyBatch(t *testing.T) {
+	sl := NewSkipList()
+	sl.ApplyBatch([]BatchOp{
+		{Key: []byte("a"), Pos: &data.LogRecordPos{Fid: 0}},
+		{Key: []byte("key-0"), Pos: &data.LogRecordPos{Fid: 0}},
+		{Key: []byte("b"), Pos: &data.LogRecordPos{Fid: 0}},
+	})
+
+	const batches = 5000
+	done := make(chan struct{})
+	wg := new(sync.WaitGroup)
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				// Batch n writes a, adds key-n, then writes b
+				a := sl.Get([]byte("a"))
+				if !assert.NotNil(t, a) {
+					return
+				}
+				assert.NotNil(t, sl.Get([]byte(fmt.Sprintf("key-%d", a.Fid))))
+				b := sl.Get([]byte("b"))
+				if !assert.NotNil(t, b) || !assert.GreaterOrEqual(t, b.Fid, a.Fid) {
+					return
+				}
+			}
+		}()
+	}
+	for n := 1; n <= batches; n++ {
+		pos := &data.LogRecordPos{Fid: uint32(n)}
+		ops := []BatchOp{
+			{Key: []byte("a"), Pos: pos},
+			{Key: []byte(fmt.Sprintf("key-%d", n)), Pos: pos},
+			// Deleted and added again in the same batch, it is never missing
+			{Key: []byte("key-0"), Pos: nil},
+			{Key: []byte("key-0"), Pos: pos},
+		}
+		// Make the batch longer, so the Gets run during it
+		for i := 0; i < 32; i++ {
+			ops = append(ops, BatchOp{Key: []byte(fmt.Sprintf("other-%d", i)), Pos: pos})
+		}
+		ops = append(ops, BatchOp{Key: []byte("b"), Pos: pos})
+		sl.ApplyBatch(ops)
+	}
+	close(done)
+	wg.Wait()
+	assert.Equal(t, batches + 3 + 32, sl.Size())
+}
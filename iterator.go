@@ -2,6 +2,7 @@ package kvproject
 
 import (
 	"bitcask-go/index"
+	"bitcask-go/utils"
 	"bytes"
 )
 
@@ -10,39 +11,63 @@ type Iterator struct {
 	indexIter index.Iterator
 	db *DB
 	options IteratorOptions
+	count int                  // Number of keys traversed since Rewind or Seek, used by Limit
+	fids []uint32              // Data files referenced by the iterator, so merge doesn't delete them
 }
 
 // Initialize iterator
 func (db *DB) NewIterator(opts IteratorOptions) *Iterator {
-	indexIter := db.index.Iterator(opts.Reverse)
-	return &Iterator{
+	// Prefix is a range too: [prefix, end of prefix)
+	lowerBound, upperBound := opts.LowerBound, opts.UpperBound
+	if len(opts.Prefix) > 0 {
+		if bytes.Compare(opts.Prefix, lowerBound) > 0 {
+			lowerBound = opts.Prefix
+		}
+		prefixEnd := utils.PrefixEnd(opts.Prefix)
+		if len(prefixEnd) > 0 && (len(upperBound) == 0 || bytes.Compare(prefixEnd, upperBound) < 0) {
+			upperBound = prefixEnd
+		}
+	}
+
+	// The positions in the snapshot of the index are in the files referenced at the same time
+	db.mu.RLock()
+	indexIter := index.NewRangeIterator(db.index.Iterator(opts.Reverse), opts.Reverse, lowerBound, upperBound)
+	fids := db.refDataFiles()
+	db.mu.RUnlock()
+	it := &Iterator{
 		db: db,
 		indexIter: indexIter,
 		options: opts,
+		fids: fids,
 	}
+	it.Rewind()
+	return it
 }
 
 // Return to the beginning of the iterator ie. the first data
 func (it *Iterator) Rewind() {
 	it.indexIter.Rewind()
-	it.skipToNext()
+	it.count = 0
 }
 
 // According to the parameter key, find the first key which is bigger or smaller than it
 // Start iterate from here
 func (it *Iterator) Seek(key []byte) {
 	it.indexIter.Seek(key)
-	it.skipToNext()
+	it.count = 0
 }
 
 // Go to the next key
 func (it *Iterator) Next() {
 	it.indexIter.Next()
-	it.skipToNext()
+	it.count++
 }
 
 // Iterate over all the keys?
 func (it *Iterator) Valid() bool{
+	if it.options.Limit > 0 && it.count >= it.options.Limit {
+		return false
+	}
 	return it.indexIter.Valid()
 }
 
@@ -59,22 +84,18 @@ func (it *Iterator) Value() ([]byte, error) {
 	return it.db.getValueByPosition(logRecordPos)
 }
 
+// Call fn with the value of the current iteration place
+// Like DB.GetFunc, value is only valid in fn, it must not be modified or used after fn returns
+func (it *Iterator) ValueFunc(fn func(value []byte) error) error {
+	logRecordPos := it.indexIter.Value()
+	it.db.mu.RLock()
+	defer it.db.mu.RUnlock()
+	return it.db.viewValueByPosition(logRecordPos, fn)
+}
+
 // Close iterator, release related resources
 func (it *Iterator) Close() {
 	it.indexIter.Close()
-}
-
-// Find the next key with the specified prefix
-func (it *Iterator) skipToNext() {
-	prefixLen := len(it.options.Prefix)
-	if prefixLen == 0 {
-		return
-	}
-
-	for ; it.indexIter.Valid(); it.indexIter.Next() {
-		key := it.indexIter.Key()
-		if prefixLen <= len(key) && bytes.Equal(it.options.Prefix, key[:prefixLen]) {
-			break
-		}
-	}
+	it.db.unrefDataFiles(it.fids)
+	it.fids = nil
 }
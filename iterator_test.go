@@ -116,3 +116,103 @@ func TestDB_Iterator_Many_Values(t *testing.T) {
 
 }
 
+
+func TestDB_Iterator_Bounds(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-iterator-4")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(10))
+		assert.Nil(t, err)
+	}
+	err = db.Put([]byte("zz-1"), utils.RandomValue(10))
+	assert.Nil(t, err)
+	err = db.Put([]byte("zz-2"), utils.RandomValue(10))
+	assert.Nil(t, err)
+
+	count := func(iter *Iterator) int {
+		var n int
+		for ; iter.Valid(); iter.Next() {
+			n++
+		}
+		iter.Close()
+		return n
+	}
+
+	// 1. [10, 20)
+	iterOpts1 := DefaultIteratorOptions
+	iterOpts1.LowerBound = utils.GetTestKey(10)
+	iterOpts1.UpperBound = utils.GetTestKey(20)
+	iter1 := db.NewIterator(iterOpts1)
+	assert.Equal(t, utils.GetTestKey(10), iter1.Key())
+	assert.Equal(t, 10, count(iter1))
+
+	// 2. Reversed [10, 20)
+	iterOpts2 := iterOpts1
+	iterOpts2.Reverse = true
+	iter2 := db.NewIterator(iterOpts2)
+	assert.Equal(t, utils.GetTestKey(19), iter2.Key())
+	assert.Equal(t, 10, count(iter2))
+
+	// 3. Limit
+	iterOpts3 := DefaultIteratorOptions
+	iterOpts3.Limit = 5
+	iter3 := db.NewIterator(iterOpts3)
+	assert.Equal(t, 5, count(iter3))
+
+	// 4. Prefix and bounds together
+	iterOpts4 := DefaultIteratorOptions
+	iterOpts4.Prefix = []byte("bitcask-go-key-00000005")
+	iterOpts4.UpperBound = utils.GetTestKey(55)
+	iter4 := db.NewIterator(iterOpts4)
+	assert.Equal(t, utils.GetTestKey(50), iter4.Key())
+	assert.Equal(t, 5, count(iter4))
+
+	// 5. Reversed prefix starts from the last key with the prefix
+	iterOpts5 := DefaultIteratorOptions
+	iterOpts5.Prefix = []byte("bitcask-go-key")
+	iterOpts5.Reverse = true
+	iter5 := db.NewIterator(iterOpts5)
+	assert.Equal(t, utils.GetTestKey(99), iter5.Key())
+	assert.Equal(t, 100, count(iter5))
+
+	// 6. Seek and limit
+	iter6 := db.NewIterator(iterOpts3)
+	iter6.Seek(utils.GetTestKey(97))
+	assert.Equal(t, 5, count(iter6))
+}
+
+func TestDB_Iterator_ValueFunc(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-iterator-value-func")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	iterator := db.NewIterator(DefaultIteratorOptions)
+	defer iterator.Close()
+	var count int
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		err := iterator.ValueFunc(func(value []byte) error {
+			assert.Equal(t, iterator.Key(), value)
+			return nil
+		})
+		assert.Nil(t, err)
+		count++
+	}
+	assert.Equal(t, 1000, count)
+}
@@ -0,0 +1,204 @@
+package kvproject
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	manifestNonMergeFileIdKey = "non-merge-file-id"
+	manifestMergedFileBaseKey = "merged-file-base"
+	manifestMergedFileNumKey = "merged-file-num"
+	manifestHintFileKey = "hint-file"
+)
+
+// Live files of the database, saved in the manifest file
+// The data files written by the last merge are [mergedFileBase, mergedFileBase + mergedFileNum), their index is in the hint file
+// The other data files from nonMergeFileId are written after the merge started, they are read from the beginning
+// The other data files have been replaced by the merge
+type manifest struct {
+	nonMergeFileId uint32
+	mergedFileBase uint32
+	mergedFileNum uint32
+	hintFile string             // Name of the hint file, empty if there is none
+}
+
+// Is the data file written by the last merge?
+func (m *manifest) isMerged(fid uint32) bool {
+	return fid >= m.mergedFileBase && fid - m.mergedFileBase < m.mergedFileNum
+}
+
+func (m *manifest) isLive(fid uint32) bool {
+	return m.isMerged(fid) || fid >= m.nonMergeFileId
+}
+
+// Load the live files from the manifest, and delete the files which are not live
+// Without the manifest, the directory is written by an older version and all the files are live
+func (db *DB) loadManifest() error {
+	manifestFileName := filepath.Join(db.options.DirPath, data.ManifestFileName)
+	if _, err := db.options.FS.Stat(manifestFileName); err == nil {
+		records, err := readRecordFile(db.options.FS, db.options.DirPath, data.OpenManifestFile)
+		if err != nil {
+			return err
+		}
+		m := &manifest{hintFile: records[manifestHintFileKey]}
+		for key, field := range map[string]*uint32{
+			manifestNonMergeFileIdKey: &m.nonMergeFileId,
+			manifestMergedFileBaseKey: &m.mergedFileBase,
+			manifestMergedFileNumKey: &m.mergedFileNum,
+		} {
+			if *field, err = parseFileId(records, key); err != nil {
+				return err
+			}
+		}
+		db.manifest = m
+		// The files may be left by a crash after the manifest was switched
+		return db.removeObsoleteFiles()
+	}
+
+	// Older versions move the merged files to replace the old ones with the same ids
+	// Files before nonMergeFileId are loaded from the hint file
+	m := &manifest{}
+	if _, err := db.options.FS.Stat(filepath.Join(db.options.DirPath, data.MergeFinishedFileName)); err == nil {
+		records, err := readRecordFile(db.options.FS, db.options.DirPath, data.OpenMergeFinishedFile)
+		if err != nil {
+			return err
+		}
+		nonMergeFileId, err := parseFileId(records, mergeFinishedKey)
+		if err != nil {
+			return err
+		}
+		m.nonMergeFileId = nonMergeFileId
+		m.mergedFileNum = nonMergeFileId
+	}
+	if _, err := db.options.FS.Stat(filepath.Join(db.options.DirPath, data.HintFileName)); err == nil {
+		m.hintFile = data.HintFileName
+	}
+	db.manifest = m
+	return nil
+}
+
+// Switch the manifest: write a temporary file, sync it, then rename it to the manifest
+// After a crash the manifest is either the old one or the new one
+func (db *DB) writeManifest(m *manifest) error {
+	records := []*data.LogRecord{
+		{Key: []byte(manifestNonMergeFileIdKey), Value: []byte(strconv.FormatUint(uint64(m.nonMergeFileId), 10))},
+		{Key: []byte(manifestMergedFileBaseKey), Value: []byte(strconv.FormatUint(uint64(m.mergedFileBase), 10))},
+		{Key: []byte(manifestMergedFileNumKey), Value: []byte(strconv.FormatUint(uint64(m.mergedFileNum), 10))},
+		{Key: []byte(manifestHintFileKey), Value: []byte(m.hintFile)},
+	}
+	if err := writeRecordFile(db.options.FS, db.options.DirPath, data.ManifestTempFileName, data.ManifestFileName, data.OpenManifestTempFile, records); err != nil {
+		return err
+	}
+	db.manifest = m
+	return nil
+}
+
+// Delete the data files which are not live, the old hint files and the files of older versions
+// Must be called only after the manifest is durable
+func (db *DB) removeObsoleteFiles() error {
+	dirEntries, err := db.options.FS.ReadDir(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range dirEntries {
+		name := entry.Name()
+		obsolete := false
+		switch {
+		case strings.HasSuffix(name, data.DataFileNameSuffix):
+			fileId, err := strconv.ParseUint(strings.TrimSuffix(name, data.DataFileNameSuffix), 10, 32)
+			if err != nil {
+				return ErrDataDirectoryCorrupted
+			}
+			// Files still used by iterators are deleted when they are released
+			obsolete = !db.manifest.isLive(uint32(fileId)) && db.getObsoleteDataFile(uint32(fileId)) == nil
+		case name == data.HintFileName || strings.HasSuffix(name, data.HintFileNameSuffix):
+			obsolete = name != db.manifest.hintFile
+		case name == data.MergeFinishedFileName || name == data.MergeFinishedTempFileName || name == data.ManifestTempFileName || name == data.SeqNoTempFileName:
+			obsolete = true
+		}
+		if obsolete {
+			if err := db.options.FS.Remove(filepath.Join(db.options.DirPath, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Read all the records of a small file such as the manifest, the later record of a key wins
+func readRecordFile(fs fio.FS, dirPath string, open func(fio.FS, string) (*data.DataFile, error)) (map[string]string, error) {
+	file, err := open(fs, dirPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	fileSize, err := file.IoManager.Size()
+	if err != nil {
+		return nil, err
+	}
+	records := make(map[string]string)
+	offset := file.RecordsStart()
+	for {
+		record, size, err := file.ScanLogRecord(offset, fileSize)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		records[string(record.Key)] = string(record.Value)
+		offset += size
+	}
+	return records, nil
+}
+
+// Write the records to the temporary file, sync it and rename it to name
+// The file is either missing or complete after a crash, never half written
+func writeRecordFile(fs fio.FS, dirPath string, tempName string, name string, openTemp func(fio.FS, string) (*data.DataFile, error), records []*data.LogRecord) error {
+	tempFileName := filepath.Join(dirPath, tempName)
+	// Left by a crash, the records must not be appended to it
+	if err := fs.Remove(tempFileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	file, err := openTemp(fs, dirPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	for _, record := range records {
+		if err := file.WriteLogRecord(record); err != nil {
+			return err
+		}
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := fs.Rename(tempFileName, filepath.Join(dirPath, name)); err != nil {
+		return err
+	}
+	return fs.SyncDir(dirPath)
+}
+
+// Parse the file id saved in the record of key
+func parseFileId(records map[string]string, key string) (uint32, error) {
+	value, ok := records[key]
+	if !ok {
+		return 0, ErrDataDirectoryCorrupted
+	}
+	fileId, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(fileId), nil
+}
@@ -0,0 +1,177 @@
+package kvproject
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Put keys over several files and delete half of them, return the data left
+func putManifestTestData(t *testing.T, db *DB) map[string][]byte {
+	expected := make(map[string][]byte)
+	for i := 0; i < 2000; i++ {
+		value := utils.RandomValue(128)
+		err := db.Put(utils.GetTestKey(i), value)
+		assert.Nil(t, err)
+		expected[string(utils.GetTestKey(i))] = value
+	}
+	for i := 0; i < 2000; i += 2 {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		delete(expected, string(utils.GetTestKey(i)))
+	}
+	return expected
+}
+
+func checkManifestTestData(t *testing.T, db *DB, expected map[string][]byte) {
+	assert.Equal(t, len(expected), len(db.ListKeys()))
+	for key, value := range expected {
+		got, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, got)
+	}
+}
+
+// Only the live files and the current hint file are left in the directory
+func checkLiveFiles(t *testing.T, db *DB) {
+	entries, err := db.options.FS.ReadDir(db.options.DirPath)
+	assert.Nil(t, err)
+	for _, entry := range entries {
+		name := entry.Name()
+		assert.NotEqual(t, mergeDirName, name)
+		assert.NotEqual(t, data.HintFileName, name)
+		assert.NotEqual(t, data.MergeFinishedFileName, name)
+		assert.NotEqual(t, data.ManifestTempFileName, name)
+		if filepath.Ext(name) == data.HintFileNameSuffix {
+			assert.Equal(t, db.manifest.hintFile, name)
+		}
+	}
+	fileIds, err := listDataFileIds(db.options.FS, db.options.DirPath)
+	assert.Nil(t, err)
+	for _, fid := range fileIds {
+		assert.True(t, db.manifest.isLive(uint32(fid)), fid)
+	}
+}
+
+func TestDB_MergeManifest(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-manifest")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	expected := putManifestTestData(t, db)
+	oldFileIds, err := listDataFileIds(opts.FS, dir)
+	assert.Nil(t, err)
+	// Crash before the merged files are activated, they are installed when opening
+	_, err = db.merge()
+	assert.Nil(t, err)
+	_, err = opts.FS.Stat(filepath.Join(dir, mergeDirName, data.MergeFinishedFileName))
+	assert.Nil(t, err)
+	err = db.Put([]byte("after-merge"), []byte("value"))
+	assert.Nil(t, err)
+	expected["after-merge"] = []byte("value")
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	_, err = opts.FS.Stat(filepath.Join(dir, data.ManifestFileName))
+	assert.Nil(t, err)
+	// The merged files are numbered after all the old files
+	m := db2.manifest
+	assert.Equal(t, uint32(oldFileIds[len(oldFileIds) - 1] + 1), m.nonMergeFileId)
+	assert.Greater(t, m.mergedFileBase, m.nonMergeFileId)
+	assert.Greater(t, m.mergedFileNum, uint32(0))
+	assert.Equal(t, data.GetHintFileName(m.mergedFileBase), m.hintFile)
+	for _, fid := range oldFileIds {
+		assert.False(t, m.isLive(uint32(fid)), fid)
+	}
+	assert.Equal(t, m.mergedFileBase + m.mergedFileNum, db2.nextFileId)
+	checkLiveFiles(t, db2)
+	checkManifestTestData(t, db2, expected)
+
+	// Files written after installing never take the ids of the merged files
+	for i := 0; i < 1000; i++ {
+		value := utils.RandomValue(128)
+		err := db2.Put(utils.GetTestKey(i), value)
+		assert.Nil(t, err)
+		expected[string(utils.GetTestKey(i))] = value
+	}
+	assert.GreaterOrEqual(t, db2.activeFile.FileId, m.mergedFileBase + m.mergedFileNum)
+	err = db2.Merge()
+	assert.Nil(t, err)
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Greater(t, db3.manifest.mergedFileBase, m.mergedFileBase)
+	for fid := m.mergedFileBase; fid < m.mergedFileBase + m.mergedFileNum; fid++ {
+		_, err := opts.FS.Stat(data.GetDataFileName(dir, fid))
+		assert.True(t, os.IsNotExist(err), fid)
+	}
+	checkLiveFiles(t, db3)
+	checkManifestTestData(t, db3, expected)
+	err = db3.Close()
+	assert.Nil(t, err)
+
+	// Opening again doesn't change the files
+	db4, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, db3.manifest, db4.manifest)
+	checkManifestTestData(t, db4, expected)
+	err = db4.Close()
+	assert.Nil(t, err)
+}
+
+// Older versions merge into the directory beside the database
+func TestDB_LoadLegacyMergeDir(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-legacy-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	expected := putManifestTestData(t, db)
+	_, err = db.merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	legacyMergePath := db.getLegacyMergePath()
+	assert.Equal(t, dir + legacyMergeDirSuffix, legacyMergePath)
+	err = opts.FS.Rename(db.getMergePath(), legacyMergePath)
+	assert.Nil(t, err)
+	defer func() {
+		_ = os.RemoveAll(legacyMergePath)
+	}()
+	// Older versions don't save the number of merged files
+	records, err := readRecordFile(opts.FS, legacyMergePath, data.OpenMergeFinishedFile)
+	assert.Nil(t, err)
+	err = writeRecordFile(opts.FS, legacyMergePath, data.MergeFinishedTempFileName, data.MergeFinishedFileName, data.OpenMergeFinishedTempFile, []*data.LogRecord{
+		{Key: []byte(mergeFinishedKey), Value: []byte(records[mergeFinishedKey])},
+	})
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	_, err = opts.FS.Stat(legacyMergePath)
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, records[mergedFileNumKey], strconv.FormatUint(uint64(db2.manifest.mergedFileNum), 10))
+	checkLiveFiles(t, db2)
+	checkManifestTestData(t, db2, expected)
+	err = db2.Close()
+	assert.Nil(t, err)
+}
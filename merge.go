@@ -2,7 +2,8 @@ package kvproject
 
 import (
 	"bitcask-go/data"
-	"bitcask-go/utils"
+	"bitcask-go/fio"
+	"bitcask-go/index"
 	"io"
 	"os"
 	"path"
@@ -12,15 +13,35 @@ import (
 )
 
 const (
-	mergeDirName = "-merge"
+	mergeDirName = "merge"
+	legacyMergeDirSuffix = "-merge"
 	mergeFinishedKey = "merge-finished"
+	mergedFileNumKey = "merged-file-num"
+	mergedFileBaseKey = "merged-file-base"
+
+	// Number of positions put into the index in one batch when loading or remapping it
+	indexBatchSize = 1024
 )
 
 // Clear invalid data and create hint file
+// The merged files are used at once, the replaced files are deleted when no iterator uses them
 func (db *DB) Merge() error {
+	reclaimSize, err := db.merge()
+	if err != nil {
+		return err
+	}
+
+	// Switch to the merged files without restarting
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.activateMerge(reclaimSize)
+}
+
+// Write the merged files to the merge directory, return the invalid data they reclaim
+func (db *DB) merge() (int64, error) {
 	// If database is empty, return
 	if db.activeFile == nil {
-		return nil
+		return 0, nil
 	}
 
 	db.mu.Lock()
@@ -28,29 +49,35 @@ func (db *DB) Merge() error {
 	// If merge is in progress, return error
 	if db.isMerging {
 		db.mu.Unlock()
-		return ErrMergeIsInProgress
+		return 0, ErrMergeIsInProgress
+	}
+
+	// A finished merge whose activation failed, its files may have been moved so it cannot be dropped
+	if err := db.activateMerge(0); err != nil {
+		db.mu.Unlock()
+		return 0, err
 	}
 
 	// Check if the merge data volume reaches the threshold
-	totalSize, err := utils.DirSize(db.options.DirPath)
+	totalSize, err := db.diskSize()
 	if err != nil {
 		db.mu.Unlock()
-		return err
+		return 0, err
 	}
 	if float32(db.reclaimSize) / float32(totalSize) < db.options.DataFileMergeRatio {
 		db.mu.Unlock()
-		return ErrMergeRatioUnreached
+		return 0, ErrMergeRatioUnreached
 	}
 
 	// Check if the remaining disk space can hold all the data during merge
-	availableDiskSize, err := utils.AvailableDiskSize()
+	availableDiskSize, err := db.options.FS.DiskFree(db.options.DirPath)
 	if err != nil {
 		db.mu.Unlock()
-		return err
+		return 0, err
 	}
 	if uint64(totalSize - db.reclaimSize) >= availableDiskSize {
 		db.mu.Unlock()
-		return ErrNoEnoughSpaceForMerge
+		return 0, ErrNoEnoughSpaceForMerge
 	}
 
 	db.isMerging = true
@@ -61,17 +88,26 @@ func (db *DB) Merge() error {
 	// Make the current active file persistant 
 	if err := db.activeFile.Sync(); err != nil {
 		db.mu.Unlock()
-		return err
+		return 0, err
 	}
 	// Transfer current active file into oler file
-	db.olderFiles[db.activeFile.FileId] = db.activeFile
+	if err := db.moveActiveToOlder(); err != nil {
+		db.mu.Unlock()
+		return 0, err
+	}
 	// Open a new active file
 	if err := db.setActiveDataFile(); err != nil {
 		db.mu.Unlock()
-		return err
+		return 0, err
 	}
 	// Record the latest file not merged
 	nonMergeFileId := db.activeFile.FileId
+	// The files below it will be replaced, new operands cannot point to them
+	db.mergeBoundary = nonMergeFileId
+	// The index has all the records of the files to merge
+	db.markIndexApplied(&data.LogRecordPos{Fid: nonMergeFileId})
+	// The invalid data in the files to merge, it is reclaimed after merge
+	reclaimSize := db.reclaimSize
 
 	// Get all the files which need merge
 	var mergeFiles []*data.DataFile
@@ -90,16 +126,24 @@ func (db *DB) Merge() error {
 	mergePath := db.getMergePath()
 	// If this mergePath exists, it means merge has happened before
 	// Delete the merge directory before
-	if _, err := os.Stat(mergePath); err == nil {
-		if err := os.RemoveAll(mergePath); err != nil {
-			return err
+	if _, err := db.options.FS.Stat(mergePath); err == nil {
+		if err := db.options.FS.RemoveAll(mergePath); err != nil {
+			return 0, err
 		}
 	}
 
 	// Newly build a merge directory
-	if err := os.MkdirAll(mergePath, os.ModePerm); err != nil {
-		return err
+	if err := db.options.FS.MkdirAll(mergePath, os.ModePerm); err != nil {
+		return 0, err
 	}
+	if err := db.writeMergeFiles(mergePath, mergeFiles, nonMergeFileId); err != nil {
+		return 0, err
+	}
+	return reclaimSize, nil
+}
+
+// Rewrite the valid records of mergeFiles to the merge directory, with the hint file and the file which indicates the end of merge
+func (db *DB) writeMergeFiles(mergePath string, mergeFiles []*data.DataFile, nonMergeFileId uint32) error {
 	// Open a new database instance
 	mergeOptions := db.options
 	mergeOptions.DirPath = mergePath
@@ -110,18 +154,30 @@ func (db *DB) Merge() error {
 	if err != nil {
 		return err
 	}
+	// Release the files and the mappings of the merge database
+	defer func() {
+		_ = mergeDB.Close()
+	}()
 
 	// Open hint file
-	hintFile, err := data.OpenHintFile(mergePath)
+	hintFile, err := data.OpenHintFile(db.options.FS, mergePath)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		_ = hintFile.Close()
+	}()
 
 	// Iterate over all the data files which need to be processed
 	for _, dataFile := range mergeFiles {
-		var offset int64 = 0
+		// Older files are not written any more, get the size only once
+		fileSize, err := dataFile.IoManager.Size()
+		if err != nil {
+			return err
+		}
+		offset := dataFile.RecordsStart()
 		for {
-			logRecord, size, err := dataFile.ReadLogRecord(offset)
+			logRecord, size, err := dataFile.ScanLogRecord(offset, fileSize)
 			if err != nil {
 				if err == io.EOF {
 					break
@@ -135,6 +191,17 @@ func (db *DB) Merge() error {
 			if logRecordPos != nil && logRecordPos.Fid == dataFile.FileId && logRecordPos.Offset == offset {
 				// This logRecord is latest.
 				// Should be written to the current active file
+				if logRecord.Type == data.LogRecordMergeOperand {
+					// Collapse the operands into a single value
+					db.mu.RLock()
+					value, err := db.resolveMergeOperands(logRecord)
+					db.mu.RUnlock()
+					if err != nil {
+						return err
+					}
+					logRecord.Value = value
+					logRecord.Type = data.LogRecordNormal
+				}
 				// Because this data is valid. There is no need to write its seqNo to the current active file
 				logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
 				pos, err := mergeDB.appendLogRecord(logRecord)
@@ -160,141 +227,303 @@ func (db *DB) Merge() error {
 	}
 
 	// Write the file which indicates the end of merge
-	mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath)
-	if err != nil {
-		return err
+	// It is written under a temporary name and renamed after syncing, a half written one would be taken as the end of merge
+	// The number of merged data files, they are numbered from 0
+	var mergedFileNum uint32
+	if mergeDB.activeFile != nil {
+		mergedFileNum = mergeDB.activeFile.FileId + 1
 	}
-	mergeFinRecord := &data.LogRecord{
-		Key: []byte(mergeFinishedKey),
-		Value: []byte(strconv.Itoa(int(nonMergeFileId))),
-	}
-	encRecord, _ := data.EncodeLogRecord(mergeFinRecord)
-	if err := mergeFinishedFile.Write(encRecord); err != nil {
-		return err
-	}
-	if err := mergeFinishedFile.Sync(); err != nil {
-		return err
+	records := []*data.LogRecord{
+		{Key: []byte(mergeFinishedKey), Value: []byte(strconv.Itoa(int(nonMergeFileId)))},
+		{Key: []byte(mergedFileNumKey), Value: []byte(strconv.Itoa(int(mergedFileNum)))},
 	}
+	return writeRecordFile(db.options.FS, mergePath, data.MergeFinishedTempFileName, data.MergeFinishedFileName, data.OpenMergeFinishedTempFile, records)
+}
 
-	return nil
+// The merge directory is in the database directory
+// eg : /tmp/bitcask  ->   /tmp/bitcask/merge
+func (db *DB) getMergePath() string {
+	return filepath.Join(db.options.DirPath, mergeDirName)
 }
 
+// Older versions merge into a directory beside the database
 // eg : /tmp/bitcask  ->   /tmp/bitcask-merge
-func (db *DB) getMergePath() string {
+func (db *DB) getLegacyMergePath() string {
 	// func Clean make sure the format of DirPath is correct
 	// func Dir get the parent directory of DirPath
 	dir := path.Dir(path.Clean(db.options.DirPath))
 	// Get the name of the database directory
 	// For the example above, it gets bitcask
 	base := path.Base(db.options.DirPath)
-	return filepath.Join(dir, base + mergeDirName)
+	return filepath.Join(dir, base + legacyMergeDirSuffix)
 }
 
 // Load merge directory
+// The merged files are installed when opening if the merge was not activated
 func (db *DB) loadMergeFiles() error {
+	for _, mergePath := range []string{db.getMergePath(), db.getLegacyMergePath()} {
+		if _, err := db.options.FS.Stat(mergePath); os.IsNotExist(err) {
+			// If not exist, continue
+			continue
+		}
+		m, err := db.moveMergeFiles(mergePath)
+		if err != nil {
+			return err
+		}
+		if m != nil {
+			// B+ tree stores indexes on the disk, they may still point to the replaced files
+			if db.options.IndexType == BPlusTree {
+				if err := db.remapMergedPositions(m); err != nil {
+					return err
+				}
+			}
+			// Switch to the merged files, the replaced files are deleted only after the new manifest is durable
+			if err := db.writeManifest(m); err != nil {
+				return err
+			}
+			if err := db.removeObsoleteFiles(); err != nil {
+				return err
+			}
+		}
+		// Merge didn't complete, or the merged files have been installed
+		if err := db.options.FS.RemoveAll(mergePath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Switch to the merged files of the merge directory without restarting
+// The index is pointed to the merged files, the replaced files are deleted when no iterator uses them
+// reclaimSize is the invalid data reclaimed by the merge
+// Must have lock when using this method
+func (db *DB) activateMerge(reclaimSize int64) error {
 	mergePath := db.getMergePath()
-	if _, err := os.Stat(mergePath); os.IsNotExist(err) {
-		// If not exist, return
+	if _, err := db.options.FS.Stat(mergePath); os.IsNotExist(err) {
 		return nil
 	}
-	defer func() {
-		_ = os.RemoveAll(mergePath)
-	}()
-
-	dirEntries, err := os.ReadDir(mergePath)
-	if err != nil {
+	m, err := db.moveMergeFiles(mergePath)
+	if err != nil || m == nil {
 		return err
 	}
 
-	// Find the file which indicates the end of merge
-	var mergeFinished bool
-	var mergeFileNames []string
-	for _, entry := range dirEntries {
-		if entry.Name() == data.MergeFinishedFileName {
-			mergeFinished = true
-		} 
-		if entry.Name() == data.SeqNoFileName {
-			// It is meaningless to move this file
-			// Because maybe there's new seqNo produced during merge
+	// Open the merged files, they are only read
+	ioType := fio.StandardFIO
+	if db.options.MMapAtStartUp {
+		ioType = fio.MemoryMap
+	}
+	for fid := m.mergedFileBase; fid < m.mergedFileBase + m.mergedFileNum; fid++ {
+		if db.olderFiles[fid] != nil {
 			continue
 		}
-		if entry.Name() == fileLockName {
-			continue
+		dataFile, err := data.OpenDataFile(db.options.FS, db.options.DirPath, fid, ioType)
+		if err != nil {
+			return err
 		}
-		// All the files in the merge directory are in mergeFileNames, 
-		// including the hint file and the MergeFinishedFile
-		// They will be moved to replace those merged old files
-		mergeFileNames = append(mergeFileNames, entry.Name())
-		
+		db.filesMu.Lock()
+		db.olderFiles[fid] = dataFile
+		db.filesMu.Unlock()
+	}
+	if err := db.remapMergedPositions(m); err != nil {
+		return err
 	}
 
-	if !mergeFinished {
-		// Merge didn't complete
-		return nil
+	// Switch to the merged files, the replaced files are deleted only after the new manifest is durable
+	if err := db.writeManifest(m); err != nil {
+		return err
+	}
+	// Get reading the replaced files without mu is finished before they are closed
+	db.filesMu.Lock()
+	for fid, dataFile := range db.olderFiles {
+		if !m.isLive(fid) {
+			delete(db.olderFiles, fid)
+			db.retireDataFile(dataFile)
+		}
+	}
+	db.filesMu.Unlock()
+	if err := db.removeObsoleteFiles(); err != nil {
+		return err
+	}
+
+	db.reclaimSize -= reclaimSize
+	if db.reclaimSize < 0 {
+		db.reclaimSize = 0
+	}
+	return db.options.FS.RemoveAll(mergePath)
+}
+
+// Move the files of a finished merge into the database directory, return the manifest with the merged files
+// The merged files get new ids after all the data files, so nothing is replaced when moving
+// If the process crashes in the middle, it is done again at the next start, so every step can be repeated
+// nil is returned if the merge didn't complete
+func (db *DB) moveMergeFiles(mergePath string) (*manifest, error) {
+	if _, err := db.options.FS.Stat(filepath.Join(mergePath, data.MergeFinishedFileName)); os.IsNotExist(err) {
+		return nil, nil
 	}
 
-	// Merge completed. Need to delete the merged old files and move the new one in.
-	nonMergeFileId, err := db.getNonMergeFileId(mergePath)
+	records, err := readRecordFile(db.options.FS, mergePath, data.OpenMergeFinishedFile)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	var fileId uint32 = 0
-	for ; fileId < nonMergeFileId; fileId++ {
-		fileName := data.GetDataFileName(db.options.DirPath, fileId)
-		if _, err := os.Stat(fileName); err == nil {
-			if err := os.Remove(fileName); err != nil {
-				return err
+	nonMergeFileId, err := parseFileId(records, mergeFinishedKey)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := records[mergedFileBaseKey]; !ok {
+		// Not moved yet, choose the ids of the merged files
+		// They are saved before moving, so that the same ids are used if moving is done again
+		mergedFileBase, err := db.nextDataFileId(nonMergeFileId)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := records[mergedFileNumKey]; !ok {
+			// Not saved by older versions, count the merged files
+			if records[mergedFileNumKey], err = countDataFiles(db.options.FS, mergePath); err != nil {
+				return nil, err
 			}
 		}
+		records[mergedFileBaseKey] = strconv.FormatUint(uint64(mergedFileBase), 10)
+		var mergeFinRecords []*data.LogRecord
+		for _, key := range []string{mergeFinishedKey, mergedFileNumKey, mergedFileBaseKey} {
+			mergeFinRecords = append(mergeFinRecords, &data.LogRecord{Key: []byte(key), Value: []byte(records[key])})
+		}
+		if err := writeRecordFile(db.options.FS, mergePath, data.MergeFinishedTempFileName, data.MergeFinishedFileName, data.OpenMergeFinishedTempFile, mergeFinRecords); err != nil {
+			return nil, err
+		}
 	}
-	for _, fileName := range mergeFileNames {
-		// Hint file is also moved to destPath
-		scrPath := filepath.Join(mergePath, fileName)
-		destPath := filepath.Join(db.options.DirPath, fileName)
-		if err := os.Rename(scrPath, destPath); err != nil {
-			return err
+	mergedFileBase, err := parseFileId(records, mergedFileBaseKey)
+	if err != nil {
+		return nil, err
+	}
+	mergedFileNum, err := parseFileId(records, mergedFileNumKey)
+	if err != nil {
+		return nil, err
+	}
+	// New files are numbered after the merged files
+	if db.nextFileId < mergedFileBase + mergedFileNum {
+		db.nextFileId = mergedFileBase + mergedFileNum
+	}
+
+	// Move the merged files and the hint file, the ones already moved are skipped
+	hintFile := data.GetHintFileName(mergedFileBase)
+	moves := map[string]string{
+		filepath.Join(mergePath, data.HintFileName): filepath.Join(db.options.DirPath, hintFile),
+	}
+	for fileId := uint32(0); fileId < mergedFileNum; fileId++ {
+		moves[data.GetDataFileName(mergePath, fileId)] = data.GetDataFileName(db.options.DirPath, mergedFileBase + fileId)
+	}
+	for scrPath, destPath := range moves {
+		if _, err := db.options.FS.Stat(scrPath); os.IsNotExist(err) {
+			continue
 		}
+		if err := db.options.FS.Rename(scrPath, destPath); err != nil {
+			return nil, err
+		}
+	}
+	if err := db.options.FS.SyncDir(db.options.DirPath); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return &manifest{
+		nonMergeFileId: nonMergeFileId,
+		mergedFileBase: mergedFileBase,
+		mergedFileNum: mergedFileNum,
+		hintFile: hintFile,
+	}, nil
 }
 
-func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
-	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath)
+// Get the id after all the data files in the database directory, and not less than minFileId
+func (db *DB) nextDataFileId(minFileId uint32) (uint32, error) {
+	fileIds, err := listDataFileIds(db.options.FS, db.options.DirPath)
 	if err != nil {
 		return 0, err
 	}
+	nextFileId := minFileId
+	if nextFileId < db.nextFileId {
+		// The active file may not be on the disk yet
+		nextFileId = db.nextFileId
+	}
+	for _, fileId := range fileIds {
+		if uint32(fileId) >= nextFileId {
+			nextFileId = uint32(fileId) + 1
+		}
+	}
+	return nextFileId, nil
+}
 
-	// Because there is only one data, the offset is 0
-	record, _, err := mergeFinishedFile.ReadLogRecord(0)
+// Count the data files in dirPath, return it as the value of a record
+func countDataFiles(fs fio.FS, dirPath string) (string, error) {
+	fileIds, err := listDataFileIds(fs, dirPath)
 	if err != nil {
-		return 0, err
+		return "", err
 	}
+	return strconv.Itoa(len(fileIds)), nil
+}
 
-	nonMergeFileId, err := strconv.Atoi(string(record.Value))
+func (db *DB) loadIndexFromHintFile() error {
+	ops := make([]index.BatchOp, 0, indexBatchSize)
+	err := db.readHintFile(db.manifest, func(key []byte, pos *data.LogRecordPos) {
+		ops = append(ops, index.BatchOp{Key: key, Pos: pos})
+		if len(ops) == indexBatchSize {
+			db.index.ApplyBatch(ops)
+			ops = ops[:0]
+		}
+	})
 	if err != nil {
-		return 0, err
+		return err
 	}
+	db.index.ApplyBatch(ops)
+	return nil
+}
 
-	return uint32(nonMergeFileId), nil
+// Point the index to the merged files
+// Keys changed after the merge started are in the files from nonMergeFileId, their positions are kept
+// The others still point to the files replaced by the merge, the merge has rewritten them
+func (db *DB) remapMergedPositions(m *manifest) error {
+	ops := make([]index.BatchOp, 0, indexBatchSize)
+	err := db.readHintFile(m, func(key []byte, pos *data.LogRecordPos) {
+		if oldPos := db.index.Get(key); oldPos != nil && oldPos.Fid < m.nonMergeFileId {
+			ops = append(ops, index.BatchOp{Key: key, Pos: pos})
+		}
+		if len(ops) == indexBatchSize {
+			db.index.ApplyBatch(ops)
+			ops = ops[:0]
+		}
+	})
+	if err != nil {
+		return err
+	}
+	db.index.ApplyBatch(ops)
+	return nil
 }
 
-func (db *DB) loadIndexFromHintFile() error {
-	hintFileName := filepath.Join(db.options.DirPath, data.HintFileName)
-	if _, err := os.Stat(hintFileName); os.IsNotExist(err) {
+// Call fn with every key and position in the hint file of m
+func (db *DB) readHintFile(m *manifest, fn func(key []byte, pos *data.LogRecordPos)) error {
+	if m.hintFile == "" {
+		return nil
+	}
+	hintFileName := filepath.Join(db.options.DirPath, m.hintFile)
+	if _, err := db.options.FS.Stat(hintFileName); os.IsNotExist(err) {
 		return nil
 	}
 
 	// The hint file exists
-	hintFile, err := data.OpenHintFile(db.options.DirPath)
+	hintFile, err := data.OpenHintFileWithName(db.options.FS, db.options.DirPath, m.hintFile)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		_ = hintFile.Close()
+	}()
 
 	// Read indexes in the file
-	var offset int64 = 0
+	fileSize, err := hintFile.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	offset := hintFile.RecordsStart()
 	for {
-		logRecord, size, err := hintFile.ReadLogRecord(offset)
+		logRecord, size, err := hintFile.ScanLogRecord(offset, fileSize)
 		if err != nil {
 			if err == io.EOF {
 				break
@@ -302,9 +531,14 @@ func (db *DB) loadIndexFromHintFile() error {
 			return err
 		}
 
-		pos := data.DecodeLogRecordPos(logRecord.Value)
-		db.index.Put(logRecord.Key, pos)
+		pos, err := data.DecodeLogRecordPos(logRecord.Value)
+		if err != nil {
+			return err
+		}
+		// Merged files are numbered from 0 in the hint file
+		pos.Fid += m.mergedFileBase
+		fn(logRecord.Key, pos)
 		offset += size
 	}
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,92 @@
+package kvproject
+
+import (
+	"bitcask-go/data"
+)
+
+// Append an operand to the key without reading the old value
+// The operands are combined with the value by Options.MergeOperator when reading
+// Merge of data files collapses the operands into a single value
+func (db *DB) MergeValue(key []byte, operand []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if db.options.MergeOperator == nil {
+		return ErrMergeOperatorNotSet
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	prev := db.index.Get(key)
+	if prev != nil && prev.Fid < db.mergeBoundary {
+		// The file of the previous record is rewritten by merge
+		// The position will be invalid after restart, so write the combined value instead
+		existing, err := db.getValueByPosition(prev)
+		if err != nil {
+			return err
+		}
+		value, err := db.options.MergeOperator.Merge(key, existing, [][]byte{operand})
+		if err != nil {
+			return err
+		}
+		return db.put(key, value)
+	}
+
+	// The operand points to the previous record of the key
+	logRecord := &data.LogRecord{
+		Key: logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Value: data.EncodeMergeOperand(prev, operand),
+		Type: data.LogRecordMergeOperand,
+	}
+	pos, err := db.appendLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+
+	// The previous record is still used by the operand, it cannot be reclaimed
+	db.markIndexApplied(pos)
+	db.index.Put(key, pos)
+	return nil
+}
+
+// Follow the chain of operands until the value, then combine them
+// Must have lock when using this method
+func (db *DB) resolveMergeOperands(logRecord *data.LogRecord) ([]byte, error) {
+	if db.options.MergeOperator == nil {
+		return nil, ErrMergeOperatorNotSet
+	}
+	realKey, _ := parseLogRecordKey(logRecord.Key)
+
+	// The chain is from the newest to the oldest
+	var operands [][]byte
+	var existing []byte
+	for {
+		prev, operand, err := data.DecodeMergeOperand(logRecord.Value)
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, operand)
+		if prev == nil {
+			break
+		}
+
+		record, err := db.readLogRecord(prev)
+		if err != nil {
+			return nil, err
+		}
+		if record.Type != data.LogRecordMergeOperand {
+			if record.Type == data.LogRecordNormal {
+				existing = record.Value
+			}
+			break
+		}
+		logRecord = record
+	}
+
+	// Operator wants the operands in the order they were written
+	for i, j := 0, len(operands) - 1; i < j; i, j = i + 1, j - 1 {
+		operands[i], operands[j] = operands[j], operands[i]
+	}
+	return db.options.MergeOperator.Merge(realKey, existing, operands)
+}
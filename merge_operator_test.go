@@ -0,0 +1,161 @@
+package kvproject
+
+import (
+	"bitcask-go/utils"
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Join the value and the operands with ","
+type appendOperator struct{}
+
+func (appendOperator) Merge(key []byte, existing []byte, operands [][]byte) ([]byte, error) {
+	values := operands
+	if existing != nil {
+		values = append([][]byte{existing}, operands...)
+	}
+	return bytes.Join(values, []byte(",")), nil
+}
+
+func TestDB_MergeValue(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-value")
+	opts.DirPath = dir
+	opts.MergeOperator = appendOperator{}
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1. Key doesn't exist
+	err = db.MergeValue(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	err = db.MergeValue(utils.GetTestKey(1), []byte("b"))
+	assert.Nil(t, err)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a,b"), val)
+
+	// 2. Key exists
+	err = db.Put(utils.GetTestKey(2), []byte("x"))
+	assert.Nil(t, err)
+	err = db.MergeValue(utils.GetTestKey(2), []byte("y"))
+	assert.Nil(t, err)
+	val, err = db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("x,y"), val)
+
+	// 3. Put and delete after the operands
+	err = db.Put(utils.GetTestKey(1), []byte("c"))
+	assert.Nil(t, err)
+	val, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("c"), val)
+	err = db.Delete(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	err = db.MergeValue(utils.GetTestKey(2), []byte("z"))
+	assert.Nil(t, err)
+	val, err = db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("z"), val)
+
+	// 4. Iterator
+	iter := db.NewIterator(DefaultIteratorOptions)
+	defer iter.Close()
+	assert.True(t, iter.Valid())
+	val, err = iter.Value()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("c"), val)
+
+	// 5. Key is empty
+	err = db.MergeValue(nil, []byte("a"))
+	assert.Equal(t, ErrKeyIsEmpty, err)
+}
+
+func TestDB_MergeValue_NoOperator(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-value-none")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.MergeValue(utils.GetTestKey(1), []byte("a"))
+	assert.Equal(t, ErrMergeOperatorNotSet, err)
+}
+
+func TestDB_MergeValue_Restart(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-value-restart")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.MergeOperator = appendOperator{}
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// The operands are in more than one data files
+	var expected [][]byte
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i + 10), utils.RandomValue(128))
+		assert.Nil(t, err)
+		if i % 100 == 0 {
+			operand := []byte(utils.GetTestKey(i))
+			expected = append(expected, operand)
+			err = db.MergeValue(utils.GetTestKey(1), operand)
+			assert.Nil(t, err)
+		}
+	}
+
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	val, err := db2.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, bytes.Join(expected, []byte(",")), val)
+}
+
+// Merge collapses the operands into a single value
+func TestDB_Merge_MergeValue(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-operands")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	opts.MergeOperator = appendOperator{}
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	err = db.MergeValue(utils.GetTestKey(1), []byte("b"))
+	assert.Nil(t, err)
+
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	// The operand points to a merged file, the value is combined
+	err = db.MergeValue(utils.GetTestKey(1), []byte("c"))
+	assert.Nil(t, err)
+	// The operand points to a file not merged
+	err = db.MergeValue(utils.GetTestKey(1), []byte("d"))
+	assert.Nil(t, err)
+
+	// Restart
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	val, err := db2.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a,b,c,d"), val)
+}
@@ -1,9 +1,12 @@
 package kvproject
 
 import (
+	"bitcask-go/data"
+	"bitcask-go/index"
 	"bitcask-go/utils"
 	"github.com/stretchr/testify/assert"
 	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 )
@@ -201,3 +204,146 @@ func TestDB_Merge5(t *testing.T) {
 		assert.NotNil(t, val)
 	}
 }
+
+
+// Data covered by range tombstones is dropped by merge
+func TestDB_Merge_DeleteRange(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-range")
+	opts.DataFileSize = 32 * 1024 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 50000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(t, err)
+	}
+	err = db.DeleteRange(utils.GetTestKey(0), utils.GetTestKey(40000))
+	assert.Nil(t, err)
+
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	// Restart
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	defer func() {
+		_ = db2.Close()
+	}()
+	assert.Nil(t, err)
+	keys := db2.ListKeys()
+	assert.Equal(t, 10000, len(keys))
+
+	_, err = db2.Get(utils.GetTestKey(100))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db2.Get(utils.GetTestKey(40000))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+
+	// Only the valid data is left on the disk
+	stat, err := db2.Stat()
+	assert.Nil(t, err)
+	assert.True(t, stat.DiskSize < int64(20000 * 1024))
+}
+
+// The merged files are used without restarting
+func TestDB_MergeOnline(t *testing.T) {
+	for _, indexType := range []IndexerType{Btree, BPlusTree} {
+		testMergeOnline(t, indexType)
+	}
+}
+
+// Size of the data in the directory
+// The B+ tree index file grows by the rules of bbolt, it is not counted
+func dataDiskSize(db *DB) int64 {
+	stat, err := db.Stat()
+	if err != nil {
+		panic(err)
+	}
+	size := stat.DiskSize
+	if info, err := db.options.FS.Stat(filepath.Join(db.options.DirPath, index.BPlusTreeIndexFileName)); err == nil {
+		size -= info.Size()
+	}
+	return size
+}
+
+func testMergeOnline(t *testing.T, indexType IndexerType) {
+	opts := DefaultOptions
+	opts.IndexType = indexType
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-online")
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	expected := putManifestTestData(t, db)
+	oldFileIds, err := listDataFileIds(opts.FS, dir)
+	assert.Nil(t, err)
+	diskSize := dataDiskSize(db)
+	// The iterator created before the merge still reads the replaced files
+	// The B+ tree iterator holds a read transaction of bbolt, which blocks the writes of merge
+	var iterator *Iterator
+	if indexType != BPlusTree {
+		iterator = db.NewIterator(DefaultIteratorOptions)
+	}
+
+	err = db.Merge()
+	assert.Nil(t, err)
+	for _, fid := range oldFileIds {
+		assert.Nil(t, db.olderFiles[uint32(fid)], fid)
+	}
+	checkManifestTestData(t, db, expected)
+
+	if iterator != nil {
+		for _, fid := range oldFileIds {
+			_, err := opts.FS.Stat(data.GetDataFileName(dir, uint32(fid)))
+			assert.Nil(t, err, fid)
+		}
+		var count int
+		for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+			value, err := iterator.Value()
+			assert.Nil(t, err)
+			assert.Equal(t, expected[string(iterator.Key())], value)
+			count++
+		}
+		assert.Equal(t, len(expected), count)
+		// Deleted when the last iterator using them is closed
+		iterator.Close()
+	}
+	for _, fid := range oldFileIds {
+		_, err := opts.FS.Stat(data.GetDataFileName(dir, uint32(fid)))
+		assert.True(t, os.IsNotExist(err), fid)
+	}
+	assert.Equal(t, 0, len(db.obsoleteFiles))
+	assert.Less(t, dataDiskSize(db), diskSize)
+	checkLiveFiles(t, db)
+
+	// Merge again without an iterator, the replaced files are deleted at once
+	err = db.Put([]byte("after-merge"), []byte("value"))
+	assert.Nil(t, err)
+	expected["after-merge"] = []byte("value")
+	err = db.Merge()
+	assert.Nil(t, err)
+	checkLiveFiles(t, db)
+	checkManifestTestData(t, db, expected)
+	fileIds, err := listDataFileIds(opts.FS, dir)
+	assert.Nil(t, err)
+	assert.Equal(t, len(db.olderFiles) + 1, len(fileIds))
+
+	// Restart
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	checkManifestTestData(t, db2, expected)
+	err = db2.Close()
+	assert.Nil(t, err)
+}
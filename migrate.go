@@ -0,0 +1,21 @@
+package kvproject
+
+// Rewrite the files of the database in the current file format
+// Files of older formats can still be read, migrating makes all of them have the header and the current checksum
+// options are the ones used to open the database, the MergeOperator is needed if MergeValue was used
+// The database must not be opened when migrating
+func Migrate(options Options) error {
+	// Merge rewrites all the data files and the hint file however much can be reclaimed
+	// The B+ tree index saved by older versions has no high-water mark, it is rebuilt when opening
+	options.DataFileMergeRatio = 0
+	db, err := Open(options)
+	if err != nil {
+		return err
+	}
+	if err := db.Merge(); err != nil {
+		_ = db.Close()
+		return err
+	}
+	// Close writes a new seq-no file
+	return db.Close()
+}
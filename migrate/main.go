@@ -0,0 +1,45 @@
+package main
+
+import (
+	kvproject "bitcask-go"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// Rewrite a database directory written by an older version in the current file format
+// eg: go run ./migrate -dir /tmp/bitcask-go -index btree
+func main() {
+	dir := flag.String("dir", "", "directory of the database")
+	indexType := flag.String("index", "btree", "index type of the database: btree, art or bptree")
+	flag.Parse()
+
+	if *dir == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if _, err := os.Stat(*dir); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to find the database: %v\n", err)
+		os.Exit(1)
+	}
+
+	options := kvproject.DefaultOptions
+	options.DirPath = *dir
+	switch *indexType {
+	case "btree":
+		options.IndexType = kvproject.Btree
+	case "art":
+		options.IndexType = kvproject.ART
+	case "bptree":
+		options.IndexType = kvproject.BPlusTree
+	default:
+		fmt.Fprintf(os.Stderr, "unknown index type %q\n", *indexType)
+		os.Exit(2)
+	}
+
+	if err := kvproject.Migrate(options); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to migrate: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("migrated", *dir)
+}
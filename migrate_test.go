@@ -0,0 +1,173 @@
+package kvproject
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Write the records to a file without a header, as the versions before the file header did
+func writeFormatV0File(t *testing.T, opts Options, fileName string, records []*data.LogRecord) []*data.LogRecordPos {
+	file, err := opts.FS.OpenFile(filepath.Join(opts.DirPath, fileName), os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	assert.Nil(t, err)
+	defer file.Close()
+
+	var positions []*data.LogRecordPos
+	var offset int64
+	for _, record := range records {
+		encRecord, size := data.EncodeLogRecord(record)
+		_, err := file.Write(encRecord)
+		assert.Nil(t, err)
+		positions = append(positions, &data.LogRecordPos{Offset: offset, Size: uint32(size)})
+		offset += size
+	}
+	return positions
+}
+
+// A directory written by an older version: a merged file with its hint file, and a file after the merge
+func makeFormatV0DB(t *testing.T, opts Options) {
+	err := opts.FS.MkdirAll(opts.DirPath, os.ModePerm)
+	assert.Nil(t, err)
+
+	merged := writeFormatV0File(t, opts, filepath.Base(data.GetDataFileName("", 0)), []*data.LogRecord{
+		{Key: logRecordKeyWithSeq([]byte("a"), nonTransactionSeqNo), Value: []byte("a-merged")},
+		{Key: logRecordKeyWithSeq([]byte("b"), nonTransactionSeqNo), Value: []byte("b-merged")},
+	})
+	var hintRecords []*data.LogRecord
+	for i, key := range []string{"a", "b"} {
+		hintRecords = append(hintRecords, &data.LogRecord{Key: []byte(key), Value: data.EncodeLogRecordPos(merged[i])})
+	}
+	writeFormatV0File(t, opts, data.HintFileName, hintRecords)
+	writeFormatV0File(t, opts, data.MergeFinishedFileName, []*data.LogRecord{
+		{Key: []byte(mergeFinishedKey), Value: []byte("1")},
+	})
+
+	active := writeFormatV0File(t, opts, filepath.Base(data.GetDataFileName("", 1)), []*data.LogRecord{
+		{Key: logRecordKeyWithSeq([]byte("c"), nonTransactionSeqNo), Value: []byte("c-value")},
+		{Key: logRecordKeyWithSeq([]byte("a"), nonTransactionSeqNo), Value: []byte("a-new")},
+		{Key: logRecordKeyWithSeq([]byte("b"), nonTransactionSeqNo), Type: data.LogRecordDeleted},
+	})
+	writeFormatV0File(t, opts, data.SeqNoFileName, []*data.LogRecord{
+		{Key: []byte(seqNoKey), Value: []byte("0")},
+	})
+
+	if opts.IndexType == BPlusTree {
+		// The B+ tree index saved by the older version
+		bptree, err := index.NewBPlusTree(opts.DirPath, false)
+		assert.Nil(t, err)
+		for i, key := range []string{"c", "a"} {
+			active[i].Fid = 1
+			bptree.Put([]byte(key), active[i])
+		}
+		assert.Nil(t, bptree.Close())
+	}
+}
+
+func checkFormatV0DB(t *testing.T, db *DB, more map[string]string) {
+	expected := map[string]string{"a": "a-new", "c": "c-value"}
+	for key, value := range more {
+		expected[key] = value
+	}
+	for key, value := range expected {
+		got, err := db.Get([]byte(key))
+		assert.Nil(t, err, key)
+		assert.Equal(t, value, string(got), key)
+	}
+	_, err := db.Get([]byte("b"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, len(expected), len(db.ListKeys()))
+}
+
+// Every file written by the database starts with a header of the current format
+func checkFileHeaders(t *testing.T, opts Options) {
+	entries, err := opts.FS.ReadDir(opts.DirPath)
+	assert.Nil(t, err)
+	var checked int
+	for _, entry := range entries {
+		name := entry.Name()
+		if name == fileLockName || name == index.BPlusTreeIndexFileName {
+			continue
+		}
+		file, err := opts.FS.OpenFile(filepath.Join(opts.DirPath, name), os.O_RDONLY, 0)
+		assert.Nil(t, err)
+		buf := make([]byte, data.FileHeaderSize)
+		_, err = file.ReadAt(buf, 0)
+		assert.Nil(t, err, name)
+		_ = file.Close()
+		header, err := data.DecodeFileHeader(buf)
+		assert.Nil(t, err, name)
+		if header != nil {
+			assert.Equal(t, data.CurrentFileFormat, header.Version, name)
+			assert.Equal(t, data.DefaultChecksum, header.Checksum, name)
+		}
+		checked++
+	}
+	// Data files, hint, merge finished and seq-no files
+	assert.GreaterOrEqual(t, checked, 4)
+}
+
+func TestDB_OpenFormatV0(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-format-v0")
+	opts.DirPath = dir
+	makeFormatV0DB(t, opts)
+
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	checkFormatV0DB(t, db, nil)
+
+	// Records appended to the old active file are in its format
+	err = db.Put([]byte("d"), []byte("d-value"))
+	assert.Nil(t, err)
+	assert.Nil(t, db.activeFile.Header)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	checkFormatV0DB(t, db2, map[string]string{"d": "d-value"})
+}
+
+func TestMigrate(t *testing.T) {
+	for _, indexType := range []IndexerType{Btree, BPlusTree} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-migrate")
+		opts.DirPath = dir
+		opts.IndexType = indexType
+		makeFormatV0DB(t, opts)
+
+		db, err := Open(opts)
+		assert.Nil(t, err)
+		err = db.Put([]byte("d"), []byte("d-value"))
+		assert.Nil(t, err)
+		err = db.Close()
+		assert.Nil(t, err)
+
+		err = Migrate(opts)
+		assert.Nil(t, err)
+		checkFileHeaders(t, opts)
+
+		db2, err := Open(opts)
+		assert.Nil(t, err)
+		checkFormatV0DB(t, db2, map[string]string{"d": "d-value"})
+		err = db2.Put([]byte("e"), []byte("e-value"))
+		assert.Nil(t, err)
+		err = db2.Close()
+		assert.Nil(t, err)
+
+		// Migrating again changes nothing
+		err = Migrate(opts)
+		assert.Nil(t, err)
+		checkFileHeaders(t, opts)
+		db3, err := Open(opts)
+		assert.Nil(t, err)
+		checkFormatV0DB(t, db3, map[string]string{"d": "d-value", "e": "e-value"})
+		destroyDB(db3)
+	}
+}
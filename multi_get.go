@@ -0,0 +1,137 @@
+package kvproject
+
+import (
+	"bitcask-go/data"
+	"sort"
+	"sync"
+)
+
+// A key to read in MultiGet
+type multiGetRead struct {
+	index int                  // Place of the key in the keys
+	pos *data.LogRecordPos
+}
+
+// Get the values of keys
+// values[i] and found[i] are the result of keys[i], found[i] is false if keys[i] doesn't exist
+// Reads are sorted by file and offset, records close to each other are read together
+func (db *DB) MultiGet(keys [][]byte) ([][]byte, []bool, error) {
+	for _, key := range keys {
+		if len(key) == 0 {
+			return nil, nil, ErrKeyIsEmpty
+		}
+	}
+
+	values := make([][]byte, len(keys))
+	found := make([]bool, len(keys))
+
+	// Same locks as Get, with a concurrent index the keys are not read at one point of the writes
+	if db.concurrentGet {
+		db.filesMu.RLock()
+		defer db.filesMu.RUnlock()
+	} else {
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+	}
+
+	// Group the positions by data file
+	reads := make(map[uint32][]multiGetRead)
+	for i, key := range keys {
+		pos := db.index.Get(key)
+		if pos == nil {
+			continue
+		}
+		reads[pos.Fid] = append(reads[pos.Fid], multiGetRead{index: i, pos: pos})
+	}
+	fids := make([]uint32, 0, len(reads))
+	for fid := range reads {
+		fids = append(fids, fid)
+	}
+	sort.Slice(fids, func(i, j int) bool {
+		return fids[i] < fids[j]
+	})
+
+	// Read all the keys in one data file
+	// Different files write different places of values and found, so it needs no lock
+	readFile := func(fid uint32) error {
+		dataFile := db.getDataFile(fid)
+		if dataFile == nil {
+			return ErrDataFileNotFound
+		}
+
+		fileReads := reads[fid]
+		sort.Slice(fileReads, func(i, j int) bool {
+			return fileReads[i].pos.Offset < fileReads[j].pos.Offset
+		})
+		positions := make([]*data.LogRecordPos, len(fileReads))
+		for i, read := range fileReads {
+			positions[i] = read.pos
+		}
+
+		records, err := dataFile.ReadLogRecords(positions)
+		if err != nil {
+			return err
+		}
+		for i, logRecord := range records {
+			index := fileReads[i].index
+			switch logRecord.Type {
+			case data.LogRecordDeleted:
+				continue
+			case data.LogRecordMergeOperand:
+				value, err := db.resolveMergeOperands(logRecord)
+				if err != nil {
+					return err
+				}
+				values[index] = value
+			default:
+				values[index] = logRecord.Value
+			}
+			found[index] = true
+		}
+		return nil
+	}
+
+	workers := db.options.MultiGetWorkers
+	if workers > len(fids) {
+		workers = len(fids)
+	}
+	if workers <= 1 {
+		for _, fid := range fids {
+			if err := readFile(fid); err != nil {
+				return nil, nil, err
+			}
+		}
+		return values, found, nil
+	}
+
+	// Read different data files in parallel
+	fidCh := make(chan uint32, len(fids))
+	for _, fid := range fids {
+		fidCh <- fid
+	}
+	close(fidCh)
+
+	var firstErr error
+	var errOnce sync.Once
+	wg := new(sync.WaitGroup)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fid := range fidCh {
+				if err := readFile(fid); err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+					})
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, nil, firstErr
+	}
+	return values, found, nil
+}
@@ -0,0 +1,147 @@
+package kvproject
+
+import (
+	"bitcask-go/utils"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_MultiGet(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-multi-get")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.MergeOperator = appendOperator{}
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1. Database is empty
+	values, found, err := db.MultiGet([][]byte{utils.GetTestKey(1)})
+	assert.Nil(t, err)
+	assert.Nil(t, values[0])
+	assert.False(t, found[0])
+
+	// Data are in more than one data files
+	expected := make(map[string][]byte)
+	for i := 0; i < 2000; i++ {
+		value := utils.RandomValue(128)
+		err := db.Put(utils.GetTestKey(i), value)
+		assert.Nil(t, err)
+		expected[string(utils.GetTestKey(i))] = value
+	}
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		delete(expected, string(utils.GetTestKey(i)))
+	}
+	err = db.MergeValue(utils.GetTestKey(500), []byte("operand"))
+	assert.Nil(t, err)
+	expected[string(utils.GetTestKey(500))] = append(expected[string(utils.GetTestKey(500))], []byte(",operand")...)
+
+	// 2. Keys are not in order, some don't exist, some are duplicate
+	var keys [][]byte
+	for i := 2100; i >= 0; i -= 7 {
+		keys = append(keys, utils.GetTestKey(i))
+	}
+	keys = append(keys, utils.GetTestKey(500), utils.GetTestKey(1999), utils.GetTestKey(1999))
+
+	check := func() {
+		values, found, err := db.MultiGet(keys)
+		assert.Nil(t, err)
+		assert.Equal(t, len(keys), len(values))
+		assert.Equal(t, len(keys), len(found))
+		for i, key := range keys {
+			value, ok := expected[string(key)]
+			assert.Equal(t, ok, found[i])
+			assert.Equal(t, value, values[i])
+		}
+	}
+	check()
+
+	// 3. Read in the calling goroutine
+	db.options.MultiGetWorkers = 0
+	check()
+
+	// 4. Key is empty
+	_, _, err = db.MultiGet([][]byte{utils.GetTestKey(1), nil})
+	assert.Equal(t, ErrKeyIsEmpty, err)
+}
+
+// With a concurrent index MultiGet reads while the writes go on, like Get
+func TestDB_MultiGet_Concurrent(t *testing.T) {
+	for _, indexType := range []IndexerType{Skiplist, Hash} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-multi-get-concurrent")
+		opts.DirPath = dir
+		opts.DataFileSize = 32 * 1024
+		opts.DataFileMergeRatio = 0
+		opts.IndexType = indexType
+		opts.MultiGetWorkers = 4
+		db, err := Open(opts)
+		assert.Nil(t, err)
+		keys := make([][]byte, 500)
+		for i := range keys {
+			keys[i] = utils.GetTestKey(i)
+			err := db.Put(keys[i], []byte(fmt.Sprintf("value-%d", i)))
+			assert.Nil(t, err)
+		}
+
+		// The lock of the writes is not taken
+		db.mu.Lock()
+		result := make(chan error)
+		go func() {
+			_, _, err := db.MultiGet(keys[:10])
+			result <- err
+		}()
+		select {
+		case err := <-result:
+			assert.Nil(t, err)
+		case <-time.After(10 * time.Second):
+			db.mu.Unlock()
+			t.Fatal("MultiGet waits for the lock of the writes")
+		}
+		db.mu.Unlock()
+
+		done := make(chan struct{})
+		wg := new(sync.WaitGroup)
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					values, found, err := db.MultiGet(keys)
+					if !assert.Nil(t, err) {
+						return
+					}
+					for i := range keys {
+						assert.True(t, found[i])
+						assert.Equal(t, []byte(fmt.Sprintf("value-%d", i)), values[i])
+					}
+				}
+			}()
+		}
+		for round := 0; round < 5; round++ {
+			for i := range keys {
+				err := db.Put(keys[i], []byte(fmt.Sprintf("value-%d", i)))
+				assert.Nil(t, err)
+			}
+			err = db.Merge()
+			assert.Nil(t, err)
+		}
+		close(done)
+		wg.Wait()
+		destroyDB(db)
+	}
+}
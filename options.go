@@ -1,12 +1,20 @@
 package kvproject
 
-import "os"
+import (
+	"bitcask-go/fio"
+	"os"
+)
 
 // Some parameters given by user
 type Options struct {
 	// The place of written files
 	DirPath string 
 
+	// File system where the files are stored, fio.OSFS by default
+	// fio.NewMemFS() keeps all the data in memory, memory map and O_DIRECT are not used with it
+	// The B+ tree index always stores its file on the disk of the OS, the Hybrid index needs fio.OSFS
+	FS fio.FS
+
 	// The limit of active file
 	DataFileSize int64
 
@@ -19,13 +27,52 @@ type Options struct {
 	// Index type
 	IndexType IndexerType
 
+	// Bytes of memory the Hybrid index keeps the keys in, the other keys are on the disk
+	IndexMemoryLimit int64
+
 	// Use mmap when start up?
 	MMapAtStartUp bool
 
+	// IO type of the active file
+	ActiveFileIOType FileIOType
+
 	// Threshold for data file merging
 	DataFileMergeRatio float32
+
+	// Number of goroutines reading different data files in MultiGet. 0 or 1 means reading in the calling goroutine
+	MultiGetWorkers int
+
+	// Combine the operands written by MergeValue with the value. Nil by default, MergeValue cannot be used
+	MergeOperator MergeOperator
 }
 
+// Combine the existing value of a key with the operands written by MergeValue
+type MergeOperator interface {
+	// existing is nil if the key has no value before the operands
+	// operands are in the order they were written
+	Merge(key []byte, existing []byte, operands [][]byte) ([]byte, error)
+}
+
+type FileIOType = fio.FileIOType
+const (
+	// Write the active file by write(2)
+	StandardIO = fio.StandardFIO
+
+	// Write the active file by copying into a memory mapping, the file is pre-allocated to DataFileSize
+	MMapIO = fio.WritableMemoryMap
+
+	// Collect the writes in a buffer, write(2) is called when the buffer is full or when syncing
+	// Unless SyncWrite is set, the data in the buffer is lost if the process crashes
+	BufferedIO = fio.BufferedFIO
+
+	// Pre-allocate the active file to DataFileSize by fallocate, so appending doesn't update the size of the file
+	PreallocatedIO = fio.PreallocatedFIO
+
+	// Same as PreallocatedIO, and the active file is opened with O_DIRECT, the page cache is not used
+	// Every write goes to the disk and rewrites the last block which isn't full, so it is much slower without batching
+	DirectIO = fio.DirectFIO
+)
+
 type IndexerType = int8
 const (
 	// BTree index
@@ -36,16 +83,31 @@ const (
 
 	// B+ tree, store indexes on the disk
 	BPlusTree
+
+	// Btree in memory up to IndexMemoryLimit, the least recently written keys are spilled to a B+ tree on the disk
+	Hybrid
+
+	// Skiplist in memory, reads don't wait for writes
+	// With StandardIO for the active file, Get doesn't wait for the writes of the database either
+	Skiplist
+
+	// Hash table in memory, for point lookups. Iterating sorts all the keys first, it is slow
+	// With StandardIO for the active file, Get doesn't wait for the writes of the database
+	Hash
 )
 
 var DefaultOptions = Options{
 	DirPath: os.TempDir(),
+	FS: fio.OSFS,
 	DataFileSize: 256 * 1024 *1024,  //256MB
 	SyncWrite: false,
 	BytesPerSync: 0,
 	IndexType: Btree,
+	IndexMemoryLimit: 256 * 1024 * 1024, //256MB
 	MMapAtStartUp: true,
+	ActiveFileIOType: StandardIO,
 	DataFileMergeRatio: 0.5,
+	MultiGetWorkers: 4,
 }
 
 // Options of iterator
@@ -55,11 +117,23 @@ type IteratorOptions struct {
 
 	// Reversed? Default is false
 	Reverse bool
+
+	// Only traverse the keys bigger than or equal to LowerBound. Empty by default.
+	LowerBound []byte
+
+	// Only traverse the keys smaller than UpperBound. Empty by default.
+	UpperBound []byte
+
+	// The maximum number of keys to traverse after Rewind or Seek. 0 means no limit.
+	Limit int
 }
 
 var DefaultIteratorOptions = IteratorOptions{
 	Prefix: nil,
 	Reverse: false,
+	LowerBound: nil,
+	UpperBound: nil,
+	Limit: 0,
 }
 
 // Options for batch writing
@@ -35,7 +35,10 @@ func (rds *RedisDataStructure) findMetadata(key []byte, dataType redisDataType)
 		// Need to be initialized
 		exist = false
 	} else {
-		meta = decodeMetadata(metaBuf)
+		meta, err = decodeMetadata(metaBuf)
+		if err != nil {
+			return nil, err
+		}
 		// Judge data type
 		if meta.dataType != dataType {
 			return nil, ErrWrongTypeOperation
@@ -46,23 +46,40 @@ func (md *metaData) encode() []byte {
 	return buf[:index]
 }
 
-func decodeMetadata(buf []byte) *metaData {
+// Decode the metadata, a corrupted one returns ErrInvalidMetadata instead of panicking
+func decodeMetadata(buf []byte) (*metaData, error) {
+	if len(buf) == 0 {
+		return nil, ErrInvalidMetadata
+	}
 	dataType := buf[0]
 
 	var index = 1
-	expire, n := binary.Varint(buf[index:])
-	index += n
-	version, n := binary.Varint(buf[index:])
-	index += n
-	size, n := binary.Varint(buf[index:])
-	index += n
+	var values [3]int64
+	for i := range values {
+		value, n := binary.Varint(buf[index:])
+		if n <= 0 {
+			return nil, ErrInvalidMetadata
+		}
+		values[i] = value
+		index += n
+	}
+	expire, version, size := values[0], values[1], values[2]
+	if size < 0 || size > math.MaxUint32 {
+		return nil, ErrInvalidMetadata
+	}
 
 	var head uint64 = 0
 	var tail uint64 = 0
 	if dataType == List {
+		var n int
 		head, n = binary.Uvarint(buf[index:])
+		if n <= 0 {
+			return nil, ErrInvalidMetadata
+		}
 		index += n
-		tail, _ = binary.Uvarint(buf[index:])
+		if tail, n = binary.Uvarint(buf[index:]); n <= 0 {
+			return nil, ErrInvalidMetadata
+		}
 	}
 
 	return &metaData{
@@ -72,7 +89,7 @@ func decodeMetadata(buf []byte) *metaData {
 		size: uint32(size),
 		head: head,
 		tail: tail,
-	}
+	}, nil
 }
 
 type hashInternalKey struct {
@@ -0,0 +1,51 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeMetadata(t *testing.T) {
+	hashMeta := &metaData{dataType: Hash, expire: time.Now().UnixNano(), version: time.Now().UnixNano(), size: 10}
+	decoded, err := decodeMetadata(hashMeta.encode())
+	assert.Nil(t, err)
+	assert.Equal(t, hashMeta, decoded)
+
+	listMeta := &metaData{dataType: List, version: 1, size: 2, head: initialListMark - 1, tail: initialListMark + 1}
+	decoded, err = decodeMetadata(listMeta.encode())
+	assert.Nil(t, err)
+	assert.Equal(t, listMeta, decoded)
+
+	for _, buf := range [][]byte{
+		nil,
+		{Hash},
+		{Hash, 0, 0},                 // Size is missing
+		{Hash, 0, 0, 1},              // Negative size
+		{Hash, 0, 0, 0xff},           // Size ends in the middle
+		{List, 0, 0, 2, 0x80},        // Head ends in the middle
+		{List, 0, 0, 2, 1},           // Tail is missing
+	} {
+		_, err := decodeMetadata(buf)
+		assert.Equal(t, ErrInvalidMetadata, err, buf)
+	}
+}
+
+func FuzzDecodeMetadata(f *testing.F) {
+	f.Add((&metaData{dataType: Hash, expire: 100, version: 200, size: 3}).encode())
+	f.Add((&metaData{dataType: List, version: 200, size: 3, head: initialListMark, tail: initialListMark + 3}).encode())
+	f.Add([]byte{List, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01})
+
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		meta, err := decodeMetadata(buf)
+		if err != nil {
+			assert.Equal(t, ErrInvalidMetadata, err)
+			return
+		}
+		// Encoding it again gives the same metadata
+		decoded, err := decodeMetadata(meta.encode())
+		assert.Nil(t, err)
+		assert.Equal(t, meta, decoded)
+	})
+}
@@ -12,6 +12,7 @@ import (
 
 var (
 	ErrWrongTypeOperation = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	ErrInvalidMetadata = errors.New("invalid metadata, the value of the key may be corrupted")
 )
 
 type RedisDataStructure struct {
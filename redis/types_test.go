@@ -2,6 +2,7 @@ package redis
 
 import (
 	kvproject "bitcask-go"
+	"bitcask-go/fio"
 	"bitcask-go/utils"
 	"os"
 	"testing"
@@ -10,6 +11,13 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+func init() {
+	// BITCASK_TEST_FS=mem runs the tests in memory
+	if os.Getenv("BITCASK_TEST_FS") == "mem" {
+		kvproject.DefaultOptions.FS = fio.NewMemFS()
+	}
+}
+
 func TestRedisDataStructure_Del_Type(t *testing.T) {
 	opts := kvproject.DefaultOptions
 	dir, _ := os.MkdirTemp("", "bitcask-go-redis-get")
@@ -0,0 +1,16 @@
+package utils
+
+// Get the smallest key which is bigger than all the keys with the prefix
+// eg: "abc" -> "abd", "ab\xff" -> "ac"
+// If prefix is empty or all 0xff, there is no such key, return nil
+func PrefixEnd(prefix []byte) []byte {
+	end := make([]byte, len(prefix))
+	copy(end, prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefixEnd(t *testing.T) {
+	assert.Equal(t, []byte("abd"), PrefixEnd([]byte("abc")))
+	assert.Equal(t, []byte("ac"), PrefixEnd([]byte("ab\xff")))
+	assert.Equal(t, []byte{0x01}, PrefixEnd([]byte{0x00, 0xff, 0xff}))
+	assert.Nil(t, PrefixEnd([]byte{0xff, 0xff}))
+	assert.Nil(t, PrefixEnd(nil))
+}
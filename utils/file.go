@@ -1,75 +1,111 @@
 package utils
 
 import (
-	"io/fs"
+	"bitcask-go/fio"
+	"io"
 	"os"
 	"path/filepath"
-	"strings"
-	"syscall"
 )
 
-func DirSize(dirPath string) (int64, error) {
+// Total size of the files in dirPath
+func DirSize(fs fio.FS, dirPath string) (int64, error) {
+	entries, err := fs.ReadDir(dirPath)
+	if err != nil {
+		return 0, err
+	}
 	var size int64
-	err := filepath.Walk(dirPath, func(path string, info fs.FileInfo, err error) error {
-		if err != nil {
-			return err
+	for _, entry := range entries {
+		if entry.IsDir() {
+			dirSize, err := DirSize(fs, filepath.Join(dirPath, entry.Name()))
+			if err != nil {
+				return 0, err
+			}
+			size += dirSize
+			continue
 		}
-		if !info.IsDir() {
-			size += info.Size()
+		info, err := entry.Info()
+		if err != nil {
+			return 0, err
 		}
-		return nil
-	})
-	return size, err
+		size += info.Size()
+	}
+	return size, nil
 }
 
 // Get the remaining available space of the disk
 func AvailableDiskSize() (uint64, error) {
-	wd, err := syscall.Getwd()
+	wd, err := os.Getwd()
 	if err != nil {
 		return 0, err
 	}
-	var stat syscall.Statfs_t
-	if err = syscall.Statfs(wd, &stat); err != nil {
-		return 0, err
-	}
-	return stat.Bavail * uint64(stat.Bsize), nil
+	return fio.OSFS.DiskFree(wd)
 }
 
-// Copy data directory, exclude contains files need not to be copied
-func CopyDic(src, dest string, exclude []string) error {
+// Copy data directory in fs, exclude contains files need not to be copied
+func CopyDic(fs fio.FS, src, dest string, exclude []string) error {
 	// If dest doesn't exist, create
-	if _, err := os.Stat(dest); os.IsNotExist(err) {
-		if err = os.MkdirAll(dest, os.ModePerm); err != nil {
+	if _, err := fs.Stat(dest); os.IsNotExist(err) {
+		if err = fs.MkdirAll(dest, os.ModePerm); err != nil {
 			return err
 		}
 	}
 
-	return filepath.Walk(src, func(path string, info fs.FileInfo, err error) error {
-		// tmp/database/01.data -> 01.data
-		fileName := strings.Replace(path, src, "", 1)
-		if fileName == "" {
-			return nil
-		}
-
+	entries, err := fs.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		excluded := false
 		for _, e := range exclude {
-			matched, err := filepath.Match(e, info.Name())
+			matched, err := filepath.Match(e, entry.Name())
 			if err != nil {
 				return err
 			}
 			if matched {
-				return nil
+				excluded = true
+				break
 			}
 		}
+		if excluded {
+			continue
+		}
 
-		if info.IsDir() {
-			return os.MkdirAll(filepath.Join(dest, fileName), info.Mode())
+		// tmp/database/01.data -> dest/01.data
+		srcPath := filepath.Join(src, entry.Name())
+		destPath := filepath.Join(dest, entry.Name())
+		if entry.IsDir() {
+			if err := CopyDic(fs, srcPath, destPath, exclude); err != nil {
+				return err
+			}
+			continue
 		}
 
 		// A normal data file
-		data, err := os.ReadFile(filepath.Join(src, fileName))
+		info, err := entry.Info()
 		if err != nil {
 			return err
 		}
-		return os.WriteFile(filepath.Join(dest, fileName), data, info.Mode())
-	})
-}
\ No newline at end of file
+		if err := copyFile(fs, srcPath, destPath, info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(fs fio.FS, src, dest string, perm os.FileMode) error {
+	srcFile, err := fs.OpenFile(src, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	destFile, err := fs.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(destFile, io.NewSectionReader(srcFile, 0, 1 << 62)); err != nil {
+		_ = destFile.Close()
+		return err
+	}
+	return destFile.Close()
+}
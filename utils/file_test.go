@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"bitcask-go/fio"
 	"os"
 	"path/filepath"
 	"testing"
@@ -10,7 +11,7 @@ import (
 
 func TestDirSize(t *testing.T) {
 	dir, _ := os.Getwd()
-	dirSize, err := DirSize(filepath.Join(dir))
+	dirSize, err := DirSize(fio.OSFS, filepath.Join(dir))
 	assert.Nil(t, err)
 	t.Log(dirSize)
 }
@@ -20,4 +21,32 @@ func TestAvailableDiskSize(t *testing.T) {
 	assert.Nil(t, err)
 	t.Log(size)
 	assert.True(t, size > 0)
-}
\ No newline at end of file
+}
+
+func TestCopyDic(t *testing.T) {
+	fs := fio.NewMemFS()
+	err := fs.MkdirAll("/src/sub", os.ModePerm)
+	assert.Nil(t, err)
+	for _, name := range []string{"/src/a.data", "/src/sub/b.data", "/src/flock"} {
+		file, err := fs.OpenFile(name, os.O_CREATE|os.O_RDWR, fio.DataFilePerm)
+		assert.Nil(t, err)
+		_, err = file.Write([]byte(name))
+		assert.Nil(t, err)
+		_ = file.Close()
+	}
+
+	err = CopyDic(fs, "/src", "/dest", []string{"flock"})
+	assert.Nil(t, err)
+
+	size, err := DirSize(fs, "/dest")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len("/src/a.data") + len("/src/sub/b.data")), size)
+	_, err = fs.Stat("/dest/flock")
+	assert.True(t, os.IsNotExist(err))
+	file, err := fs.OpenFile("/dest/sub/b.data", os.O_RDONLY, 0)
+	assert.Nil(t, err)
+	b := make([]byte, len("/src/sub/b.data"))
+	_, err = file.ReadAt(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "/src/sub/b.data", string(b))
+}